@@ -6,8 +6,9 @@ import (
 	"strconv"
 )
 
-// MinimumSpanningTree generates a minimum spanning tree for a fully connected graph with `totalNodes` nodes
-func MinimumSpanningTree(totalNodes int) map[string][]string {
+// MinimumSpanningTree generates a minimum spanning tree for a fully connected graph with `totalNodes` nodes,
+// drawing the edge weights from rng
+func MinimumSpanningTree(totalNodes int, rng *rand.Rand) map[string][]string {
 	// Generate node names
 	nodes := make([]string, totalNodes)
 	for i := 0; i < totalNodes; i++ {
@@ -20,7 +21,7 @@ func MinimumSpanningTree(totalNodes int) map[string][]string {
 		adjMatrix[i] = make([]int, totalNodes)
 		for j := range adjMatrix[i] {
 			if i != j {
-				adjMatrix[i][j] = rand.Intn(100) + 1 // Random weight between 1 and 100
+				adjMatrix[i][j] = rng.Intn(100) + 1 // Random weight between 1 and 100
 			} else {
 				adjMatrix[i][j] = 0 // No self-loops
 			}
@@ -0,0 +1,246 @@
+package mst
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Constraints bound the shape of a rooted tree, 0 leaves a bound off. Depth is counted in hops from the root
+// and degree includes the edge to the parent, so the root may have one child more than the others. A small
+// depth keeps the slowest delivery short, a small degree keeps any node from relaying for too many others.
+type Constraints struct {
+	MaxDepth  int
+	MaxDegree int
+}
+
+func (c Constraints) validate() error {
+	if c.MaxDepth == 0 && c.MaxDegree == 0 {
+		return errors.New("a tree needs a maximum depth, a maximum degree or both")
+	}
+	return nil
+}
+
+// fanout returns the children of the root and of every other node. Every node gets the fewest children that
+// fit n nodes within MaxDepth, or the most MaxDegree allows when the depth is unbounded. When none fit, the
+// root takes the extra child it has room for without a parent edge.
+func (c Constraints) fanout(n int) (root, k int, err error) {
+	if err := c.validate(); err != nil {
+		return 0, 0, err
+	}
+
+	limit := n - 1
+	if c.MaxDegree > 0 {
+		limit = min(limit, c.MaxDegree-1)
+	}
+	if n <= 1 {
+		return 1, 1, nil
+	}
+	if c.MaxDepth == 0 && limit >= 1 {
+		return limit, limit, nil
+	}
+
+	depth := c.MaxDepth
+	if depth == 0 {
+		depth = n
+	}
+	for k := 1; k <= limit; k++ {
+		if capacity(k, k, depth) >= n {
+			return k, k, nil
+		}
+	}
+	if c.MaxDegree > 0 && capacity(c.MaxDegree, c.MaxDegree-1, depth) >= n {
+		return c.MaxDegree, c.MaxDegree - 1, nil
+	}
+	return 0, 0, fmt.Errorf("%d nodes do not fit a tree of %s", n, c)
+}
+
+func (c Constraints) String() string {
+	var bounds []string
+	if c.MaxDepth > 0 {
+		bounds = append(bounds, fmt.Sprintf("depth %d", c.MaxDepth))
+	}
+	if c.MaxDegree > 0 {
+		bounds = append(bounds, fmt.Sprintf("degree %d", c.MaxDegree))
+	}
+	return strings.Join(bounds, " and ")
+}
+
+// capacity returns the number of nodes of a full tree of the given depth, with root children at the root and
+// k children at every other node.
+func capacity(root, k, depth int) int {
+	total, level := 1, root
+	for d := 0; d < depth && level > 0 && total < math.MaxInt32; d++ {
+		total += level
+		level *= k
+	}
+	return total
+}
+
+// TreeStats describes a tree rooted at Root.
+type TreeStats struct {
+	Root      string
+	Depth     int
+	Degrees   map[string]int
+	MaxDegree int
+	// MeanHops is the expected number of hops between two distinct nodes picked at random
+	MeanHops float64
+}
+
+// Measure returns the stats of the tree t rooted at root.
+func Measure(t map[string][]string, root string) TreeStats {
+	stats := TreeStats{Root: root, Degrees: make(map[string]int, len(t))}
+
+	var pairs, hops int
+	for _, src := range SortedNodes(t) {
+		stats.Degrees[src] = len(t[src])
+		stats.MaxDegree = max(stats.MaxDegree, len(t[src]))
+
+		for n, d := range distances(t, src) {
+			if n != src {
+				pairs++
+				hops += d
+			}
+			if src == root {
+				stats.Depth = max(stats.Depth, d)
+			}
+		}
+	}
+
+	if pairs > 0 {
+		stats.MeanHops = float64(hops) / float64(pairs)
+	}
+	return stats
+}
+
+// distances returns the hops from src to every node it reaches.
+func distances(t map[string][]string, src string) map[string]int {
+	dist := map[string]int{src: 0}
+	queue := []string{src}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		for _, next := range t[n] {
+			if _, ok := dist[next]; !ok {
+				dist[next] = dist[n] + 1
+				queue = append(queue, next)
+			}
+		}
+	}
+	return dist
+}
+
+// BalancedTree returns a tree rooted at the first node where every node has the same number of children, the
+// fewest that fit within c.MaxDepth, filled level by level in the order of nodes. The root has one child more
+// when only that fits within c.MaxDegree.
+func BalancedTree(nodes []string, c Constraints) (map[string][]string, TreeStats, error) {
+	root, k, err := c.fanout(len(nodes))
+	if err != nil {
+		return nil, TreeStats{}, err
+	}
+
+	t := newTopology(nodes)
+	for i := 1; i < len(nodes); i++ {
+		// The first root nodes after it are the root's children, the rest go k to a parent
+		parent := 0
+		if i > root {
+			parent = 1 + (i-1-root)/k
+		}
+		t.connect(nodes[parent], nodes[i])
+	}
+	return t, measureRooted(t, nodes), nil
+}
+
+// BoundedMST grows a minimum spanning tree from the first node with Prim's algorithm, but only attaches nodes
+// where the depth and degree stay within c. The greedy choice can use up every open place before all nodes
+// are attached, the tree is then BalancedTree, which fits whenever any tree does.
+func BoundedMST(nodes []string, weight Weight, c Constraints) (map[string][]string, TreeStats, error) {
+	if _, _, err := c.fanout(len(nodes)); err != nil {
+		return nil, TreeStats{}, err
+	}
+
+	t := newTopology(nodes)
+	if len(nodes) == 0 {
+		return t, TreeStats{}, nil
+	}
+
+	inTree := make([]bool, len(nodes))
+	depth := make([]int, len(nodes))
+	open := func(u int) bool {
+		return (c.MaxDepth == 0 || depth[u] < c.MaxDepth) && (c.MaxDegree == 0 || len(t[nodes[u]]) < c.MaxDegree)
+	}
+
+	inTree[0] = true
+	for attached := 1; attached < len(nodes); attached++ {
+		from, to := -1, -1
+		var best time.Duration
+		for u := range nodes {
+			if !inTree[u] || !open(u) {
+				continue
+			}
+			for v := range nodes {
+				if w := weight(nodes[u], nodes[v]); !inTree[v] && (from < 0 || w < best) {
+					from, to, best = u, v, w
+				}
+			}
+		}
+		if from < 0 {
+			return BalancedTree(nodes, c)
+		}
+
+		inTree[to] = true
+		depth[to] = depth[from] + 1
+		t.connect(nodes[from], nodes[to])
+	}
+	return t, measureRooted(t, nodes), nil
+}
+
+func measureRooted(t map[string][]string, nodes []string) TreeStats {
+	if len(nodes) == 0 {
+		return TreeStats{}
+	}
+	return Measure(t, nodes[0])
+}
+
+// randomWeights draws a weight for every pair of nodes, like MinimumSpanningTree.
+func randomWeights(nodes []string, rng *rand.Rand) Weight {
+	weights := make(map[[2]string]time.Duration)
+	for i, a := range nodes {
+		for _, b := range nodes[i+1:] {
+			weights[[2]string{a, b}] = time.Duration(rng.Intn(100) + 1)
+		}
+	}
+	return func(a, b string) time.Duration {
+		if w, ok := weights[[2]string{a, b}]; ok {
+			return w
+		}
+		return weights[[2]string{b, a}]
+	}
+}
+
+// balanced is BalancedTree as a generator. Nodes that do not fit the constraints are left without edges, so
+// Validate reports the topology as disconnected.
+func balanced(c Constraints) Generator {
+	return func(nodes []string, _ *rand.Rand) map[string][]string {
+		t, _, err := BalancedTree(nodes, c)
+		if err != nil {
+			return newTopology(nodes)
+		}
+		return t
+	}
+}
+
+// bounded is BoundedMST over random weights as a generator, with the same handling of constraints that do not
+// fit as balanced.
+func bounded(c Constraints) Generator {
+	return func(nodes []string, rng *rand.Rand) map[string][]string {
+		t, _, err := BoundedMST(nodes, randomWeights(nodes, rng), c)
+		if err != nil {
+			return newTopology(nodes)
+		}
+		return t
+	}
+}
@@ -0,0 +1,67 @@
+package mst
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestBalancedTree(t *testing.T) {
+	tests := []struct {
+		nodes int
+		c     Constraints
+		depth int
+		ok    bool
+	}{
+		// The root has no parent edge, so it can take a child even with a degree of 1
+		{2, Constraints{MaxDegree: 1}, 1, true},
+		{3, Constraints{MaxDegree: 1}, 0, false},
+		{3, Constraints{MaxDegree: 2}, 2, true},
+		{7, Constraints{MaxDepth: 2, MaxDegree: 3}, 2, true},
+		{10, Constraints{MaxDepth: 2, MaxDegree: 3}, 2, true},
+		{11, Constraints{MaxDepth: 2, MaxDegree: 3}, 0, false},
+		{10, Constraints{MaxDepth: 1}, 1, true},
+	}
+	for _, tt := range tests {
+		nodes := nodeIDs(tt.nodes)
+		tree, stats, err := BalancedTree(nodes, tt.c)
+		if !tt.ok {
+			if err == nil {
+				t.Errorf("%d nodes fit a tree of %s", tt.nodes, tt.c)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%d nodes, %s: %v", tt.nodes, tt.c, err)
+		}
+
+		if _, err := Validate(tree); err != nil {
+			t.Fatalf("%d nodes, %s: %v", tt.nodes, tt.c, err)
+		}
+		if stats.Depth != tt.depth {
+			t.Errorf("%d nodes, %s: depth %d, want %d", tt.nodes, tt.c, stats.Depth, tt.depth)
+		}
+		if tt.c.MaxDegree > 0 && stats.MaxDegree > tt.c.MaxDegree {
+			t.Errorf("%d nodes, %s: degree %d", tt.nodes, tt.c, stats.MaxDegree)
+		}
+	}
+}
+
+func TestBoundedMST(t *testing.T) {
+	for _, c := range []Constraints{{MaxDegree: 1}, {MaxDepth: 2, MaxDegree: 3}, {MaxDepth: 3}, {MaxDegree: 2}} {
+		n := 10
+		if c.MaxDegree == 1 {
+			n = 2
+		}
+		nodes := nodeIDs(n)
+		tree, stats, err := BoundedMST(nodes, randomWeights(nodes, rand.New(rand.NewSource(1))), c)
+		if err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+		if _, err := Validate(tree); err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+		if (c.MaxDepth > 0 && stats.Depth > c.MaxDepth) || (c.MaxDegree > 0 && stats.MaxDegree > c.MaxDegree) {
+			t.Errorf("%s: depth %d and degree %d", c, stats.Depth, stats.MaxDegree)
+		}
+	}
+}
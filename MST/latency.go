@@ -0,0 +1,133 @@
+package mst
+
+import (
+	"math"
+	"time"
+)
+
+// Weight returns the cost of the edge between a and b, such as their round trip time.
+type Weight func(a, b string) time.Duration
+
+// MinimumSpanningTreeBy returns the spanning tree of the complete graph over nodes with the least total
+// weight, built with Prim's algorithm. Ties go to the node earlier in nodes, so the same weights always give
+// the same tree.
+func MinimumSpanningTreeBy(nodes []string, weight Weight) map[string][]string {
+	t := newTopology(nodes)
+	if len(nodes) == 0 {
+		return t
+	}
+
+	inTree := make([]bool, len(nodes))
+	key := make([]time.Duration, len(nodes))
+	parent := make([]int, len(nodes))
+	for i := range key {
+		key[i] = math.MaxInt64
+		parent[i] = -1
+	}
+
+	key[0] = 0
+	for range nodes {
+		u := -1
+		for v := range nodes {
+			if !inTree[v] && (u < 0 || key[v] < key[u]) {
+				u = v
+			}
+		}
+		inTree[u] = true
+		if parent[u] >= 0 {
+			t.connect(nodes[parent[u]], nodes[u])
+		}
+
+		for v := range nodes {
+			if w := weight(nodes[u], nodes[v]); !inTree[v] && w < key[v] {
+				key[v], parent[v] = w, u
+			}
+		}
+	}
+	return t
+}
+
+// MinimumDiameterTreeBy returns the shortest path tree, over the complete graph, rooted at the node that
+// gives the tree the smallest diameter. With round trip times as weights this bounds the slowest path between
+// two nodes, at the cost of a root with many neighbors.
+func MinimumDiameterTreeBy(nodes []string, weight Weight) map[string][]string {
+	var best map[string][]string
+	bestDiameter := time.Duration(math.MaxInt64)
+	for root := range nodes {
+		t := shortestPathTree(nodes, root, weight)
+		if d := Diameter(t, weight); d < bestDiameter {
+			best, bestDiameter = t, d
+		}
+	}
+	if best == nil {
+		return newTopology(nodes)
+	}
+	return best
+}
+
+// shortestPathTree runs Dijkstra's algorithm from nodes[root] over the complete graph.
+func shortestPathTree(nodes []string, root int, weight Weight) map[string][]string {
+	t := newTopology(nodes)
+	done := make([]bool, len(nodes))
+	dist := make([]time.Duration, len(nodes))
+	parent := make([]int, len(nodes))
+	for i := range dist {
+		dist[i] = math.MaxInt64
+		parent[i] = -1
+	}
+
+	dist[root] = 0
+	for range nodes {
+		u := -1
+		for v := range nodes {
+			if !done[v] && (u < 0 || dist[v] < dist[u]) {
+				u = v
+			}
+		}
+		done[u] = true
+		if parent[u] >= 0 {
+			t.connect(nodes[parent[u]], nodes[u])
+		}
+
+		for v := range nodes {
+			if w := weight(nodes[u], nodes[v]); !done[v] && dist[u]+w < dist[v] {
+				dist[v], parent[v] = dist[u]+w, u
+			}
+		}
+	}
+	return t
+}
+
+// TotalWeight returns the sum of the weights of the edges of t, which MinimumSpanningTreeBy keeps smallest.
+func TotalWeight(t map[string][]string, weight Weight) time.Duration {
+	var total time.Duration
+	for _, a := range SortedNodes(t) {
+		for _, b := range t[a] {
+			if a < b {
+				total += weight(a, b)
+			}
+		}
+	}
+	return total
+}
+
+// Diameter returns the weight of the heaviest path between two nodes of the tree t.
+func Diameter(t map[string][]string, weight Weight) time.Duration {
+	var diameter time.Duration
+	for _, src := range SortedNodes(t) {
+		dist := map[string]time.Duration{src: 0}
+		stack := []string{src}
+		for len(stack) > 0 {
+			n := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			for _, next := range t[n] {
+				if _, ok := dist[next]; !ok {
+					dist[next] = dist[n] + weight(n, next)
+					diameter = max(diameter, dist[next])
+					stack = append(stack, next)
+				}
+			}
+		}
+	}
+	return diameter
+}
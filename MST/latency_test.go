@@ -0,0 +1,28 @@
+package mst
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestBuildersKeepTheirCostSmallest(t *testing.T) {
+	for seed := int64(1); seed <= 20; seed++ {
+		nodes := nodeIDs(12)
+		weight := randomWeights(nodes, rand.New(rand.NewSource(seed)))
+
+		spanning := MinimumSpanningTreeBy(nodes, weight)
+		diameter := MinimumDiameterTreeBy(nodes, weight)
+		for _, tree := range []map[string][]string{spanning, diameter} {
+			if _, err := Validate(tree); err != nil {
+				t.Fatalf("seed %d: %v", seed, err)
+			}
+		}
+
+		if TotalWeight(spanning, weight) > TotalWeight(diameter, weight) {
+			t.Errorf("seed %d: the minimum spanning tree weighs %d, the diameter tree %d", seed, TotalWeight(spanning, weight), TotalWeight(diameter, weight))
+		}
+		if Diameter(diameter, weight) > Diameter(spanning, weight) {
+			t.Errorf("seed %d: the diameter tree spans %d, the minimum spanning tree %d", seed, Diameter(diameter, weight), Diameter(spanning, weight))
+		}
+	}
+}
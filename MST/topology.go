@@ -0,0 +1,323 @@
+package mst
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Generator builds an undirected topology over nodes, drawing any randomness from rng so the same seed gives
+// the same topology.
+type Generator func(nodes []string, rng *rand.Rand) map[string][]string
+
+// TopologyEnv selects the generator when the package is loaded, as maelstrom starts nodes without arguments.
+const TopologyEnv = "AODS_TOPOLOGY"
+
+var (
+	specMu sync.Mutex
+	spec   = os.Getenv(TopologyEnv)
+)
+
+// SetTopology changes the configured generator, see Parse for the format. An empty spec clears it.
+func SetTopology(s string) error {
+	if s != "" {
+		if _, err := Parse(s); err != nil {
+			return err
+		}
+	}
+
+	specMu.Lock()
+	spec = s
+	specMu.Unlock()
+	return nil
+}
+
+// Configured returns the configured generator and its spec, and false if none or an invalid one is set.
+func Configured() (Generator, string, bool) {
+	specMu.Lock()
+	s := spec
+	specMu.Unlock()
+
+	if s == "" {
+		return nil, "", false
+	}
+	gen, err := Parse(s)
+	return gen, s, err == nil
+}
+
+// Parse returns the generator named by spec - tree:k, grid, ring, line, star, complete, regular:k, mst,
+// balanced:depth:degree or bounded:depth:degree, where a bound of 0 is no bound. tree:1 is a line and
+// regular:2 a ring, regular:1 is refused as it leaves all but two nodes apart.
+func Parse(spec string) (Generator, error) {
+	name, rest, _ := strings.Cut(strings.ToLower(spec), ":")
+
+	var args []int
+	if rest != "" {
+		for _, arg := range strings.Split(rest, ":") {
+			k, err := strconv.Atoi(arg)
+			if err != nil || k < 0 {
+				return nil, fmt.Errorf("invalid topology argument in %q", spec)
+			}
+			args = append(args, k)
+		}
+	}
+
+	wants, ok := arity[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown topology %q", spec)
+	}
+	if len(args) != wants {
+		return nil, fmt.Errorf("topology %q takes %d arguments, not %d", name, wants, len(args))
+	}
+
+	if (name == "tree" && args[0] < 1) || (name == "regular" && args[0] < 2) {
+		return nil, fmt.Errorf("invalid topology argument in %q", spec)
+	}
+
+	switch name {
+	case "tree":
+		return Tree(args[0]), nil
+	case "regular":
+		return RandomRegular(args[0]), nil
+	case "balanced", "bounded":
+		c := Constraints{MaxDepth: args[0], MaxDegree: args[1]}
+		if err := c.validate(); err != nil {
+			return nil, err
+		}
+		if name == "balanced" {
+			return balanced(c), nil
+		}
+		return bounded(c), nil
+	case "grid":
+		return Grid, nil
+	case "ring":
+		return Ring, nil
+	case "line":
+		return Line, nil
+	case "star":
+		return Star, nil
+	case "complete":
+		return Complete, nil
+	}
+	return MST, nil
+}
+
+// arity is the number of arguments of every topology.
+var arity = map[string]int{
+	"tree": 1, "regular": 1, "balanced": 2, "bounded": 2,
+	"grid": 0, "ring": 0, "line": 0, "star": 0, "complete": 0, "mst": 0,
+}
+
+// Tree connects every node to its parent in a tree where each node has up to k children, in the order of
+// nodes.
+func Tree(k int) Generator {
+	return func(nodes []string, _ *rand.Rand) map[string][]string {
+		t := newTopology(nodes)
+		for i := 1; i < len(nodes); i++ {
+			t.connect(nodes[(i-1)/k], nodes[i])
+		}
+		return t
+	}
+}
+
+// Grid places the nodes row by row on the smallest square grid that holds them.
+func Grid(nodes []string, _ *rand.Rand) map[string][]string {
+	t := newTopology(nodes)
+	width := int(math.Ceil(math.Sqrt(float64(len(nodes)))))
+	for i := range nodes {
+		if i%width > 0 {
+			t.connect(nodes[i-1], nodes[i])
+		}
+		if i >= width {
+			t.connect(nodes[i-width], nodes[i])
+		}
+	}
+	return t
+}
+
+// Ring closes the line into a cycle.
+func Ring(nodes []string, rng *rand.Rand) map[string][]string {
+	t := Line(nodes, rng)
+	if len(nodes) > 2 {
+		connect(t, nodes[len(nodes)-1], nodes[0])
+	}
+	return t
+}
+
+// Line connects the nodes one after another.
+func Line(nodes []string, _ *rand.Rand) map[string][]string {
+	t := newTopology(nodes)
+	for i := 1; i < len(nodes); i++ {
+		t.connect(nodes[i-1], nodes[i])
+	}
+	return t
+}
+
+// Star connects every node to the first one.
+func Star(nodes []string, _ *rand.Rand) map[string][]string {
+	t := newTopology(nodes)
+	for i := 1; i < len(nodes); i++ {
+		t.connect(nodes[0], nodes[i])
+	}
+	return t
+}
+
+// Complete connects every pair of nodes.
+func Complete(nodes []string, _ *rand.Rand) map[string][]string {
+	t := newTopology(nodes)
+	for i := range nodes {
+		for j := i + 1; j < len(nodes); j++ {
+			t.connect(nodes[i], nodes[j])
+		}
+	}
+	return t
+}
+
+// RandomRegular returns a connected random graph where every node has k neighbors, one node k-1 if the
+// number of nodes times k is odd. Pairing runs into dead ends now and then and is restarted, after
+// regularAttempts of them the generator settles for a circulant graph of the same degree. For k = 2 it is
+// a ring in random order, as pairing mostly ends up with several cycles.
+func RandomRegular(k int) Generator {
+	return func(nodes []string, rng *rand.Rand) map[string][]string {
+		k := min(k, len(nodes)-1)
+		if k == 2 {
+			order := append([]string(nil), nodes...)
+			rng.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+			return Ring(order, rng)
+		}
+		for attempt := 0; attempt < regularAttempts; attempt++ {
+			if t, ok := pairRegular(nodes, k, rng); ok {
+				if _, err := Validate(t); err == nil {
+					return t
+				}
+			}
+		}
+		return circulant(nodes, k)
+	}
+}
+
+const regularAttempts = 100
+
+// pairRegular pairs up free edge ends of nodes that are not adjacent yet, picked at random. It reports
+// false when the ends left can not be paired.
+func pairRegular(nodes []string, k int, rng *rand.Rand) (map[string][]string, bool) {
+	t := newTopology(nodes)
+	free := make([]int, 0, len(nodes)*k)
+	for i := range nodes {
+		for j := 0; j < k; j++ {
+			free = append(free, i)
+		}
+	}
+
+	valid := func(a, b int) bool {
+		return free[a] != free[b] && !t.adjacent(nodes[free[a]], nodes[free[b]])
+	}
+
+	// A single free end is left over when the degrees do not add up
+	for len(free) > 1 {
+		a, b := -1, -1
+		for try := 0; try < 50 && a < 0; try++ {
+			if x, y := rng.Intn(len(free)), rng.Intn(len(free)); valid(x, y) {
+				a, b = min(x, y), max(x, y)
+			}
+		}
+		// Few valid pairs are left, look for them all
+		if a < 0 {
+			var pairs [][2]int
+			for x := range free {
+				for y := x + 1; y < len(free); y++ {
+					if valid(x, y) {
+						pairs = append(pairs, [2]int{x, y})
+					}
+				}
+			}
+			if len(pairs) == 0 {
+				return t, false
+			}
+			p := pairs[rng.Intn(len(pairs))]
+			a, b = p[0], p[1]
+		}
+
+		t.connect(nodes[free[a]], nodes[free[b]])
+		free = append(free[:b], free[b+1:]...)
+		free = append(free[:a], free[a+1:]...)
+	}
+	return t, true
+}
+
+// circulant connects every node to the k/2 nodes on either side of it. If k is odd it also pairs each node
+// of the first half with the one n/2 further on, which leaves out the last node when n is odd.
+func circulant(nodes []string, k int) map[string][]string {
+	t := newTopology(nodes)
+	n := len(nodes)
+	for i := range nodes {
+		for d := 1; d <= k/2; d++ {
+			t.connect(nodes[i], nodes[(i+d)%n])
+		}
+		if k%2 == 1 && i < n/2 {
+			t.connect(nodes[i], nodes[i+n/2])
+		}
+	}
+	return t
+}
+
+// MST is MinimumSpanningTree over nodes.
+func MST(nodes []string, rng *rand.Rand) map[string][]string {
+	t := newTopology(nodes)
+	tree := MinimumSpanningTree(len(nodes), rng)
+	for _, src := range SortedNodes(tree) {
+		i, _ := strconv.Atoi(strings.TrimPrefix(src, "n"))
+		for _, dest := range tree[src] {
+			j, _ := strconv.Atoi(strings.TrimPrefix(dest, "n"))
+			t.connect(nodes[i], nodes[j])
+		}
+	}
+	return t
+}
+
+type topology map[string][]string
+
+func newTopology(nodes []string) topology {
+	t := make(topology, len(nodes))
+	for _, n := range nodes {
+		t[n] = []string{}
+	}
+	return t
+}
+
+// connect adds the undirected edge between a and b unless it exists.
+func (t topology) connect(a, b string) {
+	if a == b || t.adjacent(a, b) {
+		return
+	}
+	t[a] = append(t[a], b)
+	t[b] = append(t[b], a)
+}
+
+func (t topology) adjacent(a, b string) bool {
+	for _, n := range t[a] {
+		if n == b {
+			return true
+		}
+	}
+	return false
+}
+
+func connect(t map[string][]string, a, b string) {
+	topology(t).connect(a, b)
+}
+
+// SortedNodes returns the nodes of a topology in sorted order.
+func SortedNodes(t map[string][]string) []string {
+	nodes := make([]string, 0, len(t))
+	for n := range t {
+		nodes = append(nodes, n)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
@@ -0,0 +1,73 @@
+package mst
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func nodeIDs(n int) []string {
+	nodes := make([]string, n)
+	for i := range nodes {
+		nodes[i] = fmt.Sprintf("n%d", i)
+	}
+	return nodes
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec      string
+		edges     int
+		diameter  int
+		maxDegree int
+	}{
+		{"tree:1", 9, 9, 2},
+		{"tree:3", 9, 4, 4},
+		{"line", 9, 9, 2},
+		{"ring", 10, 5, 2},
+		{"regular:2", 10, 5, 2},
+		{"regular:3", 15, 0, 3},
+	}
+	for _, tt := range tests {
+		gen, err := Parse(tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stats, err := Validate(gen(nodeIDs(10), rand.New(rand.NewSource(1))))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.spec, err)
+		}
+		if stats.Edges != tt.edges || stats.MaxDegree != tt.maxDegree {
+			t.Errorf("%s has %d edges and max degree %d, want %d and %d", tt.spec, stats.Edges, stats.MaxDegree, tt.edges, tt.maxDegree)
+		}
+		if tt.diameter > 0 && stats.Diameter != tt.diameter {
+			t.Errorf("%s has diameter %d, want %d", tt.spec, stats.Diameter, tt.diameter)
+		}
+	}
+
+	for _, spec := range []string{"tree:0", "regular:0", "regular:1", "tree", "ring:2", "mesh"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("%s parsed", spec)
+		}
+	}
+}
+
+func TestCirculantDegrees(t *testing.T) {
+	for _, n := range []int{7, 8} {
+		for _, k := range []int{2, 3, 4, 5} {
+			degrees := make(map[int]int)
+			for _, peers := range circulant(nodeIDs(n), k) {
+				degrees[len(peers)]++
+			}
+
+			want := map[int]int{k: n}
+			if n*k%2 == 1 {
+				want = map[int]int{k: n - 1, k - 1: 1}
+			}
+			if fmt.Sprint(degrees) != fmt.Sprint(want) {
+				t.Errorf("circulant of %d nodes with degree %d has degree counts %v, want %v", n, k, degrees, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package mst
+
+import (
+	"fmt"
+)
+
+// Stats describes a valid topology.
+type Stats struct {
+	Nodes     int
+	Edges     int
+	Diameter  int
+	MaxDegree int
+}
+
+// Validate checks that a topology is undirected and connected, and measures its diameter and degree with a
+// breadth-first search from every node.
+func Validate(t map[string][]string) (Stats, error) {
+	stats := Stats{Nodes: len(t)}
+	nodes := SortedNodes(t)
+
+	for _, src := range nodes {
+		stats.MaxDegree = max(stats.MaxDegree, len(t[src]))
+		for _, dest := range t[src] {
+			if _, ok := t[dest]; !ok {
+				return stats, fmt.Errorf("edge %s-%s leads to an unknown node", src, dest)
+			}
+			if !topology(t).adjacent(dest, src) {
+				return stats, fmt.Errorf("edge %s-%s has no way back", src, dest)
+			}
+			if src == dest {
+				return stats, fmt.Errorf("node %s is its own neighbor", src)
+			}
+			stats.Edges++
+		}
+	}
+	stats.Edges /= 2
+
+	for _, src := range nodes {
+		dist := map[string]int{src: 0}
+		queue := []string{src}
+		for len(queue) > 0 {
+			n := queue[0]
+			queue = queue[1:]
+			for _, next := range t[n] {
+				if _, ok := dist[next]; !ok {
+					dist[next] = dist[n] + 1
+					stats.Diameter = max(stats.Diameter, dist[next])
+					queue = append(queue, next)
+				}
+			}
+		}
+
+		if len(dist) < len(nodes) {
+			return stats, fmt.Errorf("%d of %d nodes are unreachable from %s", len(nodes)-len(dist), len(nodes), src)
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,375 @@
+// Package antientropy repairs the value sets of broadcast neighbors by
+// comparing Merkle trees or IBLT sketches, so a node that missed values behind a partition
+// catches up without retrying every message or pulling a full set.
+package antientropy
+
+import (
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HdkTvd/advent-of-distributed-systems/logging"
+	"github.com/HdkTvd/advent-of-distributed-systems/membership"
+	"github.com/HdkTvd/advent-of-distributed-systems/schema"
+	"github.com/HdkTvd/advent-of-distributed-systems/sim"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// 1. Every interval the node picks a random neighbor and sends its root hash.
+// 2. The neighbor answers with the nodes whose hashes differ, and the node sends the hashes of their children,
+// one level at a time.
+// 3. For the leaf buckets that still differ the node sends its values, and the neighbor answers with its
+// values the node does not have, so both sides repair in one exchange.
+// 4. With the IBLT method the node instead sends a sketch of its values, and the neighbor decodes the
+// difference in one round trip. A sketch too small for the difference is retried at twice the size, up to
+// maxCells, after which the exchange falls back to the Merkle descent.
+
+// MethodEnv selects the method when the package is loaded, as maelstrom starts nodes without arguments.
+const MethodEnv = "AODS_ANTI_ENTROPY"
+
+// Method is how neighbors find the difference of their values.
+type Method int
+
+const (
+	Merkle Method = iota
+	IBLT
+)
+
+func (m Method) String() string {
+	if m == IBLT {
+		return "iblt"
+	}
+	return "merkle"
+}
+
+// ParseMethod parses merkle or iblt.
+func ParseMethod(s string) (Method, error) {
+	switch strings.ToLower(s) {
+	case "merkle", "":
+		return Merkle, nil
+	case "iblt":
+		return IBLT, nil
+	}
+	return Merkle, fmt.Errorf("unknown anti-entropy method %q", s)
+}
+
+// maelstrom nodes read messages with a bufio.Scanner, which fails on lines over 64KiB, so every message
+// stays well below that.
+const (
+	// minCells is the size of the first sketch sent to a neighbor, sketches shrink back to it while
+	// differences stay small
+	minCells = 48
+	maxCells = 1536
+	// maxIndices bounds the hashes of one merkle_sync, maxValues the values of one message
+	maxIndices = 1024
+	maxValues  = 2048
+)
+
+var (
+	methodMu sync.Mutex
+	method   Method
+)
+
+func init() {
+	if m, err := ParseMethod(os.Getenv(MethodEnv)); err == nil {
+		method = m
+	}
+}
+
+// SetMethod changes the method of AntiEntropy created from now on.
+func SetMethod(m Method) {
+	methodMu.Lock()
+	method = m
+	methodMu.Unlock()
+}
+
+// AntiEntropy keeps a Merkle tree of the values a node has seen and exchanges it with its neighbors.
+type AntiEntropy struct {
+	mu   sync.Mutex
+	tree *Tree
+	// cells is the sketch size that last decoded the difference with each neighbor
+	cells  map[string]int
+	method Method
+
+	n      *maelstrom.Node
+	peers  membership.View
+	learn  func(values []int, src string)
+	clock  sim.Clock
+	rng    *rand.Rand
+	logger *slog.Logger
+}
+
+// New registers the anti-entropy handlers on n. learn is called, without any lock held, with the values a
+// neighbor had that the node did not, and must add them with Add.
+func New(n *maelstrom.Node, peers membership.View, learn func(values []int, src string)) *AntiEntropy {
+	env := sim.For(n)
+
+	methodMu.Lock()
+	m := method
+	methodMu.Unlock()
+
+	ae := &AntiEntropy{
+		tree:   NewTree(),
+		cells:  make(map[string]int),
+		method: m,
+		n:      n,
+		peers:  peers,
+		learn:  learn,
+		clock:  env.Clock,
+		rng:    env.Rand,
+		logger: logging.For(n),
+	}
+
+	n.Handle("merkle_sync", func(msg maelstrom.Message) error {
+		body, err := schema.Decode[schema.MerkleSync](msg)
+		if err != nil {
+			return err
+		}
+
+		ae.mu.Lock()
+		differing := ae.tree.Differing(body.Level, body.Indices, body.Hashes)
+		ae.mu.Unlock()
+
+		return n.Reply(msg, schema.NewMerkleSyncOK(differing))
+	})
+
+	n.Handle("merkle_values", func(msg maelstrom.Message) error {
+		body, err := schema.Decode[schema.MerkleValues](msg)
+		if err != nil {
+			return err
+		}
+
+		theirs := make(map[int]bool, len(body.Values))
+		for _, v := range body.Values {
+			theirs[v] = true
+		}
+
+		var missing, extra []int
+		ae.mu.Lock()
+		for _, v := range body.Values {
+			if !ae.tree.Has(v) {
+				missing = append(missing, v)
+			}
+		}
+		for _, v := range ae.tree.Values(body.Buckets) {
+			if !theirs[v] && len(extra) < maxValues {
+				extra = append(extra, v)
+			}
+		}
+		ae.mu.Unlock()
+
+		if len(missing) > 0 {
+			ae.learn(missing, msg.Src)
+		}
+
+		return n.Reply(msg, schema.NewMerkleValuesOK(extra))
+	})
+
+	n.Handle("iblt_sync", func(msg maelstrom.Message) error {
+		body, err := schema.Decode[schema.IBLTSync](msg)
+		if err != nil {
+			return err
+		}
+
+		theirs := &Sketch{Counts: body.Counts, Keys: body.Keys, Checks: body.Checks}
+		if theirs.Len()%hashFuncs != 0 {
+			return maelstrom.NewRPCError(maelstrom.MalformedRequest, "sketch size is not a multiple of 3")
+		}
+
+		ae.mu.Lock()
+		added, removed, ok := ae.sketch(theirs.Len()).Subtract(theirs).Decode()
+		var missing, extra []int
+		if ok {
+			// A decoded value the tree disagrees with means the sketch was corrupt, it is skipped
+			for _, v := range removed {
+				if !ae.tree.Has(v) {
+					missing = append(missing, v)
+				}
+			}
+			for _, v := range added {
+				if ae.tree.Has(v) && len(extra) < maxValues {
+					extra = append(extra, v)
+				}
+			}
+		}
+		ae.mu.Unlock()
+
+		if len(missing) > 0 {
+			ae.learn(missing, msg.Src)
+		}
+
+		return n.Reply(msg, schema.NewIBLTSyncOK(extra, !ok))
+	})
+
+	return ae
+}
+
+// sketch returns a sketch of every value with the given number of cells. Callers hold mu.
+func (ae *AntiEntropy) sketch(cells int) *Sketch {
+	s := NewSketch(cells)
+	for v := range ae.tree.seen {
+		s.Insert(v)
+	}
+	return s
+}
+
+// Add records values the node learned.
+func (ae *AntiEntropy) Add(values ...int) {
+	ae.mu.Lock()
+	ae.tree.Add(values...)
+	ae.mu.Unlock()
+}
+
+// Start runs an exchange with a random neighbor every interval.
+func (ae *AntiEntropy) Start(interval time.Duration) {
+	ae.clock.Go(func() {
+		for {
+			ae.clock.Sleep(interval)
+
+			peers := ae.peers.Neighbors()
+			if len(peers) > 0 {
+				ae.Sync(peers[ae.rng.Intn(len(peers))])
+			}
+		}
+	})
+}
+
+// Sync starts an exchange with peer. It returns before the exchange is done, every step runs in the reply
+// handler of the previous one.
+func (ae *AntiEntropy) Sync(peer string) {
+	if ae.method == IBLT {
+		ae.mu.Lock()
+		cells := max(ae.cells[peer], minCells)
+		ae.mu.Unlock()
+
+		ae.reconcile(peer, cells)
+		return
+	}
+	ae.compare(peer, 0, []int{0})
+}
+
+// reconcile sends a sketch with the given number of cells and learns the values peer decoded as missing.
+func (ae *AntiEntropy) reconcile(peer string, cells int) {
+	ae.mu.Lock()
+	s := ae.sketch(cells)
+	ae.mu.Unlock()
+
+	ae.rpc(peer, schema.NewIBLTSync(s.Counts, s.Keys, s.Checks), func(msg maelstrom.Message) error {
+		body, err := schema.DecodeReply[schema.IBLTSyncOK](msg, "iblt_sync_ok")
+		if err != nil {
+			return err
+		}
+
+		if body.Failed {
+			if cells*2 > maxCells {
+				ae.logger.Debug("Difference too large for a sketch, comparing Merkle trees", "dest", peer, "cells", cells)
+				ae.compare(peer, 0, []int{0})
+				return nil
+			}
+
+			ae.mu.Lock()
+			ae.cells[peer] = cells * 2
+			ae.mu.Unlock()
+
+			ae.reconcile(peer, cells*2)
+			return nil
+		}
+
+		// A difference far below the capacity lets the next sketch shrink
+		if len(body.Values)*4 < cells {
+			ae.mu.Lock()
+			ae.cells[peer] = max(cells/2, minCells)
+			ae.mu.Unlock()
+		}
+
+		if len(body.Values) > 0 {
+			ae.logger.Debug("Repaired missing values", "dest", peer, "values", len(body.Values))
+			ae.learn(body.Values, peer)
+		}
+		return nil
+	})
+}
+
+// compare sends the hashes of indices at level and descends into the ones peer reports as differing.
+func (ae *AntiEntropy) compare(peer string, level int, indices []int) {
+	if len(indices) > maxIndices {
+		for len(indices) > 0 {
+			size := min(len(indices), maxIndices)
+			ae.compare(peer, level, indices[:size])
+			indices = indices[size:]
+		}
+		return
+	}
+
+	ae.mu.Lock()
+	hashes := ae.tree.Hashes(level, indices)
+	ae.mu.Unlock()
+
+	ae.rpc(peer, schema.NewMerkleSync(level, indices, hashes), func(msg maelstrom.Message) error {
+		body, err := schema.DecodeReply[schema.MerkleSyncOK](msg, "merkle_sync_ok")
+		if err != nil {
+			return err
+		}
+		if len(body.Differing) == 0 {
+			return nil
+		}
+
+		if level == depth {
+			ae.exchange(peer, body.Differing)
+			return nil
+		}
+
+		var next []int
+		for _, idx := range body.Differing {
+			next = append(next, children(idx)...)
+		}
+		ae.compare(peer, level+1, next)
+		return nil
+	})
+}
+
+// exchange sends the values in buckets and learns the ones peer has in addition, split into messages of
+// up to maxValues values.
+func (ae *AntiEntropy) exchange(peer string, buckets []int) {
+	ae.mu.Lock()
+	var chunk, values []int
+	for _, b := range buckets {
+		bucket := ae.tree.Values([]int{b})
+		if len(chunk) > 0 && len(values)+len(bucket) > maxValues {
+			ae.sendValues(peer, chunk, values)
+			chunk, values = nil, nil
+		}
+		chunk = append(chunk, b)
+		values = append(values, bucket...)
+	}
+	ae.mu.Unlock()
+
+	if len(chunk) > 0 {
+		ae.sendValues(peer, chunk, values)
+	}
+}
+
+func (ae *AntiEntropy) sendValues(peer string, buckets, values []int) {
+	ae.rpc(peer, schema.NewMerkleValues(buckets, values), func(msg maelstrom.Message) error {
+		body, err := schema.DecodeReply[schema.MerkleValuesOK](msg, "merkle_values_ok")
+		if err != nil {
+			return err
+		}
+
+		if len(body.Values) > 0 {
+			ae.logger.Debug("Repaired missing values", "dest", peer, "values", len(body.Values))
+			ae.learn(body.Values, peer)
+		}
+		return nil
+	})
+}
+
+func (ae *AntiEntropy) rpc(dest string, body any, onReply maelstrom.HandlerFunc) {
+	if err := ae.n.RPC(dest, body, onReply); err != nil {
+		ae.logger.Error("Failed to send", "dest", dest, "err", err)
+	}
+}
@@ -0,0 +1,232 @@
+package antientropy_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HdkTvd/advent-of-distributed-systems/antientropy"
+	"github.com/HdkTvd/advent-of-distributed-systems/harness"
+	"github.com/HdkTvd/advent-of-distributed-systems/membership"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// replica is the value set of one node, kept in sync by its anti-entropy.
+type replica struct {
+	mu     sync.Mutex
+	values map[int]bool
+	ae     *antientropy.AntiEntropy
+	// syncs lists the merkle_sync and iblt_sync requests the node sent, the latter with their sketch size
+	syncs []string
+
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+// Write taps the messages the node sends.
+func (r *replica) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	r.buf.Write(p)
+	for {
+		line, err := r.buf.ReadBytes('\n')
+		if err != nil {
+			r.buf.Write(line)
+			break
+		}
+
+		var msg maelstrom.Message
+		var body struct {
+			Type   string `json:"type"`
+			Counts []int  `json:"counts"`
+		}
+		if json.Unmarshal(line, &msg) != nil || json.Unmarshal(msg.Body, &body) != nil {
+			continue
+		}
+		switch body.Type {
+		case "merkle_sync":
+			r.syncs = append(r.syncs, body.Type)
+		case "iblt_sync":
+			r.syncs = append(r.syncs, fmt.Sprintf("%s:%d", body.Type, len(body.Counts)))
+		}
+	}
+	r.mu.Unlock()
+
+	return r.w.Write(p)
+}
+
+func (r *replica) add(values ...int) {
+	r.mu.Lock()
+	for _, v := range values {
+		r.values[v] = true
+	}
+	r.mu.Unlock()
+	r.ae.Add(values...)
+}
+
+func (r *replica) len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.values)
+}
+
+// takeSyncs returns the sync requests sent since the last call.
+func (r *replica) takeSyncs() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	syncs := r.syncs
+	r.syncs = nil
+	return syncs
+}
+
+// start runs two nodes that are each other's only neighbor and reconcile with method.
+func start(t *testing.T, method antientropy.Method) (*harness.Network, map[string]*replica, context.Context) {
+	t.Helper()
+
+	antientropy.SetMethod(method)
+	t.Cleanup(func() { antientropy.SetMethod(antientropy.Merkle) })
+
+	var mu sync.Mutex
+	replicas := make(map[string]*replica)
+
+	net := harness.New(2, func(n *maelstrom.Node) {
+		r := &replica{values: make(map[int]bool), w: n.Stdout}
+		n.Stdout = r
+		r.ae = antientropy.New(n, membership.NewStatic(), func(values []int, src string) { r.add(values...) })
+
+		n.Handle("init", func(msg maelstrom.Message) error {
+			mu.Lock()
+			replicas[n.ID()] = r
+			mu.Unlock()
+			return nil
+		})
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	t.Cleanup(cancel)
+	if err := net.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := net.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	return net, replicas, ctx
+}
+
+func TestSyncConverges(t *testing.T) {
+	_, replicas, _ := start(t, antientropy.Merkle)
+	a, b := replicas["n0"], replicas["n1"]
+
+	// More values than one merkle_values message carries, so the rest follow in later rounds
+	const values = 6000
+	for v := 0; v < values; v++ {
+		switch v % 3 {
+		case 0:
+			a.add(v)
+		case 1:
+			b.add(v)
+		default:
+			a.add(v)
+			b.add(v)
+		}
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for a.len() != values || b.len() != values {
+		if time.Now().After(deadline) {
+			t.Fatalf("n0 has %d and n1 has %d of %d values", a.len(), b.len(), values)
+		}
+		a.ae.Sync("n1")
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestMerkleSyncRejectsLengthMismatch(t *testing.T) {
+	net, _, ctx := start(t, antientropy.Merkle)
+
+	_, err := net.Client("c1").RPC(ctx, "n0", map[string]any{
+		"type": "merkle_sync", "level": 0, "indices": []int{0}, "hashes": []uint64{},
+	})
+	var rpcErr *maelstrom.RPCError
+	if !errors.As(err, &rpcErr) || rpcErr.Code != maelstrom.MalformedRequest {
+		t.Fatalf("merkle_sync with more indices than hashes returned %v, want a malformed-request error", err)
+	}
+}
+
+func TestIBLTGrowsThenFallsBackToMerkle(t *testing.T) {
+	_, replicas, _ := start(t, antientropy.IBLT)
+	a, b := replicas["n0"], replicas["n1"]
+
+	// Peel a small difference with the smallest sketch
+	a.add(1, 2, 3)
+	b.add(3, 4)
+	a.ae.Sync("n1")
+	eventually(t, func() error {
+		if a.len() != 4 || b.len() != 4 {
+			return fmt.Errorf("n0 has %d and n1 has %d of 4 values", a.len(), b.len())
+		}
+		return nil
+	})
+	if got, want := a.takeSyncs(), []string{"iblt_sync:48"}; !slices.Equal(got, want) {
+		t.Fatalf("sent %v, want %v", got, want)
+	}
+
+	// A difference too large for any sketch doubles it up to 1536 cells, then descends the Merkle trees
+	const values = 3000
+	for v := 100; v < 100+values; v++ {
+		a.add(v)
+	}
+	a.ae.Sync("n1")
+	eventually(t, func() error {
+		if b.len() != 4+values {
+			return fmt.Errorf("n1 has %d of %d values", b.len(), 4+values)
+		}
+		return nil
+	})
+	want := []string{"iblt_sync:48", "iblt_sync:96", "iblt_sync:192", "iblt_sync:384", "iblt_sync:768", "iblt_sync:1536"}
+	if got := a.takeSyncs(); len(got) <= len(want) || !slices.Equal(got[:len(want)], want) || got[len(want)] != "merkle_sync" {
+		t.Fatalf("sent %v, want %v followed by merkle_sync", got, want)
+	}
+
+	// The next exchange starts from the size that last worked, and shrinks once the difference is small. n0
+	// learns the new values from the reply, after it picked the size of the next sketch
+	for i, v := range []int{-1, -2} {
+		b.add(v)
+		a.ae.Sync("n1")
+		eventually(t, func() error {
+			if a.len() != 5+i+values {
+				return fmt.Errorf("n0 has %d of %d values", a.len(), 5+i+values)
+			}
+			return nil
+		})
+	}
+	if got, want := a.takeSyncs(), []string{"iblt_sync:1536", "iblt_sync:768"}; !slices.Equal(got, want) {
+		t.Fatalf("sent %v, want %v", got, want)
+	}
+}
+
+// eventually polls cond until it holds or 10 seconds pass.
+func eventually(t *testing.T, cond func() error) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		err := cond()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
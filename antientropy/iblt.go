@@ -0,0 +1,104 @@
+package antientropy
+
+// hashFuncs is the number of cells every value is added to, one in each
+// third of the sketch.
+const hashFuncs = 3
+
+// Sketch is an invertible Bloom lookup table over a set of ints. Subtracting
+// the sketch of another set leaves the symmetric difference, which Decode
+// lists as long as it is small compared to the number of cells.
+type Sketch struct {
+	Counts []int
+	// Keys and Checks are the XOR of the values, and of their hashes, added to a cell
+	Keys   []int
+	Checks []uint64
+}
+
+// NewSketch returns an empty sketch with cells rounded up to a multiple of
+// hashFuncs.
+func NewSketch(cells int) *Sketch {
+	cells = (max(cells, hashFuncs) + hashFuncs - 1) / hashFuncs * hashFuncs
+	return &Sketch{
+		Counts: make([]int, cells),
+		Keys:   make([]int, cells),
+		Checks: make([]uint64, cells),
+	}
+}
+
+// Len returns the number of cells.
+func (s *Sketch) Len() int {
+	return len(s.Counts)
+}
+
+func (s *Sketch) Insert(values ...int) {
+	for _, v := range values {
+		s.toggle(v, 1)
+	}
+}
+
+func (s *Sketch) toggle(v, count int) {
+	check := checksum(v)
+	for _, c := range s.cells(v) {
+		s.Counts[c] += count
+		s.Keys[c] ^= v
+		s.Checks[c] ^= check
+	}
+}
+
+// cells returns the cell of v in each third of the sketch, so they never collide.
+func (s *Sketch) cells(v int) [hashFuncs]int {
+	var cells [hashFuncs]int
+	width := len(s.Counts) / hashFuncs
+	for i := range cells {
+		cells[i] = i*width + int(mix(uint64(v)^uint64(i+1)*0x9e3779b97f4a7c15)%uint64(width))
+	}
+	return cells
+}
+
+// Subtract returns s minus o, which must have the same number of cells.
+func (s *Sketch) Subtract(o *Sketch) *Sketch {
+	d := NewSketch(s.Len())
+	for c := range s.Counts {
+		d.Counts[c] = s.Counts[c] - o.Counts[c]
+		d.Keys[c] = s.Keys[c] ^ o.Keys[c]
+		d.Checks[c] = s.Checks[c] ^ o.Checks[c]
+	}
+	return d
+}
+
+// Decode peels a difference sketch. It returns the values only the first
+// set had, the values only the subtracted one had, and false if the
+// difference was too large to list completely.
+func (s *Sketch) Decode() (added, removed []int, ok bool) {
+	d := s.Subtract(NewSketch(s.Len()))
+
+	for progress := true; progress; {
+		progress = false
+		for c := range d.Counts {
+			if d.Counts[c] != 1 && d.Counts[c] != -1 || d.Checks[c] != checksum(d.Keys[c]) {
+				continue
+			}
+
+			v, count := d.Keys[c], d.Counts[c]
+			if count == 1 {
+				added = append(added, v)
+			} else {
+				removed = append(removed, v)
+			}
+			d.toggle(v, -count)
+			progress = true
+		}
+	}
+
+	for c := range d.Counts {
+		if d.Counts[c] != 0 || d.Keys[c] != 0 || d.Checks[c] != 0 {
+			return added, removed, false
+		}
+	}
+	return added, removed, true
+}
+
+// checksum tells a cell holding a single value from one holding several.
+func checksum(v int) uint64 {
+	return mix(uint64(v)^0xd6e8feb86659fd93) & hashMask
+}
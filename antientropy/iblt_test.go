@@ -0,0 +1,42 @@
+package antientropy
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSketchPeelsDifference(t *testing.T) {
+	a, b := NewSketch(minCells), NewSketch(minCells)
+	for v := 0; v < 1000; v++ {
+		a.Insert(v)
+		b.Insert(v)
+	}
+	a.Insert(-5, 1000, 1<<40)
+	b.Insert(2000, 2001)
+
+	added, removed, ok := a.Subtract(b).Decode()
+	slices.Sort(added)
+	slices.Sort(removed)
+	if !ok || !slices.Equal(added, []int{-5, 1000, 1 << 40}) || !slices.Equal(removed, []int{2000, 2001}) {
+		t.Fatalf("Decode = %v, %v, %v, want [-5 1000 %d], [2000 2001], true", added, removed, ok, 1<<40)
+	}
+}
+
+func TestSketchTooSmallFailsToDecode(t *testing.T) {
+	a, b := NewSketch(minCells), NewSketch(minCells)
+	for v := 0; v < 200; v++ {
+		a.Insert(v)
+	}
+
+	if _, _, ok := a.Subtract(b).Decode(); ok {
+		t.Fatalf("decoded a difference of 200 values from %d cells", minCells)
+	}
+}
+
+func TestNewSketchRoundsToThirds(t *testing.T) {
+	for cells, want := range map[int]int{0: 3, 47: 48, 48: 48, 49: 51} {
+		if got := NewSketch(cells).Len(); got != want {
+			t.Errorf("NewSketch(%d).Len() = %d, want %d", cells, got, want)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package antientropy
+
+import "sort"
+
+const (
+	// fanout is the number of children of every inner node
+	fanout = 16
+	// depth is the level of the leaves, the root is level 0
+	depth  = 3
+	leaves = 4096 // fanout^depth
+
+	// hashMask keeps hashes below 2^53, so they survive JSON parsers that read numbers as doubles
+	hashMask = 1<<53 - 1
+)
+
+// Tree is a Merkle tree over a set of ints. Values are spread over the leaf
+// buckets by hash, a leaf's hash combines the hashes of its values and an
+// inner node's hash combines the hashes of its children, so two sets differ
+// in exactly the buckets below the nodes whose hashes differ.
+type Tree struct {
+	seen    map[int]bool
+	buckets [leaves][]int
+	// levels holds the hashes of every level, rebuilt when dirty
+	levels [depth + 1][]uint64
+	dirty  bool
+}
+
+func NewTree() *Tree {
+	t := &Tree{seen: make(map[int]bool), dirty: true}
+	for level := range t.levels {
+		t.levels[level] = make([]uint64, width(level))
+	}
+	return t
+}
+
+// Add inserts the values, it returns the ones that were not in the tree yet.
+func (t *Tree) Add(values ...int) []int {
+	var fresh []int
+	for _, v := range values {
+		if t.seen[v] {
+			continue
+		}
+		t.seen[v] = true
+		b := bucket(v)
+		t.buckets[b] = append(t.buckets[b], v)
+		fresh = append(fresh, v)
+	}
+
+	if len(fresh) > 0 {
+		t.dirty = true
+	}
+	return fresh
+}
+
+// Has reports whether v is in the tree.
+func (t *Tree) Has(v int) bool {
+	return t.seen[v]
+}
+
+// Hashes returns the hashes of the nodes at indices of level.
+func (t *Tree) Hashes(level int, indices []int) []uint64 {
+	t.rebuild()
+
+	hashes := make([]uint64, len(indices))
+	for i, idx := range indices {
+		hashes[i] = t.levels[level][idx]
+	}
+	return hashes
+}
+
+// Differing returns the indices whose hash at level differs from the given one. Indices out of range are
+// skipped.
+func (t *Tree) Differing(level int, indices []int, hashes []uint64) []int {
+	if level < 0 || level > depth {
+		return nil
+	}
+	t.rebuild()
+
+	var differing []int
+	for i, idx := range indices {
+		if idx >= 0 && idx < len(t.levels[level]) && t.levels[level][idx] != hashes[i] {
+			differing = append(differing, idx)
+		}
+	}
+	return differing
+}
+
+// Values returns the values in the leaf buckets, in sorted order.
+func (t *Tree) Values(buckets []int) []int {
+	var values []int
+	for _, b := range buckets {
+		if b >= 0 && b < leaves {
+			values = append(values, t.buckets[b]...)
+		}
+	}
+	sort.Ints(values)
+	return values
+}
+
+func (t *Tree) rebuild() {
+	if !t.dirty {
+		return
+	}
+
+	for b, values := range t.buckets {
+		var h uint64
+		for _, v := range values {
+			h ^= mix(uint64(v))
+		}
+		t.levels[depth][b] = h & hashMask
+	}
+
+	for level := depth - 1; level >= 0; level-- {
+		for i := range t.levels[level] {
+			var h uint64
+			for _, c := range children(i) {
+				h = mix(h ^ t.levels[level+1][c])
+			}
+			t.levels[level][i] = h & hashMask
+		}
+	}
+
+	t.dirty = false
+}
+
+func width(level int) int {
+	w := 1
+	for i := 0; i < level; i++ {
+		w *= fanout
+	}
+	return w
+}
+
+func children(idx int) []int {
+	c := make([]int, fanout)
+	for i := range c {
+		c[i] = idx*fanout + i
+	}
+	return c
+}
+
+func bucket(v int) int {
+	return int(mix(uint64(v)) % leaves)
+}
+
+// mix is the splitmix64 finalizer, it spreads nearby values over the buckets.
+func mix(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
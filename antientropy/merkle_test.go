@@ -0,0 +1,80 @@
+package antientropy
+
+import (
+	"slices"
+	"testing"
+)
+
+// diff descends from the root of a to the leaf buckets whose hashes differ from b's, the way compare does
+// over the network.
+func diff(a, b *Tree) []int {
+	indices := []int{0}
+	for level := 0; level <= depth; level++ {
+		differing := b.Differing(level, indices, a.Hashes(level, indices))
+		if level == depth || len(differing) == 0 {
+			return differing
+		}
+
+		indices = nil
+		for _, idx := range differing {
+			indices = append(indices, children(idx)...)
+		}
+	}
+	return nil
+}
+
+func TestTreesConverge(t *testing.T) {
+	a, b := NewTree(), NewTree()
+	for v := 0; v < 10000; v++ {
+		if v%3 != 1 {
+			a.Add(v)
+		}
+		if v%3 != 2 {
+			b.Add(v)
+		}
+	}
+
+	for _, level := range []int{0, 1, depth} {
+		for _, h := range a.Hashes(level, []int{0, width(level) - 1}) {
+			if h > hashMask {
+				t.Fatalf("hash %d at level %d does not fit in 53 bits", h, level)
+			}
+		}
+	}
+
+	buckets := diff(a, b)
+	if len(buckets) == 0 {
+		t.Fatal("trees with different values have no differing buckets")
+	}
+
+	// Exchange the values of the differing buckets both ways, as merkle_values does
+	fromA, fromB := a.Values(buckets), b.Values(buckets)
+	added := len(b.Add(fromA...)) + len(a.Add(fromB...))
+	if want := 10000 / 3 * 2; added < want {
+		t.Errorf("exchanged %d new values, want at least %d", added, want)
+	}
+
+	if buckets := diff(a, b); len(buckets) != 0 {
+		t.Fatalf("buckets %v still differ after the exchange", buckets)
+	}
+	if ha, hb := a.Hashes(0, []int{0}), b.Hashes(0, []int{0}); !slices.Equal(ha, hb) {
+		t.Fatalf("root hashes %v and %v differ", ha, hb)
+	}
+	for v := 0; v < 10000; v++ {
+		if !a.Has(v) || !b.Has(v) {
+			t.Fatalf("value %d is missing after the exchange", v)
+		}
+	}
+}
+
+func TestDifferingSkipsOutOfRange(t *testing.T) {
+	tree := NewTree()
+	tree.Add(1, 2, 3)
+
+	if got := tree.Differing(depth+1, []int{0}, []uint64{0}); got != nil {
+		t.Errorf("Differing at level %d = %v, want nil", depth+1, got)
+	}
+	if got := tree.Differing(1, []int{-1, fanout}, []uint64{0, 0}); got != nil {
+		t.Errorf("Differing out of range = %v, want nil", got)
+	}
+}
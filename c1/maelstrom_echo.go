@@ -1,30 +1,35 @@
 package c1
 
 import (
-	"encoding/json"
 	"log"
 
+	"github.com/HdkTvd/advent-of-distributed-systems/schema"
+	"github.com/HdkTvd/advent-of-distributed-systems/workload"
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
 )
 
+func init() {
+	workload.Register("echo", SetupEcho)
+}
+
 func Maelstrom_echo() {
 	n := maelstrom.NewNode()
-	n.Handle("echo", func(msg maelstrom.Message) error {
-		// Unmarshal the message body as an loosely-typed map.
-		var body map[string]interface{}
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
-		}
-
-		// Update the message type to return back.
-		body["type"] = "echo_ok"
-
-		// Echo the original message back with the updated message type.
-		return n.Reply(msg, body)
-	})
+	SetupEcho(n)
 
 	if err := n.Run(); err != nil {
 		log.Fatal(err)
 	}
+}
 
+// SetupEcho registers the echo handler on n.
+func SetupEcho(n *maelstrom.Node) {
+	n.Handle("echo", func(msg maelstrom.Message) error {
+		body, err := schema.Decode[schema.Echo](msg)
+		if err != nil {
+			return err
+		}
+
+		// Echo the original value back with the updated message type.
+		return n.Reply(msg, schema.NewEchoOK(body.Echo))
+	})
 }
@@ -1,31 +1,34 @@
 package c2
 
 import (
-	"encoding/json"
 	"log"
 
+	"github.com/HdkTvd/advent-of-distributed-systems/schema"
+	"github.com/HdkTvd/advent-of-distributed-systems/workload"
 	"github.com/google/uuid"
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
 )
 
+func init() {
+	workload.Register("unique-ids", SetupUniqueIDGeneration)
+}
+
 func Unique_id_generation() {
 	n := maelstrom.NewNode()
+	SetupUniqueIDGeneration(n)
+
+	if err := n.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// SetupUniqueIDGeneration registers the generate handler on n.
+func SetupUniqueIDGeneration(n *maelstrom.Node) {
 	n.Handle("generate", func(msg maelstrom.Message) error {
-		// Unmarshal the message body as an loosely-typed map.
-		var body map[string]interface{}
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
+		if _, err := schema.Decode[schema.Generate](msg); err != nil {
 			return err
 		}
 
-		// Update the message type to return back.
-		body["type"] = "generate_ok"
-		body["id"] = uuid.New()
-
-		// Echo the original message back with the updated message type.
-		return n.Reply(msg, body)
+		return n.Reply(msg, schema.NewGenerateOK(uuid.New().String()))
 	})
-
-	if err := n.Run(); err != nil {
-		log.Fatal(err)
-	}
 }
@@ -0,0 +1,239 @@
+package c3
+
+import (
+	"log"
+	"log/slog"
+	"math/rand"
+	"sync"
+
+	"github.com/HdkTvd/advent-of-distributed-systems/logging"
+	"github.com/HdkTvd/advent-of-distributed-systems/schema"
+	"github.com/HdkTvd/advent-of-distributed-systems/sim"
+	"github.com/HdkTvd/advent-of-distributed-systems/workload"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Bracha reliable broadcast (Bracha, Asynchronous Byzantine Agreement Protocols), for n > 3f nodes of which
+// up to f = (n-1)/3 may be Byzantine
+// 1. The node a client broadcasts to numbers the value and sends bracha_send to every node.
+// 2. A node echoes the first value the sender itself sent for a (sender, seq) to every node.
+// 3. A node that has matching echoes from more than (n+f)/2 nodes, or matching readies from f+1, sends
+// bracha_ready for the value to every node, once. Any two echo quorums share a correct node, which echoes
+// one value only, and f+1 readies include a correct node's, so correct nodes are never ready for two values.
+// 4. 2f+1 matching readies deliver the value. f+1 of them come from correct nodes and make every correct
+// node ready, so either all correct nodes deliver the value or none does.
+// 5. Every message is retried until it is acknowledged, and only the first echo and the first ready of a
+// node count for each (sender, seq).
+
+func init() {
+	workload.Register("broadcast-bracha", SetupBrachaBroadcast)
+}
+
+func Bracha_broadcast() {
+	n := maelstrom.NewNode()
+	SetupBrachaBroadcast(n)
+
+	if err := n.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+type brachaKey struct {
+	sender string
+	seq    int
+}
+
+// brachaInstance is the state of one (sender, seq).
+type brachaInstance struct {
+	// echoes and readies hold the value every node echoed or was ready for
+	echoes  map[string]int
+	readies map[string]int
+	// echoed is set once the node echoed value, readied once it sent its ready
+	echoed    bool
+	value     int
+	readied   bool
+	delivered bool
+}
+
+// brachaSend is a message sent until the destination acknowledges it.
+type brachaSend struct {
+	dest string
+	body schema.Bracha
+	done bool
+}
+
+type bracha struct {
+	mu sync.Mutex
+	n  *maelstrom.Node
+
+	seq       int
+	instances map[brachaKey]*brachaInstance
+	delivered []schema.Delivery
+
+	clock  sim.Clock
+	rng    *rand.Rand
+	logger *slog.Logger
+}
+
+// SetupBrachaBroadcast registers the broadcast, read and topology handlers on n, delivering every value on
+// all correct nodes or on none while up to a third of the nodes lie.
+func SetupBrachaBroadcast(n *maelstrom.Node) {
+	env := sim.For(n)
+	b := &bracha{
+		n:         n,
+		instances: make(map[brachaKey]*brachaInstance),
+		clock:     env.Clock,
+		rng:       env.Rand,
+		logger:    logging.For(n),
+	}
+
+	n.Handle("broadcast", func(msg maelstrom.Message) error {
+		body, err := schema.Decode[schema.Broadcast](msg)
+		if err != nil {
+			return err
+		}
+
+		b.mu.Lock()
+		b.seq++
+		b.all(schema.NewBracha("bracha_send", n.ID(), b.seq, body.Message))
+		b.mu.Unlock()
+
+		return n.Reply(msg, schema.NewBroadcastOK())
+	})
+
+	n.Handle("read", func(msg maelstrom.Message) error {
+		b.mu.Lock()
+		deliveries := append([]schema.Delivery(nil), b.delivered...)
+		b.mu.Unlock()
+
+		return n.Reply(msg, schema.NewBrachaReadOK(deliveries))
+	})
+
+	// Every node sends to every other node, the topology is not needed
+	n.Handle("topology", func(msg maelstrom.Message) error {
+		return n.Reply(msg, schema.NewTopologyOK())
+	})
+
+	for _, typ := range []string{"bracha_send", "bracha_echo", "bracha_ready"} {
+		n.Handle(typ, func(msg maelstrom.Message) error {
+			body, err := schema.Decode[schema.Bracha](msg)
+			if err != nil {
+				return err
+			}
+
+			b.mu.Lock()
+			b.receive(msg.Src, body)
+			b.mu.Unlock()
+
+			return n.Reply(msg, schema.NewBrachaOK())
+		})
+	}
+}
+
+// receive handles a bracha message from src. Callers hold mu.
+func (b *bracha) receive(src string, body schema.Bracha) {
+	key := brachaKey{body.Sender, body.Seq}
+	inst, ok := b.instances[key]
+	if !ok {
+		inst = &brachaInstance{echoes: make(map[string]int), readies: make(map[string]int)}
+		b.instances[key] = inst
+	}
+
+	nodes := len(b.n.NodeIDs())
+	f := (nodes - 1) / 3
+
+	switch body.Type {
+	case "bracha_send":
+		// Only the sender can start its instances, a retried send is acknowledged again
+		if src != body.Sender {
+			return
+		}
+		if inst.echoed {
+			if inst.value != body.Message {
+				b.logger.Warn("Sender equivocated", "sender", body.Sender, "seq", body.Seq, "echoed", inst.value, "message", body.Message)
+			}
+			return
+		}
+		inst.echoed, inst.value = true, body.Message
+		b.all(schema.NewBracha("bracha_echo", body.Sender, body.Seq, body.Message))
+
+	case "bracha_echo":
+		if _, ok := inst.echoes[src]; ok {
+			return
+		}
+		inst.echoes[src] = body.Message
+		if 2*matching(inst.echoes, body.Message) > nodes+f {
+			b.ready(inst, body)
+		}
+
+	case "bracha_ready":
+		if _, ok := inst.readies[src]; ok {
+			return
+		}
+		inst.readies[src] = body.Message
+		count := matching(inst.readies, body.Message)
+		if count >= f+1 {
+			b.ready(inst, body)
+		}
+		if count >= 2*f+1 && !inst.delivered {
+			inst.delivered = true
+			b.delivered = append(b.delivered, schema.Delivery{Sender: body.Sender, Seq: body.Seq, Message: body.Message})
+			b.logger.Debug("Delivered", "sender", body.Sender, "seq", body.Seq, "message", body.Message)
+		}
+	}
+}
+
+// ready sends the node's ready for the value of body, unless it sent one. Callers hold mu.
+func (b *bracha) ready(inst *brachaInstance, body schema.Bracha) {
+	if inst.readied {
+		return
+	}
+	inst.readied = true
+	b.all(schema.NewBracha("bracha_ready", body.Sender, body.Seq, body.Message))
+}
+
+// all sends body to every other node and handles it locally. Callers hold mu.
+func (b *bracha) all(body schema.Bracha) {
+	for _, id := range b.n.NodeIDs() {
+		if id != b.n.ID() {
+			b.send(&brachaSend{dest: id, body: body}, 1)
+		}
+	}
+	b.receive(b.n.ID(), body)
+}
+
+// send sends s and retries it with backoff until it is acknowledged. Callers hold mu.
+func (b *bracha) send(s *brachaSend, attempt int) {
+	if err := b.n.RPC(s.dest, s.body, func(msg maelstrom.Message) error {
+		if _, err := schema.DecodeReply[schema.BrachaOK](msg, "bracha_ok"); err != nil {
+			return err
+		}
+
+		b.mu.Lock()
+		s.done = true
+		b.mu.Unlock()
+		return nil
+	}); err != nil {
+		b.logger.Error("Failed to send", "dest", s.dest, "type", s.body.Type, "err", err)
+	}
+
+	b.clock.AfterFunc(backoff(b.rng, attempt), func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if !s.done {
+			b.send(s, attempt+1)
+		}
+	})
+}
+
+// matching counts the nodes in votes that voted for value.
+func matching(votes map[string]int, value int) int {
+	count := 0
+	for _, v := range votes {
+		if v == value {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,255 @@
+package c3
+
+import (
+	"log"
+	"log/slog"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/HdkTvd/advent-of-distributed-systems/logging"
+	"github.com/HdkTvd/advent-of-distributed-systems/membership"
+	"github.com/HdkTvd/advent-of-distributed-systems/schema"
+	"github.com/HdkTvd/advent-of-distributed-systems/sim"
+	"github.com/HdkTvd/advent-of-distributed-systems/workload"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Causal broadcast with vector clocks
+// 1. A node delivers a client's value as the next value of its own origin, depending on everything it
+// delivered before. A client may send the clock of an earlier read along, which the node refuses until it
+// delivered everything the clock counts, so only events that exist ever wait for each other.
+// 2. Every causalPullInterval each node sends its vector clock to its neighbours, which answer with the
+// events it misses in the order they delivered them. Lost pulls are simply sent again.
+// 3. An event is delivered once it is the next one of its origin and all its dependencies are delivered,
+// until then it waits in a buffer.
+// 4. read returns the values in delivery order, so a reply never comes before the value it replies to, and
+// the clock a client passes with its reply to keep it that way on any other node.
+
+const (
+	causalPullInterval = 100 * time.Millisecond
+	// maxCausalEvents caps the events of one causal_pull_ok, every event carries a vector clock and replies
+	// must stay below maelstrom's 64KiB line limit
+	maxCausalEvents = 128
+)
+
+func init() {
+	workload.Register("broadcast-causal", SetupCausalBroadcast)
+}
+
+func Causal_broadcast() {
+	n := maelstrom.NewNode()
+	SetupCausalBroadcast(n)
+
+	if err := n.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+type causalBroadcast struct {
+	mu    sync.Mutex
+	n     *maelstrom.Node
+	peers *membership.Static
+
+	// delivered is the vector clock of the node, the number of delivered values of every origin
+	delivered map[string]int
+	log       []schema.CausalEvent
+	// pending holds the events that arrived before their dependencies, by origin and seq
+	pending map[string]map[int]schema.CausalEvent
+
+	clock  sim.Clock
+	logger *slog.Logger
+}
+
+// SetupCausalBroadcast registers the broadcast, read and topology handlers on n, delivering values in causal
+// order.
+func SetupCausalBroadcast(n *maelstrom.Node) {
+	env := sim.For(n)
+	cb := &causalBroadcast{
+		n:         n,
+		peers:     membership.NewStatic(),
+		delivered: make(map[string]int),
+		pending:   make(map[string]map[int]schema.CausalEvent),
+		clock:     env.Clock,
+		logger:    logging.For(n),
+	}
+
+	n.Handle("init", func(msg maelstrom.Message) error {
+		env.Clock.Go(cb.pullLoop)
+		return nil
+	})
+
+	n.Handle("broadcast", func(msg maelstrom.Message) error {
+		body, err := schema.Decode[schema.CausalBroadcast](msg)
+		if err != nil {
+			return err
+		}
+
+		for origin := range body.Clock {
+			if !slices.Contains(n.NodeIDs(), origin) {
+				return maelstrom.NewRPCError(maelstrom.MalformedRequest, "clock names unknown node "+origin)
+			}
+		}
+
+		if err := cb.broadcast(body.Message, body.Clock); err != nil {
+			return err
+		}
+		return n.Reply(msg, schema.NewBroadcastOK())
+	})
+
+	n.Handle("read", func(msg maelstrom.Message) error {
+		cb.mu.Lock()
+		values := make([]int, len(cb.log))
+		for i, e := range cb.log {
+			values[i] = e.Message
+		}
+		clock := maps.Clone(cb.delivered)
+		cb.mu.Unlock()
+
+		return n.Reply(msg, schema.NewCausalReadOK(values, clock))
+	})
+
+	n.Handle("topology", func(msg maelstrom.Message) error {
+		body, err := schema.Decode[schema.Topology](msg)
+		if err != nil {
+			return err
+		}
+
+		cb.peers.Add(body.Topology[n.ID()]...)
+		return n.Reply(msg, schema.NewTopologyOK())
+	})
+
+	n.Handle("causal_pull", func(msg maelstrom.Message) error {
+		body, err := schema.Decode[schema.CausalPull](msg)
+		if err != nil {
+			return err
+		}
+
+		return n.Reply(msg, schema.NewCausalPullOK(cb.eventsAfter(body.Clock)))
+	})
+}
+
+// broadcast adds a value from a client as the node's next event, after everything delivered. It fails while
+// deps counts values the node has not delivered, which a clock from another node's read can until the pulls
+// catch up, and which a made up clock never does.
+func (cb *causalBroadcast) broadcast(value int, deps map[string]int) error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	for origin, count := range deps {
+		if count > cb.delivered[origin] {
+			return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, "clock counts values not delivered yet")
+		}
+	}
+
+	id := cb.n.ID()
+	e := schema.CausalEvent{Origin: id, Seq: cb.delivered[id] + 1, Message: value, Deps: make(map[string]int)}
+	for origin, count := range cb.delivered {
+		if origin != id && count > 0 {
+			e.Deps[origin] = count
+		}
+	}
+
+	cb.receive(e)
+	return nil
+}
+
+// receive buffers e and delivers every event whose dependencies are met. Callers hold mu.
+func (cb *causalBroadcast) receive(events ...schema.CausalEvent) {
+	for _, e := range events {
+		if e.Seq <= cb.delivered[e.Origin] {
+			continue
+		}
+		if cb.pending[e.Origin] == nil {
+			cb.pending[e.Origin] = make(map[int]schema.CausalEvent)
+		}
+		cb.pending[e.Origin][e.Seq] = e
+	}
+
+	origins := make([]string, 0, len(cb.pending))
+	for origin := range cb.pending {
+		origins = append(origins, origin)
+	}
+	sort.Strings(origins)
+
+	for progress := true; progress; {
+		progress = false
+		for _, origin := range origins {
+			e, ok := cb.pending[origin][cb.delivered[origin]+1]
+			if !ok || !cb.satisfied(e) {
+				continue
+			}
+
+			delete(cb.pending[origin], e.Seq)
+			cb.delivered[origin] = e.Seq
+			cb.log = append(cb.log, e)
+			progress = true
+		}
+	}
+
+	waiting := 0
+	for _, origin := range origins {
+		waiting += len(cb.pending[origin])
+		if len(cb.pending[origin]) == 0 {
+			delete(cb.pending, origin)
+		}
+	}
+	if waiting > 0 {
+		cb.logger.Debug("Events wait for their dependencies", "waiting", waiting)
+	}
+}
+
+// satisfied reports whether every dependency of e is delivered. Callers hold mu.
+func (cb *causalBroadcast) satisfied(e schema.CausalEvent) bool {
+	for origin, count := range e.Deps {
+		if cb.delivered[origin] < count {
+			return false
+		}
+	}
+	return true
+}
+
+// eventsAfter returns up to maxCausalEvents delivered events that clock does not count, in delivery order.
+func (cb *causalBroadcast) eventsAfter(clock map[string]int) []schema.CausalEvent {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	var events []schema.CausalEvent
+	for _, e := range cb.log {
+		if e.Seq > clock[e.Origin] {
+			events = append(events, e)
+			if len(events) == maxCausalEvents {
+				break
+			}
+		}
+	}
+	return events
+}
+
+func (cb *causalBroadcast) pullLoop() {
+	for {
+		cb.clock.Sleep(causalPullInterval)
+
+		cb.mu.Lock()
+		body := schema.NewCausalPull(maps.Clone(cb.delivered))
+		cb.mu.Unlock()
+
+		for _, peer := range cb.peers.Neighbors() {
+			if err := cb.n.RPC(peer, body, func(msg maelstrom.Message) error {
+				reply, err := schema.DecodeReply[schema.CausalPullOK](msg, "causal_pull_ok")
+				if err != nil {
+					return err
+				}
+
+				cb.mu.Lock()
+				cb.receive(reply.Events...)
+				cb.mu.Unlock()
+				return nil
+			}); err != nil {
+				cb.logger.Error("Failed to send", "dest", peer, "err", err)
+			}
+		}
+	}
+}
@@ -1,195 +1,256 @@
 package c3
 
 import (
-	"encoding/json"
-	"errors"
-	"fmt"
 	"log"
+	"log/slog"
 	"math/rand"
-	"os"
-	"reflect"
-	"strconv"
 	"sync"
 	"time"
 
 	mst "github.com/HdkTvd/advent-of-distributed-systems/MST"
+	"github.com/HdkTvd/advent-of-distributed-systems/antientropy"
+	"github.com/HdkTvd/advent-of-distributed-systems/logging"
+	"github.com/HdkTvd/advent-of-distributed-systems/membership"
+	"github.com/HdkTvd/advent-of-distributed-systems/schema"
+	"github.com/HdkTvd/advent-of-distributed-systems/sim"
+	"github.com/HdkTvd/advent-of-distributed-systems/workload"
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
 )
 
 // 1. Do not broadcast msg, instead follow point 2.
-// 2. Keep track of messages on neighbouring nodes. After some period of time get all the messages from neighbours
+// 2. Keep track of messages on neighbouring nodes. After some period of time pull the messages neighbours
+// learned since the last pull, so the payload only grows with new messages.
 // no. of msgs per ops pre node is reduced.
 
+// topologySeed seeds the configured topology generator, the same on every node so they build the same graph.
+const topologySeed = 1
+
+// maxPullEntries caps the log entries sent in one pull_ok, the rest follow in the next pulls.
+const maxPullEntries = 4096
+
 type Node struct {
-	Values   map[int]bool
-	Topology []string
-	Mu       *sync.Mutex
+	Values map[int]bool
+	// Log holds every value in the order the node learned it, peers pull it by offset
+	Log []int
+	// Peers are the neighbours the node pulls from
+	Peers membership.View
+	// Watermarks is the offset into each neighbour's log pulled so far
+	Watermarks map[string]int
+	// AntiEntropy, if set, is told about every value added to the log
+	AntiEntropy *antientropy.AntiEntropy
+	Mu          *sync.Mutex
 }
 
 func NewNode() *Node {
 	values := make(map[int]bool, 0)
-	topology := make([]string, 0)
+	peers := membership.NewStatic()
+	watermarks := make(map[string]int)
 	mu := &sync.Mutex{}
 
-	return &Node{values, topology, mu}
+	return &Node{Values: values, Peers: peers, Watermarks: watermarks, Mu: mu}
+}
+
+// add appends the values the node has not seen yet to its log. Callers hold Mu.
+func (node *Node) add(values ...int) {
+	for _, v := range values {
+		if !node.Values[v] {
+			node.Values[v] = true
+			node.Log = append(node.Log, v)
+			if node.AntiEntropy != nil {
+				node.AntiEntropy.Add(v)
+			}
+		}
+	}
+}
+
+// entriesAfter returns up to maxPullEntries log entries after the first after ones, and the
+// offset following them. An offset past the end of the log starts over from the beginning. Callers hold Mu.
+func (node *Node) entriesAfter(after int) ([]int, int) {
+	if after > len(node.Log) {
+		after = 0
+	}
+
+	next := min(after+maxPullEntries, len(node.Log))
+	return append([]int(nil), node.Log[after:next]...), next
+}
+
+func init() {
+	workload.Register("broadcast-efficient", SetupEfficientBroadcast)
+	workload.Register("broadcast-efficient-hyparview", SetupEfficientBroadcastHyParView)
+	workload.Register("broadcast-efficient-latency", SetupEfficientBroadcastLatency)
+	workload.Register("broadcast-efficient-latency-diameter", SetupEfficientBroadcastLatencyDiameter)
 }
 
 func Efficient_broadcast() {
-	ln := NewNode()
 	n := maelstrom.NewNode()
+	SetupEfficientBroadcast(n)
+
+	if err := n.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// SetupEfficientBroadcast registers the efficient broadcast handlers on n, pulling along a spanning tree the
+// nodes build together.
+func SetupEfficientBroadcast(n *maelstrom.Node) {
+	setupEfficientBroadcast(n, nil)
+}
+
+// SetupEfficientBroadcastHyParView is SetupEfficientBroadcast pulling from the neighbours of a HyParView
+// overlay instead of the spanning tree.
+func SetupEfficientBroadcastHyParView(n *maelstrom.Node) {
+	setupEfficientBroadcast(n, membership.NewHyParView(n, membership.DefaultConfig()))
+}
+
+// SetupEfficientBroadcastLatency is SetupEfficientBroadcast pulling along the minimum spanning tree of the
+// round trip times the nodes measure.
+func SetupEfficientBroadcastLatency(n *maelstrom.Node) {
+	setupEfficientBroadcast(n, membership.NewLatencyTree(n, membership.DefaultLatencyConfig()))
+}
+
+// SetupEfficientBroadcastLatencyDiameter is SetupEfficientBroadcastLatency with the tree of the smallest
+// diameter instead of the least total round trip time.
+func SetupEfficientBroadcastLatencyDiameter(n *maelstrom.Node) {
+	cfg := membership.DefaultLatencyConfig()
+	cfg.Build = mst.MinimumDiameterTreeBy
+	cfg.Cost = mst.Diameter
+	setupEfficientBroadcast(n, membership.NewLatencyTree(n, cfg))
+}
+
+// overlay is a view that maintains itself once started.
+type overlay interface {
+	membership.View
+	Start()
+}
+
+// setupEfficientBroadcast pulls from the neighbours of overlay, or of a spanning tree over the topology if it
+// is nil.
+func setupEfficientBroadcast(n *maelstrom.Node, overlay overlay) {
+	ln := NewNode()
+	tree := membership.NewSpanningTree(n, membership.DefaultTreeConfig())
+	ln.Peers = tree
+	if overlay != nil {
+		ln.Peers = overlay
+	}
+
+	env := sim.For(n)
+	logger := logging.For(n)
+
+	// Anti-entropy repairs whatever the pulls missed
+	ln.AntiEntropy = antientropy.New(n, ln.Peers, func(values []int, src string) {
+		ln.Mu.Lock()
+		ln.add(values...)
+		ln.Mu.Unlock()
+	})
 
 	n.Handle("init", func(msg maelstrom.Message) error {
-		waitPeriod := generateRandomWaitPeriod(n.ID())
+		waitPeriod := generateRandomWaitPeriod(env.Rand)
+		logger.Info("Starting the node", "wait_period", time.Duration(waitPeriod)*time.Millisecond)
 
-		go ln.askForMessagesAndWriteItOnLocal(n, waitPeriod)
+		env.Clock.Go(func() { ln.askForMessagesAndWriteItOnLocal(n, env.Clock, logger, waitPeriod) })
+		ln.AntiEntropy.Start(antiEntropyInterval)
 
-		if n.ID() == "n0" {
-			fmt.Fprintln(os.Stderr, "MST running...")
-			topology := mst.MinimumSpanningTree(len(n.NodeIDs()))
-			ln.Topology = topology[n.ID()]
-			shareTopology(n, topology)
+		if overlay != nil {
+			overlay.Start()
+			return nil
 		}
 
+		// Every node builds the same configured graph from the shared seed, otherwise the tree spans the
+		// topology maelstrom sends
+		if gen, spec, ok := mst.Configured(); ok {
+			graph := gen(n.NodeIDs(), rand.New(rand.NewSource(topologySeed)))
+			if stats, err := mst.Validate(graph); err != nil {
+				logger.Error("Invalid topology", "topology", spec, "err", err)
+			} else {
+				logger.Info("Topology built", "topology", spec, "edges", stats.Edges, "diameter", stats.Diameter, "max_degree", stats.MaxDegree)
+			}
+			tree.SetGraph(graph[n.ID()])
+		}
+		tree.Start()
+
 		return nil
 	})
 
 	n.Handle("broadcast", func(msg maelstrom.Message) error {
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
+		body, err := schema.Decode[schema.Broadcast](msg)
+		if err != nil {
 			return err
 		}
 
-		message := int(reflect.ValueOf(body["message"]).Float())
-
 		ln.Mu.Lock()
-		ln.Values[message] = true
+		ln.add(body.Message)
 		ln.Mu.Unlock()
 
-		return n.Reply(msg, map[string]any{"type": "broadcast_ok"})
+		return n.Reply(msg, schema.NewBroadcastOK())
 	})
 
 	n.Handle("read", func(msg maelstrom.Message) error {
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
-		}
-
-		body = map[string]any{}
-
-		if mc, ok := body["msg_count"].(int); ok && mc >= len(ln.Values) {
-			body["type"] = "read_no_content"
-		} else {
-			body["type"] = "read_ok"
+		ln.Mu.Lock()
+		keys := append([]int(nil), ln.Log...)
+		ln.Mu.Unlock()
 
-			var keys []any
-			ln.Mu.Lock()
-			for k := range ln.Values {
-				keys = append(keys, k)
-			}
-			ln.Mu.Unlock()
+		return n.Reply(msg, schema.NewReadOK(keys))
+	})
 
-			body["messages"] = keys
+	n.Handle("pull", func(msg maelstrom.Message) error {
+		body, err := schema.Decode[schema.Pull](msg)
+		if err != nil {
+			return err
 		}
 
-		return n.Reply(msg, body)
+		ln.Mu.Lock()
+		messages, next := ln.entriesAfter(body.After)
+		ln.Mu.Unlock()
+
+		return n.Reply(msg, schema.NewPullOK(messages, next))
 	})
 
 	n.Handle("topology", func(msg maelstrom.Message) error {
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
+		body, err := schema.Decode[schema.Topology](msg)
+		if err != nil {
 			return err
 		}
 
-		replyBody := map[string]any{}
-		replyBody["type"] = "topology_ok"
-
-		if source, _ := body["source"].(string); source != "nodeServer" {
-			return n.Reply(msg, replyBody)
-		}
-
-		topologyFromClient := body["topology"].(map[string]interface{})
-
-		for _, neighbor := range topologyFromClient[n.ID()].([]interface{}) {
-			ln.Topology = append(ln.Topology, neighbor.(string))
+		if _, _, ok := mst.Configured(); !ok {
+			tree.SetGraph(body.Topology[n.ID()])
 		}
 
-		return n.Reply(msg, replyBody)
+		return n.Reply(msg, schema.NewTopologyOK())
 	})
-
-	if err := n.Run(); err != nil {
-		log.Fatal(err)
-	}
 }
 
-func (node *Node) askForMessagesAndWriteItOnLocal(mn *maelstrom.Node, waitPeriod int) {
+func (node *Node) askForMessagesAndWriteItOnLocal(mn *maelstrom.Node, clock sim.Clock, logger *slog.Logger, waitPeriod int) {
 	for {
-		time.Sleep(time.Millisecond * time.Duration(waitPeriod))
-		for _, neighbor := range node.Topology {
-			payload := map[string]interface{}{
-				"type":      "read",
-				"msg_count": len(node.Values),
-			}
+		clock.Sleep(time.Millisecond * time.Duration(waitPeriod))
 
-			if err := mn.RPC(neighbor, payload, func(msg maelstrom.Message) error {
-				var body map[string]any
-				if err := json.Unmarshal(msg.Body, &body); err != nil {
+		for _, neighbor := range node.Peers.Neighbors() {
+			node.Mu.Lock()
+			after := node.Watermarks[neighbor]
+			node.Mu.Unlock()
+
+			if err := mn.RPC(neighbor, schema.NewPull(after), func(msg maelstrom.Message) error {
+				body, err := schema.DecodeReply[schema.PullOK](msg, "pull_ok")
+				if err != nil {
 					return err
 				}
 
-				if messages, ok := body["messages"].([]interface{}); ok && body["type"] == "read_ok" {
-					node.Mu.Lock()
-					for _, m := range messages {
-						node.Values[int(m.(float64))] = true
-					}
-					node.Mu.Unlock()
-
-					return nil
+				node.Mu.Lock()
+				node.add(body.Messages...)
+				// A late reply to an older pull must not move the watermark back
+				if node.Watermarks[neighbor] == after {
+					node.Watermarks[neighbor] = body.Next
 				}
+				node.Mu.Unlock()
 
 				return nil
 			}); err != nil {
-				fmt.Fprintf(os.Stderr, "Error getting messages from neighbor node - %v\n", neighbor)
+				logger.Error("Error getting messages from neighbor node", "dest", neighbor, "err", err)
 			}
 		}
 	}
 }
 
-func shareTopology(mn *maelstrom.Node, topology map[string][]string) {
-	payload := map[string]interface{}{
-		"type":     "topology",
-		"topology": topology,
-		"source":   "nodeServer",
-	}
-
-	for _, n := range mn.NodeIDs() {
-		// n0 Node shares the topology to every other node
-		if n == "n0" {
-			continue
-		}
-		mn.RPC(n, payload, func(msg maelstrom.Message) error {
-			var body map[string]any
-			if err := json.Unmarshal(msg.Body, &body); err != nil {
-				return err
-			}
-
-			if body["type"] != "topology_ok" {
-				return errors.New("error sharing topology")
-			}
-
-			return nil
-		})
-	}
-}
-
-func generateRandomWaitPeriod(nodeId string) int {
-	nodeNumber, _ := strconv.Atoi(nodeId[len(nodeId)-1:])
-
-	s2 := rand.NewSource(time.Now().UnixNano() + int64(nodeNumber*10))
-	r2 := rand.New(s2)
+func generateRandomWaitPeriod(rng *rand.Rand) int {
 	max, min := 200, 100
-	waitPeriod := r2.Intn(max-min) + min
-
-	fmt.Fprintf(os.Stderr, "Starting the node with wait period of %vms\n", waitPeriod)
-
-	return waitPeriod
+	return rng.Intn(max-min) + min
 }
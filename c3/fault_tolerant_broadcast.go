@@ -2,172 +2,597 @@ package c3
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
 	"log"
-	"os"
-	"reflect"
+	"log/slog"
+	"math/rand"
+	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/HdkTvd/advent-of-distributed-systems/antientropy"
+	"github.com/HdkTvd/advent-of-distributed-systems/logging"
+	"github.com/HdkTvd/advent-of-distributed-systems/membership"
+	"github.com/HdkTvd/advent-of-distributed-systems/schema"
+	"github.com/HdkTvd/advent-of-distributed-systems/sim"
+	"github.com/HdkTvd/advent-of-distributed-systems/wal"
+	"github.com/HdkTvd/advent-of-distributed-systems/workload"
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
 )
 
 type job struct {
 	Src   string
 	Dest  string
-	Value any
+	Value int
 }
 
 type persistentQueue struct {
 	mu     sync.Mutex
 	ackMap map[job]bool
+	// log, once opened, keeps the values, jobs and acks across restarts
+	log    *wal.Log
+	logger *slog.Logger
 }
 
-func newPersistentQueue() *persistentQueue {
+func newPersistentQueue(logger *slog.Logger) *persistentQueue {
 	return &persistentQueue{
 		ackMap: make(map[job]bool),
+		logger: logger,
 	}
 }
 
-func (pq *persistentQueue) markAcked(j job) {
+// walRecord is a line of the log. An enqueue record stands for a job of every value to every dest, an ack
+// record for the acks of every value by its one dest.
+type walRecord struct {
+	Op     string   `json:"op"`
+	Values []int    `json:"values,omitempty"`
+	Dests  []string `json:"dests,omitempty"`
+}
+
+const (
+	opTopology = "topology"
+	opValue    = "value"
+	opEnqueue  = "enqueue"
+	opAck      = "ack"
+)
+
+func (pq *persistentQueue) setLog(l *wal.Log) {
+	pq.mu.Lock()
+	pq.log = l
+	pq.mu.Unlock()
+}
+
+// record appends rec to the log and calls done once it is durable, or right away if there is no log. done
+// gets the error if rec could not be appended.
+func (pq *persistentQueue) record(rec walRecord, done func(err error)) {
 	pq.mu.Lock()
-	pq.ackMap[j] = true
+	l := pq.log
 	pq.mu.Unlock()
+
+	if l == nil {
+		if done != nil {
+			done(nil)
+		}
+		return
+	}
+
+	var durable func()
+	if done != nil {
+		durable = func() { done(nil) }
+	}
+	if err := l.Append(rec, durable); err != nil {
+		pq.logger.Error("Failed to append to the log", "op", rec.Op, "err", err)
+		if done != nil {
+			done(err)
+		}
+	}
 }
 
-func (pq *persistentQueue) isAcked(j job) bool {
+func (pq *persistentQueue) markAcked(jobs ...job) {
+	pq.mu.Lock()
+	for _, j := range jobs {
+		pq.ackMap[j] = true
+	}
+	pq.mu.Unlock()
+
+	// A lost ack only makes the batch be sent again after a restart
+	byDest := make(map[string][]int)
+	var dests []string
+	for _, j := range jobs {
+		if _, ok := byDest[j.Dest]; !ok {
+			dests = append(dests, j.Dest)
+		}
+		byDest[j.Dest] = append(byDest[j.Dest], j.Value)
+	}
+	for _, dest := range dests {
+		pq.record(walRecord{Op: opAck, Values: byDest[dest], Dests: []string{dest}}, nil)
+	}
+}
+
+// isAcked reports whether every job is acknowledged.
+func (pq *persistentQueue) isAcked(jobs ...job) bool {
 	pq.mu.Lock()
 	defer pq.mu.Unlock()
-	return pq.ackMap[j]
+	for _, j := range jobs {
+		if !pq.ackMap[j] {
+			return false
+		}
+	}
+	return true
 }
 
-func consumeJobChannel(jobChan chan job, pq *persistentQueue, n *maelstrom.Node) {
-	log.Printf("Started job queue")
+const (
+	// batchWindow is how long jobs for a destination are collected before they
+	// are sent together.
+	batchWindow = 50 * time.Millisecond
+	maxBatch    = 256
+	// maxInFlight bounds the unacknowledged batches of a destination, and maxQueued the jobs waiting behind
+	// them. Jobs beyond that are dropped and left to anti-entropy, so a partition never blocks the handlers.
+	maxInFlight = 2
+	maxQueued   = 4096
+	// Retries back off exponentially from retryBase up to retryCap, with jitter.
+	retryBase = 200 * time.Millisecond
+	retryCap  = 2 * time.Second
+
+	statsInterval = 5 * time.Second
+)
 
-	factor := 50
+// antiEntropyInterval is how often a node compares its values with a random neighbor.
+const antiEntropyInterval = time.Second
 
-	for j := range jobChan {
-		go func(jb job) {
-			body := map[string]any{}
-			body["type"] = "broadcast"
-			body["message"] = jb.Value
+// jobQueue coalesces the jobs of each destination into broadcast_batch
+// messages and delivers each until the destination acknowledges it.
+type jobQueue struct {
+	mu     sync.Mutex
+	dests  map[string]*destQueue
+	stats  queueStats
+	pq     *persistentQueue
+	n      *maelstrom.Node
+	clock  sim.Clock
+	rng    *rand.Rand
+	logger *slog.Logger
+}
 
-			attempt := 0
-			for {
-				if err := n.RPC(jb.Dest, body, func(msg maelstrom.Message) error {
-					resBody := map[string]any{}
-					if err := json.Unmarshal(msg.Body, &resBody); err != nil {
-						return err
-					}
-					typ := reflect.ValueOf(resBody["type"]).String()
-					if typ != "broadcast_ok" {
-						return errors.New("broadcast not ok")
-					}
+type destQueue struct {
+	pending  []job
+	armed    bool
+	inFlight int
+}
 
-					fmt.Fprintf(os.Stderr, "Acknowledged msg - %v, dest - %v \n", jb.Value, jb.Dest)
-					pq.markAcked(jb)
+// batch is a broadcast_batch in flight, done once it is acknowledged.
+type batch struct {
+	dest string
+	jobs []job
+	done bool
+}
 
-					return nil
-				}); err != nil || !pq.isAcked(jb) {
-					attempt++
-					fmt.Fprintf(os.Stderr, "Retry %v after err %v, attempt %d\n", jb, "retrying because not acknowledged", attempt)
-					time.Sleep(time.Duration(attempt*factor) * time.Millisecond)
-					continue
-				}
+// queueStats counts jobs, except Retries and Sent which count batch sends.
+type queueStats struct {
+	Enqueued int
+	Dropped  int
+	Acked    int
+	Sent     int
+	Retries  int
+}
 
-				break
-			}
-		}(j)
+func newJobQueue(n *maelstrom.Node, pq *persistentQueue, clock sim.Clock, rng *rand.Rand, logger *slog.Logger) *jobQueue {
+	return &jobQueue{
+		dests:  make(map[string]*destQueue),
+		pq:     pq,
+		n:      n,
+		clock:  clock,
+		rng:    rng,
+		logger: logger,
 	}
 }
 
+// add queues jb behind its destination's batches, or drops it if the destination is too far behind.
+func (q *jobQueue) add(jb job) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	d, ok := q.dests[jb.Dest]
+	if !ok {
+		d = &destQueue{}
+		q.dests[jb.Dest] = d
+	}
+
+	if len(d.pending) >= maxQueued {
+		q.stats.Dropped++
+		q.logger.Debug("Dropping job of a full queue", "dest", jb.Dest, "message", jb.Value)
+		return
+	}
+
+	d.pending = append(d.pending, jb)
+	q.stats.Enqueued++
+	q.schedule(jb.Dest)
+}
+
+// schedule sends a full batch of dest right away and opens a batch window for the rest. Callers hold mu.
+func (q *jobQueue) schedule(dest string) {
+	d := q.dests[dest]
+	if len(d.pending) >= maxBatch {
+		q.pump(dest)
+	}
+	if len(d.pending) == 0 || d.armed {
+		return
+	}
+
+	d.armed = true
+	q.clock.AfterFunc(batchWindow, func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		d.armed = false
+		q.pump(dest)
+	})
+}
+
+// pump sends the pending jobs of dest while it has batches to spare. Callers hold mu.
+func (q *jobQueue) pump(dest string) {
+	d := q.dests[dest]
+	for d.inFlight < maxInFlight && len(d.pending) > 0 {
+		size := min(len(d.pending), maxBatch)
+		b := &batch{dest: dest, jobs: append([]job(nil), d.pending[:size]...)}
+		d.pending = d.pending[size:]
+		d.inFlight++
+
+		q.send(b, 1)
+	}
+}
+
+// send delivers b and sends it again, after a jittered exponential backoff, until it is acknowledged.
+// Callers hold mu.
+func (q *jobQueue) send(b *batch, attempt int) {
+	values := make([]int, len(b.jobs))
+	for i, jb := range b.jobs {
+		values[i] = jb.Value
+	}
+
+	q.stats.Sent++
+	if err := q.n.RPC(b.dest, schema.NewBroadcastBatch(values), func(msg maelstrom.Message) error {
+		if _, err := schema.DecodeReply[maelstrom.MessageBody](msg, "broadcast_batch_ok"); err != nil {
+			return err
+		}
+
+		q.logger.Debug("Acknowledged batch", logging.Msg(msg), "messages", len(b.jobs))
+		q.ack(b)
+		return nil
+	}); err != nil {
+		q.logger.Error("Failed to send batch", "dest", b.dest, "messages", len(b.jobs), "err", err)
+	}
+
+	q.clock.AfterFunc(backoff(q.rng, attempt), func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		if b.done {
+			return
+		}
+		q.stats.Retries++
+		q.logger.Debug("Retrying because not acknowledged", "dest", b.dest, "messages", len(b.jobs), "attempt", attempt)
+		q.send(b, attempt+1)
+	})
+}
+
+// ack completes b, a retried batch can be acknowledged more than once.
+func (q *jobQueue) ack(b *batch) {
+	q.mu.Lock()
+	if b.done {
+		q.mu.Unlock()
+		return
+	}
+	b.done = true
+	q.stats.Acked += len(b.jobs)
+	q.dests[b.dest].inFlight--
+	q.schedule(b.dest)
+	q.mu.Unlock()
+
+	q.pq.markAcked(b.jobs...)
+}
+
+// backoff returns the wait before the next attempt, a random time in the upper half of
+// retryBase * 2^(attempt-1), capped at retryCap.
+func backoff(rng *rand.Rand, attempt int) time.Duration {
+	d := retryCap
+	if attempt <= 16 {
+		d = min(retryBase<<(attempt-1), retryCap)
+	}
+	return d/2 + time.Duration(rng.Int63n(int64(d/2)+1))
+}
+
+// logStats logs the queue depth and counters every statsInterval while they change.
+func (q *jobQueue) logStats() {
+	var last queueStats
+	for {
+		q.clock.Sleep(statsInterval)
+
+		q.mu.Lock()
+		stats := q.stats
+		queued, inFlight := 0, 0
+		for _, d := range q.dests {
+			queued += len(d.pending)
+			inFlight += d.inFlight
+		}
+		q.mu.Unlock()
+
+		if stats == last && queued == 0 {
+			continue
+		}
+		last = stats
+
+		q.logger.Info("Job queue", "queued", queued, "in_flight", inFlight, "enqueued", stats.Enqueued,
+			"acked", stats.Acked, "dropped", stats.Dropped, "sent", stats.Sent, "retries", stats.Retries)
+	}
+}
+
+func init() {
+	workload.Register("broadcast-fault-tolerant", SetupFaultTolerantBroadcast)
+}
+
 func Fault_tolerant_broadcast() {
 	n := maelstrom.NewNode()
+	SetupFaultTolerantBroadcast(n)
+
+	if err := n.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// SetupFaultTolerantBroadcast registers the fault tolerant broadcast handlers and starts its job queue on n.
+func SetupFaultTolerantBroadcast(n *maelstrom.Node) {
 
 	mu := &sync.Mutex{}
-	values := make(map[any]bool)
-	topology := make([]string, 0)
+	values := make(map[int]bool)
+	peers := membership.NewStatic()
+
+	env := sim.For(n)
+	logger := logging.For(n)
+	pq := newPersistentQueue(logger)
+	queue := newJobQueue(n, pq, env.Clock, env.Rand, logger)
+
+	// Without a data directory nothing is replayed and the node is ready right away
+	dataDir := wal.Dir()
+	ready := dataDir == ""
+
+	var ae *antientropy.AntiEntropy
+
+	// learn records the values and queues the new ones for every neighbor
+	// except the one they came from. done runs once they are durable, or
+	// with the error if they could not be logged. It reports false while the
+	// log is being replayed.
+	learn := func(messages []int, src string, done func(err error)) bool {
+		var fresh []int
+		mu.Lock()
+		if !ready {
+			mu.Unlock()
+			return false
+		}
+		for _, message := range messages {
+			if !values[message] {
+				values[message] = true
+				fresh = append(fresh, message)
+			}
+		}
+		mu.Unlock()
+
+		ae.Add(fresh...)
+
+		var dests []string
+		for _, neighbor := range peers.Neighbors() {
+			if neighbor != src {
+				dests = append(dests, neighbor)
+			}
+		}
+
+		if len(fresh) == 0 {
+			if done != nil {
+				done(nil)
+			}
+			return true
+		}
+		pq.record(walRecord{Op: opValue, Values: fresh}, nil)
+		pq.record(walRecord{Op: opEnqueue, Values: fresh, Dests: dests}, done)
+
+		for _, message := range fresh {
+			for _, neighbor := range dests {
+				logger.Debug("Adding job", "dest", neighbor, "message", message)
+				queue.add(job{
+					Src:   n.ID(),
+					Dest:  neighbor,
+					Value: message,
+				})
+			}
+		}
+		return true
+	}
 
-	jobChan := make(chan job, 100)
-	pq := newPersistentQueue()
+	// Anti-entropy catches up on values whose batches were given up on, e.g. during a long partition
+	ae = antientropy.New(n, peers, func(values []int, src string) {
+		learn(values, src, nil)
+	})
 
-	go consumeJobChannel(jobChan, pq, n)
+	// restore replays the log of a previous run, compacts it and resumes the unacknowledged jobs
+	restore := func() error {
+		path := filepath.Join(dataDir, n.ID()+".wal")
+
+		enqueued := make(map[job]bool)
+		var order []job
+		err := wal.Replay(path, func(line []byte) error {
+			var rec walRecord
+			if err := json.Unmarshal(line, &rec); err != nil {
+				return err
+			}
 
-	n.Handle("broadcast", func(msg maelstrom.Message) error {
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			switch rec.Op {
+			case opTopology:
+				peers.Add(rec.Dests...)
+			case opValue:
+				mu.Lock()
+				for _, v := range rec.Values {
+					values[v] = true
+				}
+				mu.Unlock()
+				ae.Add(rec.Values...)
+			case opEnqueue:
+				for _, v := range rec.Values {
+					for _, dest := range rec.Dests {
+						j := job{Src: n.ID(), Dest: dest, Value: v}
+						if !enqueued[j] {
+							enqueued[j] = true
+							order = append(order, j)
+						}
+					}
+				}
+			case opAck:
+				if len(rec.Dests) != 1 {
+					return fmt.Errorf("ack record with %d dests", len(rec.Dests))
+				}
+				for _, v := range rec.Values {
+					pq.markAcked(job{Src: n.ID(), Dest: rec.Dests[0], Value: v})
+				}
+			}
+			return nil
+		})
+		if err != nil {
 			return err
 		}
 
-		message := reflect.ValueOf(body["message"]).Float()
-		seen := false
+		var unacked []job
+		for _, j := range order {
+			if !pq.isAcked(j) {
+				unacked = append(unacked, j)
+			}
+		}
+
 		mu.Lock()
-		_, ok := values[message]
-		if ok {
-			seen = true
-		} else {
-			values[message] = true
+		all := make([]int, 0, len(values))
+		for v := range values {
+			all = append(all, v)
 		}
 		mu.Unlock()
+		sort.Ints(all)
 
-		if !seen {
-			for _, neighbor := range topology {
-				if neighbor != msg.Src {
-					fmt.Fprintf(os.Stderr, "Adding job, src - %v, dst - %v, message - %v\n", n.ID(), neighbor, message)
-					jobChan <- job{
-						Src:   n.ID(),
-						Dest:  neighbor,
-						Value: message,
-					}
-				}
+		var recs []any
+		if neighbors := peers.Neighbors(); len(neighbors) > 0 {
+			recs = append(recs, walRecord{Op: opTopology, Dests: neighbors})
+		}
+		if len(all) > 0 {
+			recs = append(recs, walRecord{Op: opValue, Values: all})
+		}
+		byDest := make(map[string][]int)
+		for _, j := range unacked {
+			byDest[j.Dest] = append(byDest[j.Dest], j.Value)
+		}
+		for _, dest := range sortedKeys(byDest) {
+			recs = append(recs, walRecord{Op: opEnqueue, Values: byDest[dest], Dests: []string{dest}})
+		}
+		if err := wal.Rewrite(path, recs); err != nil {
+			return err
+		}
+
+		l, err := wal.Open(path, env.Clock, logger)
+		if err != nil {
+			return err
+		}
+		pq.setLog(l)
+
+		mu.Lock()
+		ready = true
+		mu.Unlock()
+
+		logger.Info("Replayed the log", "path", path, "values", len(all), "unacked", len(unacked))
+		for _, j := range unacked {
+			queue.add(j)
+		}
+		return nil
+	}
+
+	n.Handle("init", func(msg maelstrom.Message) error {
+		if dataDir != "" {
+			if err := restore(); err != nil {
+				return err
 			}
 		}
 
-		return n.Reply(msg, map[string]any{"type": "broadcast_ok"})
+		ae.Start(antiEntropyInterval)
+		env.Clock.Go(queue.logStats)
+		return nil
 	})
 
-	n.Handle("read", func(msg maelstrom.Message) error {
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
+	// Replies wait until the values are durable, so an acknowledged value survives a restart
+	logging.Handle(n, logger, "broadcast", func(msg maelstrom.Message, logger *slog.Logger) error {
+		body, err := schema.Decode[schema.Broadcast](msg)
+		if err != nil {
 			return err
 		}
 
-		body = map[string]any{}
-		body["type"] = "read_ok"
+		if !learn([]int{body.Message}, msg.Src, func(err error) { reply(n, logger, msg, schema.NewBroadcastOK(), err) }) {
+			return errReplaying
+		}
+		return nil
+	})
+
+	logging.Handle(n, logger, "broadcast_batch", func(msg maelstrom.Message, logger *slog.Logger) error {
+		body, err := schema.Decode[schema.BroadcastBatch](msg)
+		if err != nil {
+			return err
+		}
 
-		var keys []any
+		if !learn(body.Messages, msg.Src, func(err error) { reply(n, logger, msg, schema.NewBroadcastBatchOK(), err) }) {
+			return errReplaying
+		}
+		return nil
+	})
+
+	n.Handle("read", func(msg maelstrom.Message) error {
+		var keys []int
 		mu.Lock()
 		for k := range values {
 			keys = append(keys, k)
 		}
 		mu.Unlock()
 
-		body["messages"] = keys
-
-		return n.Reply(msg, body)
+		return n.Reply(msg, schema.NewReadOK(keys))
 	})
 
-	n.Handle("topology", func(msg maelstrom.Message) error {
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
+	logging.Handle(n, logger, "topology", func(msg maelstrom.Message, logger *slog.Logger) error {
+		body, err := schema.Decode[schema.Topology](msg)
+		if err != nil {
 			return err
 		}
 
-		topologyFromClient := body["topology"].(map[string]interface{})
-		for _, neighbor := range topologyFromClient[n.ID()].([]interface{}) {
-			topology = append(topology, neighbor.(string))
-		}
+		peers.Add(body.Topology[n.ID()]...)
+		logger.Info("Topology received", "neighbors", peers.Neighbors())
 
-		body = map[string]any{}
-		body["type"] = "topology_ok"
+		// maelstrom only sends the topology once, a restarted node reads it from the log
+		pq.record(walRecord{Op: opTopology, Dests: body.Topology[n.ID()]}, func(err error) {
+			reply(n, logger, msg, schema.NewTopologyOK(), err)
+		})
+		return nil
+	})
+}
 
-		fmt.Fprintf(os.Stderr, "topo- %v", topology, "\n")
+func sortedKeys(m map[string][]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
 
-		return n.Reply(msg, body)
-	})
+var errReplaying = maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, "replaying the log")
 
-	if err := n.Run(); err != nil {
-		log.Fatal(err)
+// reply sends body from outside the handler, e.g. once a value is durable, or a crash error if err is set.
+// The value is known in memory by then and may still spread, so the client cannot be told it failed. logger
+// is the one the handler of msg was given.
+func reply(n *maelstrom.Node, logger *slog.Logger, msg maelstrom.Message, body any, err error) {
+	if err != nil {
+		body = maelstrom.NewRPCError(maelstrom.Crash, "failed to log: "+err.Error())
+	}
+	if err := n.Reply(msg, body); err != nil {
+		logger.Error("Failed to reply", "err", err)
 	}
 }
@@ -1,32 +1,48 @@
 package c3
 
 import (
-	"encoding/json"
 	"errors"
-	"fmt"
 	"log"
-	"os"
+	"log/slog"
 	"sync"
 
+	"github.com/HdkTvd/advent-of-distributed-systems/logging"
+	"github.com/HdkTvd/advent-of-distributed-systems/schema"
+	"github.com/HdkTvd/advent-of-distributed-systems/workload"
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
 )
 
+func init() {
+	workload.Register("broadcast-multi", SetupMultiNodeBroadcast)
+}
+
 func Multi_node_broadcast() {
+	maelstromNode := maelstrom.NewNode()
+	SetupMultiNodeBroadcast(maelstromNode)
+
+	if err := maelstromNode.Run(); err != nil {
+		log.Fatal("Error running maelstrom node - ", err)
+		return
+	}
+}
+
+// SetupMultiNodeBroadcast registers the multi node broadcast handlers on maelstromNode.
+func SetupMultiNodeBroadcast(maelstromNode *maelstrom.Node) {
+	logger := logging.For(maelstromNode)
+
 	var mu sync.Mutex
 	// TODO: node Id to messages link required? Doesn't nodes have it's own working memory?
 	messages := make(map[string][]int, 0)
-	topology := make(map[string]interface{}, 0)
+	topology := make(map[string][]string, 0)
 
-	maelstromNode := maelstrom.NewNode()
-
-	maelstromNode.Handle("broadcast", func(msg maelstrom.Message) error {
-		reqBody := make(map[string]interface{})
-		if err := json.Unmarshal(msg.Body, &reqBody); err != nil {
-			log.Printf("Error unmarshalling message [%v]\n", err)
+	logging.Handle(maelstromNode, logger, "broadcast", func(msg maelstrom.Message, logger *slog.Logger) error {
+		reqBody, err := schema.Decode[schema.Broadcast](msg)
+		if err != nil {
+			logger.Error("Error unmarshalling message", "err", err)
 			return err
 		}
 
-		message := int(reqBody["message"].(float64))
+		message := reqBody.Message
 		nodeId := maelstromNode.ID()
 
 		if messageList, nodeExists := messages[nodeId]; !nodeExists {
@@ -39,86 +55,66 @@ func Multi_node_broadcast() {
 			messages[nodeId] = messageList
 		}
 
-		if err := maelstromNode.Reply(msg, map[string]interface{}{
-			"type": "broadcast_ok",
-		}); err != nil {
-			log.Printf("Error sending message [%v]\n", err)
+		if err := maelstromNode.Reply(msg, schema.NewBroadcastOK()); err != nil {
+			logger.Error("Error sending message", "err", err)
 			return err
 		}
 
-		receivers := make(map[string]interface{}, 0)
-		if reqBody["receivers"] != nil {
-			receivers = reqBody["receivers"].(map[string]interface{})
+		receivers := reqBody.Receivers
+		if receivers == nil {
+			receivers = make(map[string]bool, 0)
 		}
 		receivers[msg.Dest] = true
 
-		fmt.Fprintf(os.Stderr, "Debug: Previous receivers of this message -> %v | %v", message, receivers, "\n")
+		logger.Debug("Previous receivers of this message", "message", message, "receivers", receivers)
 
-		payload := map[string]interface{}{
-			"type":      "broadcast",
-			"message":   message,
-			"receivers": receivers,
-		}
+		payload := schema.NewBroadcast(message)
+		payload.Receivers = receivers
 
 		// Nodes can communicate bidirectionally, this incurs repetition in messages
 		// check prev receivers, do not send if present in the list
-		for _, adjacentNode := range topology[nodeId].([]interface{}) {
-			if _, ok := receivers[adjacentNode.(string)]; ok {
+		for _, adjacentNode := range topology[nodeId] {
+			if _, ok := receivers[adjacentNode]; ok {
 				continue
 			}
-			if err := maelstromNode.RPC(adjacentNode.(string), payload, func(msg maelstrom.Message) error {
-				reqBody := make(map[string]interface{})
-				if err := json.Unmarshal(msg.Body, &reqBody); err != nil {
-					log.Printf("Error unmarshalling message [%v]\n", err)
-					return err
-				}
-
-				if reqBody["type"].(string) != "broadcast_ok" {
+			if err := maelstromNode.RPC(adjacentNode, payload, func(msg maelstrom.Message) error {
+				if _, err := schema.DecodeReply[maelstrom.MessageBody](msg, "broadcast_ok"); err != nil {
+					logger.Warn("Error in broadcast response", "dest", adjacentNode, "err", err)
 					return errors.New("broadcast response failure")
 				}
 
 				return nil
 			}); err != nil {
-				log.Printf("Error broadcasting message to node [%v] - [%v]\n", adjacentNode, err)
+				logger.Error("Error broadcasting message to node", "dest", adjacentNode, "err", err)
 			}
 		}
 
 		return nil
 	})
 
-	maelstromNode.Handle("read", func(msg maelstrom.Message) error {
-		if err := maelstromNode.Reply(msg, map[string]interface{}{
-			"type":     "read_ok",
-			"messages": messages[maelstromNode.ID()],
-		}); err != nil {
-			log.Printf("Error sending message [%v]\n", err)
+	logging.Handle(maelstromNode, logger, "read", func(msg maelstrom.Message, logger *slog.Logger) error {
+		if err := maelstromNode.Reply(msg, schema.NewReadOK(messages[maelstromNode.ID()])); err != nil {
+			logger.Error("Error sending message", "err", err)
 			return err
 		}
 
 		return nil
 	})
 
-	maelstromNode.Handle("topology", func(msg maelstrom.Message) error {
-		reqBody := make(map[string]interface{})
-		if err := json.Unmarshal(msg.Body, &reqBody); err != nil {
-			log.Printf("Error unmarshalling message [%v]\n", err)
+	logging.Handle(maelstromNode, logger, "topology", func(msg maelstrom.Message, logger *slog.Logger) error {
+		reqBody, err := schema.Decode[schema.Topology](msg)
+		if err != nil {
+			logger.Error("Error unmarshalling message", "err", err)
 			return err
 		}
 
-		topology = reqBody["topology"].(map[string]interface{})
+		topology = reqBody.Topology
 
-		if err := maelstromNode.Reply(msg, map[string]interface{}{
-			"type": "topology_ok",
-		}); err != nil {
-			log.Printf("Error sending message [%v]\n", err)
+		if err := maelstromNode.Reply(msg, schema.NewTopologyOK()); err != nil {
+			logger.Error("Error sending message", "err", err)
 			return err
 		}
 
 		return nil
 	})
-
-	if err := maelstromNode.Run(); err != nil {
-		log.Fatal("Error running maelstrom node - ", err)
-		return
-	}
 }
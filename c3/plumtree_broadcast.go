@@ -0,0 +1,471 @@
+package c3
+
+import (
+	"log"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/HdkTvd/advent-of-distributed-systems/antientropy"
+	"github.com/HdkTvd/advent-of-distributed-systems/logging"
+	"github.com/HdkTvd/advent-of-distributed-systems/membership"
+	"github.com/HdkTvd/advent-of-distributed-systems/schema"
+	"github.com/HdkTvd/advent-of-distributed-systems/sim"
+	"github.com/HdkTvd/advent-of-distributed-systems/workload"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Plumtree (Leitao et al., Epidemic Broadcast Trees), with one tree per origin node
+// 1. Values are pushed eagerly to the eager peers, which start as every neighbour in the topology.
+// 2. A duplicate gossip prunes the link it came over into a lazy peer, so the eager links settle into a spanning tree.
+// 3. Lazy peers only get IHAVE announcements, batched every lazyInterval.
+// 4. A value that was announced but not gossiped in time is grafted from the announcer, which repairs the tree
+// around slow or partitioned links.
+// 5. Gossip and announcements are sent once, a node that was partitioned while a value spread never hears of
+// it, so anti-entropy with the neighbours repairs what the trees missed.
+// Nodes with several clients would prune each other's trees if they shared one, so every origin gets its own views.
+
+const (
+	// graftTimeout is how long a node waits for the gossip of an announced value before grafting it
+	graftTimeout = 300 * time.Millisecond
+	// regraftTimeout is how long it waits before grafting from the next announcer
+	regraftTimeout = 150 * time.Millisecond
+	lazyInterval   = 50 * time.Millisecond
+	// optimizationThreshold is how many rounds shorter an announced path must be to replace the tree link
+	optimizationThreshold = 3
+)
+
+func init() {
+	workload.Register("broadcast-plumtree", SetupPlumtreeBroadcast)
+	workload.Register("broadcast-plumtree-hyparview", SetupPlumtreeBroadcastHyParView)
+}
+
+func Plumtree_broadcast() {
+	n := maelstrom.NewNode()
+	SetupPlumtreeBroadcast(n)
+
+	if err := n.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// SetupPlumtreeBroadcast registers the broadcast, read and topology handlers on n, backed by a plumtree.
+func SetupPlumtreeBroadcast(n *maelstrom.Node) {
+	setupPlumtreeBroadcast(n, nil)
+}
+
+// SetupPlumtreeBroadcastHyParView is SetupPlumtreeBroadcast over a HyParView overlay instead of the topology.
+func SetupPlumtreeBroadcastHyParView(n *maelstrom.Node) {
+	setupPlumtreeBroadcast(n, membership.NewHyParView(n, membership.DefaultConfig()))
+}
+
+func setupPlumtreeBroadcast(n *maelstrom.Node, hv *membership.HyParView) {
+	env := sim.For(n)
+	pt := newPlumtree(n, env.Clock, logging.For(n))
+	pt.ae = antientropy.New(n, pt, pt.repair)
+
+	if hv != nil {
+		hv.OnChange(pt.setNeighbors)
+	}
+
+	n.Handle("init", func(msg maelstrom.Message) error {
+		pt.ae.Start(antiEntropyInterval)
+		if hv != nil {
+			hv.Start()
+		}
+		return nil
+	})
+
+	n.Handle("broadcast", func(msg maelstrom.Message) error {
+		body, err := schema.Decode[schema.Broadcast](msg)
+		if err != nil {
+			return err
+		}
+
+		pt.broadcast(body.Message)
+
+		return n.Reply(msg, schema.NewBroadcastOK())
+	})
+
+	n.Handle("read", func(msg maelstrom.Message) error {
+		return n.Reply(msg, schema.NewReadOK(pt.values()))
+	})
+
+	n.Handle("topology", func(msg maelstrom.Message) error {
+		body, err := schema.Decode[schema.Topology](msg)
+		if err != nil {
+			return err
+		}
+
+		// The overlay picks its own neighbours
+		if hv == nil {
+			pt.setNeighbors(body.Topology[n.ID()])
+		}
+
+		return n.Reply(msg, schema.NewTopologyOK())
+	})
+
+	// The tree messages are sent without msg_id and need no reply
+	logging.Handle(n, pt.logger, "gossip", func(msg maelstrom.Message, logger *slog.Logger) error {
+		body, err := schema.Decode[schema.Gossip](msg)
+		if err != nil {
+			logger.Warn("Dropping malformed gossip", "err", err)
+			return nil
+		}
+
+		pt.onGossip(msg.Src, body.Message, body.Origin, body.Round)
+		return nil
+	})
+
+	logging.Handle(n, pt.logger, "ihave", func(msg maelstrom.Message, logger *slog.Logger) error {
+		body, err := schema.Decode[schema.IHave](msg)
+		if err != nil {
+			logger.Warn("Dropping malformed ihave", "err", err)
+			return nil
+		}
+
+		pt.onIHave(msg.Src, body.Announcements)
+		return nil
+	})
+
+	logging.Handle(n, pt.logger, "graft", func(msg maelstrom.Message, logger *slog.Logger) error {
+		body, err := schema.Decode[schema.Graft](msg)
+		if err != nil {
+			logger.Warn("Dropping malformed graft", "err", err)
+			return nil
+		}
+
+		pt.onGraft(msg.Src, body.Origin, body.Messages)
+		return nil
+	})
+
+	logging.Handle(n, pt.logger, "prune", func(msg maelstrom.Message, logger *slog.Logger) error {
+		body, err := schema.Decode[schema.Prune](msg)
+		if err != nil {
+			logger.Warn("Dropping malformed prune", "err", err)
+			return nil
+		}
+
+		pt.onPrune(msg.Src, body.Origin)
+		return nil
+	})
+}
+
+type plumtree struct {
+	mu     sync.Mutex
+	n      *maelstrom.Node
+	clock  sim.Clock
+	logger *slog.Logger
+
+	neighbors []string
+	// views holds the eager and lazy peers of every origin's tree
+	views map[string]*plumtreeView
+
+	// log holds the values in the order they arrived, seen how each of them arrived
+	log  []int
+	seen map[int]delivery
+
+	// missing lists the announcers of values that were announced but not received yet
+	missing map[int][]announcer
+	timers  map[int]func() bool
+
+	lazyQueue map[string][]schema.Announcement
+	lazyArmed bool
+
+	ae *antientropy.AntiEntropy
+}
+
+type plumtreeView struct {
+	eager map[string]bool
+	lazy  map[string]bool
+}
+
+// delivery is how a value first reached the node, parent is empty for client broadcasts.
+type delivery struct {
+	origin string
+	round  int
+	parent string
+}
+
+type announcer struct {
+	peer   string
+	origin string
+}
+
+func newPlumtree(n *maelstrom.Node, clock sim.Clock, logger *slog.Logger) *plumtree {
+	return &plumtree{
+		n:         n,
+		clock:     clock,
+		logger:    logger,
+		views:     make(map[string]*plumtreeView),
+		seen:      make(map[int]delivery),
+		missing:   make(map[int][]announcer),
+		timers:    make(map[int]func() bool),
+		lazyQueue: make(map[string][]schema.Announcement),
+	}
+}
+
+// setNeighbors replaces the neighbours. New ones join every tree as eager peers and the ones that left are
+// removed from every tree.
+func (pt *plumtree) setNeighbors(neighbors []string) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	current := make(map[string]bool)
+	for _, peer := range neighbors {
+		if peer != pt.n.ID() {
+			current[peer] = true
+		}
+	}
+
+	for _, peer := range pt.neighbors {
+		if !current[peer] {
+			for _, v := range pt.views {
+				delete(v.eager, peer)
+				delete(v.lazy, peer)
+			}
+		}
+	}
+	for peer := range current {
+		for _, v := range pt.views {
+			if !v.eager[peer] && !v.lazy[peer] {
+				v.eager[peer] = true
+			}
+		}
+	}
+
+	pt.neighbors = sortedPeers(current)
+}
+
+// Neighbors returns the current neighbours, anti-entropy picks its peers from them.
+func (pt *plumtree) Neighbors() []string {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	return append([]string(nil), pt.neighbors...)
+}
+
+// view returns the peers of origin's tree, where every neighbour starts eager. Callers hold mu.
+func (pt *plumtree) view(origin string) *plumtreeView {
+	v, ok := pt.views[origin]
+	if !ok {
+		v = &plumtreeView{eager: make(map[string]bool), lazy: make(map[string]bool)}
+		for _, peer := range pt.neighbors {
+			v.eager[peer] = true
+		}
+		pt.views[origin] = v
+	}
+	return v
+}
+
+func (v *plumtreeView) addEager(peer string) {
+	delete(v.lazy, peer)
+	v.eager[peer] = true
+}
+
+func (v *plumtreeView) addLazy(peer string) {
+	delete(v.eager, peer)
+	v.lazy[peer] = true
+}
+
+func (pt *plumtree) values() []int {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	return append([]int(nil), pt.log...)
+}
+
+// broadcast starts a value from a client on the node's own tree.
+func (pt *plumtree) broadcast(message int) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	if _, ok := pt.seen[message]; ok {
+		return
+	}
+	pt.deliver(message, delivery{origin: pt.n.ID()})
+}
+
+func (pt *plumtree) onGossip(src string, message int, origin string, round int) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	d, ok := pt.seen[message]
+	if !ok {
+		pt.view(origin).addEager(src)
+		pt.deliver(message, delivery{origin: origin, round: round, parent: src})
+		return
+	}
+
+	// A duplicate from the parent, e.g. a grafted retransmission, says nothing about the tree
+	if d.parent == src {
+		return
+	}
+
+	pt.logger.Debug("Pruning duplicate gossip link", "dest", src, "origin", origin, "message", message)
+	pt.view(origin).addLazy(src)
+	pt.send(src, schema.NewPrune(origin))
+}
+
+// repair records the values anti-entropy found missing. Their origin and round are unknown, so they are not
+// passed on along a tree, the neighbours repair them the same way.
+func (pt *plumtree) repair(values []int, src string) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	for _, message := range values {
+		if _, ok := pt.seen[message]; !ok {
+			pt.record(message, delivery{parent: src})
+		}
+	}
+}
+
+// deliver records a new value and passes it on along its origin's tree. Callers hold mu.
+func (pt *plumtree) deliver(message int, d delivery) {
+	pt.record(message, d)
+
+	v := pt.view(d.origin)
+	for _, peer := range sortedPeers(v.eager) {
+		if peer != d.parent {
+			pt.send(peer, schema.NewGossip(message, d.origin, d.round+1))
+		}
+	}
+	for _, peer := range sortedPeers(v.lazy) {
+		if peer != d.parent {
+			pt.announce(peer, schema.Announcement{Message: message, Origin: d.origin, Round: d.round + 1})
+		}
+	}
+}
+
+// record adds a value to the log and stops waiting for it. Callers hold mu.
+func (pt *plumtree) record(message int, d delivery) {
+	pt.log = append(pt.log, message)
+	pt.seen[message] = d
+	pt.ae.Add(message)
+
+	if stop, ok := pt.timers[message]; ok {
+		stop()
+		delete(pt.timers, message)
+	}
+	delete(pt.missing, message)
+}
+
+func (pt *plumtree) onIHave(src string, announcements []schema.Announcement) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	optimized := make(map[string]bool)
+	for _, a := range announcements {
+		d, ok := pt.seen[a.Message]
+		if !ok {
+			pt.missing[a.Message] = append(pt.missing[a.Message], announcer{peer: src, origin: a.Origin})
+			if _, armed := pt.timers[a.Message]; !armed {
+				pt.armGraft(a.Message, graftTimeout)
+			}
+			continue
+		}
+
+		// The announcer is much closer to the origin than the path the value
+		// came over, so it replaces the parent in the tree
+		if optimized[a.Origin] || d.parent == "" || d.parent == src || d.round-a.Round < optimizationThreshold {
+			continue
+		}
+		pt.logger.Debug("Replacing tree link with a shorter path", "dest", src, "origin", a.Origin, "parent", d.parent)
+		v := pt.view(a.Origin)
+		v.addEager(src)
+		pt.send(src, schema.NewGraft(a.Origin, nil))
+		v.addLazy(d.parent)
+		pt.send(d.parent, schema.NewPrune(a.Origin))
+		optimized[a.Origin] = true
+	}
+}
+
+// armGraft grafts message once timeout passes without its gossip. Callers hold mu.
+func (pt *plumtree) armGraft(message int, timeout time.Duration) {
+	pt.timers[message] = pt.clock.AfterFunc(timeout, func() { pt.graftMissing(message) })
+}
+
+// graftMissing grafts message from its first announcer and rotates the announcers, so the next
+// attempt asks another peer if this one does not answer.
+func (pt *plumtree) graftMissing(message int) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	delete(pt.timers, message)
+	announcers := pt.missing[message]
+	if _, ok := pt.seen[message]; ok || len(announcers) == 0 {
+		return
+	}
+
+	a := announcers[0]
+	pt.missing[message] = append(announcers[1:], a)
+
+	pt.logger.Debug("Grafting missing message", "dest", a.peer, "origin", a.origin, "message", message)
+	pt.view(a.origin).addEager(a.peer)
+	pt.send(a.peer, schema.NewGraft(a.origin, []int{message}))
+	pt.armGraft(message, regraftTimeout)
+}
+
+func (pt *plumtree) onGraft(src, origin string, messages []int) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	pt.view(origin).addEager(src)
+	for _, message := range messages {
+		d, ok := pt.seen[message]
+		if !ok {
+			continue
+		}
+		pt.send(src, schema.NewGossip(message, d.origin, d.round+1))
+	}
+}
+
+func (pt *plumtree) onPrune(src, origin string) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	pt.view(origin).addLazy(src)
+}
+
+// announce queues an IHAVE for peer, the queue is flushed every lazyInterval. Callers hold mu.
+func (pt *plumtree) announce(peer string, a schema.Announcement) {
+	pt.lazyQueue[peer] = append(pt.lazyQueue[peer], a)
+	if !pt.lazyArmed {
+		pt.lazyArmed = true
+		pt.clock.AfterFunc(lazyInterval, pt.flushLazy)
+	}
+}
+
+func (pt *plumtree) flushLazy() {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	pt.lazyArmed = false
+	queue := pt.lazyQueue
+	pt.lazyQueue = make(map[string][]schema.Announcement)
+
+	peers := make([]string, 0, len(queue))
+	for peer := range queue {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+
+	for _, peer := range peers {
+		pt.send(peer, schema.NewIHave(queue[peer]))
+	}
+}
+
+func (pt *plumtree) send(dest string, body any) {
+	if err := pt.n.Send(dest, body); err != nil {
+		pt.logger.Error("Failed to send", "dest", dest, "err", err)
+	}
+}
+
+// sortedPeers returns the peers in a fixed order, so a seeded simulation sends in the same order every run.
+func sortedPeers(peers map[string]bool) []string {
+	sorted := make([]string, 0, len(peers))
+	for peer := range peers {
+		sorted = append(sorted, peer)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
@@ -1,72 +1,76 @@
 package c3
 
 import (
-	"encoding/json"
 	"log"
+	"log/slog"
 	"sync"
 
+	"github.com/HdkTvd/advent-of-distributed-systems/logging"
+	"github.com/HdkTvd/advent-of-distributed-systems/schema"
+	"github.com/HdkTvd/advent-of-distributed-systems/workload"
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
 )
 
+func init() {
+	workload.Register("broadcast-single", SetupSingleNodeBroadcast)
+}
+
 func Single_node_broadcast() {
+	maelstromNode := maelstrom.NewNode()
+	SetupSingleNodeBroadcast(maelstromNode)
+
+	if err := maelstromNode.Run(); err != nil {
+		log.Fatal("Error running maelstrom node - ", err)
+		return
+	}
+}
+
+// SetupSingleNodeBroadcast registers the single node broadcast handlers on maelstromNode.
+func SetupSingleNodeBroadcast(maelstromNode *maelstrom.Node) {
+	logger := logging.For(maelstromNode)
+
 	var mu sync.Mutex
 	messages := make([]int, 0)
 
-	maelstromNode := maelstrom.NewNode()
-	maelstromNode.Handle("broadcast", func(msg maelstrom.Message) error {
-		reqBody := make(map[string]interface{})
-		if err := json.Unmarshal(msg.Body, &reqBody); err != nil {
-			log.Printf("Error unmarshalling message [%v]\n", err)
+	logging.Handle(maelstromNode, logger, "broadcast", func(msg maelstrom.Message, logger *slog.Logger) error {
+		reqBody, err := schema.Decode[schema.Broadcast](msg)
+		if err != nil {
+			logger.Error("Error unmarshalling message", "err", err)
 			return err
 		}
 
-		message := int(reqBody["message"].(float64))
-
 		mu.Lock()
-		messages = append(messages, message)
+		messages = append(messages, reqBody.Message)
 		mu.Unlock()
 
-		if err := maelstromNode.Reply(msg, map[string]interface{}{
-			"type": "broadcast_ok",
-		}); err != nil {
-			log.Printf("Error sending message [%v]\n", err)
+		if err := maelstromNode.Reply(msg, schema.NewBroadcastOK()); err != nil {
+			logger.Error("Error sending message", "err", err)
 			return err
 		}
 
 		return nil
 	})
 
-	maelstromNode.Handle("read", func(msg maelstrom.Message) error {
-		if err := maelstromNode.Reply(msg, map[string]interface{}{
-			"type":     "read_ok",
-			"messages": messages,
-		}); err != nil {
-			log.Printf("Error sending message [%v]\n", err)
+	logging.Handle(maelstromNode, logger, "read", func(msg maelstrom.Message, logger *slog.Logger) error {
+		if err := maelstromNode.Reply(msg, schema.NewReadOK(messages)); err != nil {
+			logger.Error("Error sending message", "err", err)
 			return err
 		}
 
 		return nil
 	})
 
-	maelstromNode.Handle("topology", func(msg maelstrom.Message) error {
-		reqBody := make(map[string]interface{})
-		if err := json.Unmarshal(msg.Body, &reqBody); err != nil {
-			log.Printf("Error unmarshalling message [%v]\n", err)
+	logging.Handle(maelstromNode, logger, "topology", func(msg maelstrom.Message, logger *slog.Logger) error {
+		if _, err := schema.Decode[schema.Topology](msg); err != nil {
+			logger.Error("Error unmarshalling message", "err", err)
 			return err
 		}
 
-		if err := maelstromNode.Reply(msg, map[string]interface{}{
-			"type": "topology_ok",
-		}); err != nil {
-			log.Printf("Error sending message [%v]\n", err)
+		if err := maelstromNode.Reply(msg, schema.NewTopologyOK()); err != nil {
+			logger.Error("Error sending message", "err", err)
 			return err
 		}
 
 		return nil
 	})
-
-	if err := maelstromNode.Run(); err != nil {
-		log.Fatal("Error running maelstrom node - ", err)
-		return
-	}
 }
@@ -0,0 +1,208 @@
+package c3
+
+import (
+	"log"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/HdkTvd/advent-of-distributed-systems/logging"
+	"github.com/HdkTvd/advent-of-distributed-systems/schema"
+	"github.com/HdkTvd/advent-of-distributed-systems/sim"
+	"github.com/HdkTvd/advent-of-distributed-systems/workload"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Total order broadcast with Lamport timestamps (Lamport, Time, Clocks, and the Ordering of Events)
+// 1. A node gives a client's value the next tick of its Lamport clock and appends it to its log of proposals.
+// 2. Every orderPullInterval each node pulls the new proposals of every other node, with that node's clock.
+// The log is pulled in order and the clock promises that later proposals get larger timestamps, so the reply
+// acknowledges every timestamp up to it.
+// 3. Proposals are delivered by timestamp, ties broken by origin, once every node acknowledged the timestamp,
+// so no proposal can show up that belongs before them. All nodes deliver in the same order.
+// 4. Delivery needs every node, a node that is partitioned or down stops it until it is back, but it never
+// lets two nodes deliver in different orders.
+
+const (
+	orderPullInterval = 100 * time.Millisecond
+	// maxProposals caps the proposals of one order_pull_ok, the rest follow in the next pulls
+	maxProposals = 1024
+)
+
+func init() {
+	workload.Register("broadcast-total-order", SetupTotalOrderBroadcast)
+}
+
+func Total_order_broadcast() {
+	n := maelstrom.NewNode()
+	SetupTotalOrderBroadcast(n)
+
+	if err := n.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+type totalOrder struct {
+	mu sync.Mutex
+	n  *maelstrom.Node
+
+	// lamport is the node's Lamport clock, proposals its own values in the order it made them
+	lamport   int
+	proposals []schema.Proposal
+	// next is the offset into each peer's proposals pulled so far, acked the largest clock it sent
+	next  map[string]int
+	acked map[string]int
+	// queues holds the proposals of every origin that are not delivered yet, in timestamp order
+	queues    map[string][]schema.Proposal
+	delivered []int
+
+	clock  sim.Clock
+	logger *slog.Logger
+}
+
+// SetupTotalOrderBroadcast registers the broadcast, read and topology handlers on n, delivering values in the
+// same order on every node.
+func SetupTotalOrderBroadcast(n *maelstrom.Node) {
+	env := sim.For(n)
+	to := &totalOrder{
+		n:      n,
+		next:   make(map[string]int),
+		acked:  make(map[string]int),
+		queues: make(map[string][]schema.Proposal),
+		clock:  env.Clock,
+		logger: logging.For(n),
+	}
+
+	n.Handle("init", func(msg maelstrom.Message) error {
+		env.Clock.Go(to.pullLoop)
+		return nil
+	})
+
+	n.Handle("broadcast", func(msg maelstrom.Message) error {
+		body, err := schema.Decode[schema.Broadcast](msg)
+		if err != nil {
+			return err
+		}
+
+		to.mu.Lock()
+		to.lamport++
+		p := schema.Proposal{Timestamp: to.lamport, Message: body.Message}
+		to.proposals = append(to.proposals, p)
+		to.queues[n.ID()] = append(to.queues[n.ID()], p)
+		to.deliver()
+		to.mu.Unlock()
+
+		return n.Reply(msg, schema.NewBroadcastOK())
+	})
+
+	n.Handle("read", func(msg maelstrom.Message) error {
+		to.mu.Lock()
+		values := append([]int(nil), to.delivered...)
+		to.mu.Unlock()
+
+		return n.Reply(msg, schema.NewOrderedReadOK(values))
+	})
+
+	// Every node pulls from every other node, the topology is not needed
+	n.Handle("topology", func(msg maelstrom.Message) error {
+		return n.Reply(msg, schema.NewTopologyOK())
+	})
+
+	n.Handle("order_pull", func(msg maelstrom.Message) error {
+		body, err := schema.Decode[schema.OrderPull](msg)
+		if err != nil {
+			return err
+		}
+
+		to.mu.Lock()
+		after := min(body.After, len(to.proposals))
+		next := min(after+maxProposals, len(to.proposals))
+		proposals := append([]schema.Proposal(nil), to.proposals[after:next]...)
+		// Proposals left for the next pull have larger timestamps than the last one sent
+		clock := to.lamport
+		if next < len(to.proposals) {
+			clock = proposals[len(proposals)-1].Timestamp
+		}
+		to.mu.Unlock()
+
+		return n.Reply(msg, schema.NewOrderPullOK(proposals, next, clock))
+	})
+}
+
+func (to *totalOrder) pullLoop() {
+	for {
+		to.clock.Sleep(orderPullInterval)
+
+		for _, peer := range to.n.NodeIDs() {
+			if peer == to.n.ID() {
+				continue
+			}
+
+			to.mu.Lock()
+			after := to.next[peer]
+			to.mu.Unlock()
+
+			peer := peer
+			if err := to.n.RPC(peer, schema.NewOrderPull(after), func(msg maelstrom.Message) error {
+				body, err := schema.DecodeReply[schema.OrderPullOK](msg, "order_pull_ok")
+				if err != nil {
+					return err
+				}
+
+				to.mu.Lock()
+				defer to.mu.Unlock()
+
+				// A late reply to an older pull would add its proposals twice
+				if to.next[peer] != after {
+					return nil
+				}
+				to.next[peer] = body.Next
+				to.queues[peer] = append(to.queues[peer], body.Proposals...)
+				to.acked[peer] = max(to.acked[peer], body.Clock)
+				to.lamport = max(to.lamport, body.Clock)
+				to.deliver()
+				return nil
+			}); err != nil {
+				to.logger.Error("Failed to send", "dest", peer, "err", err)
+			}
+		}
+	}
+}
+
+// deliver delivers the queued proposals every node acknowledged, smallest timestamp and origin first. Callers
+// hold mu.
+func (to *totalOrder) deliver() {
+	origins := make([]string, 0, len(to.queues))
+	for origin := range to.queues {
+		origins = append(origins, origin)
+	}
+	sort.Strings(origins)
+
+	for {
+		first := ""
+		for _, origin := range origins {
+			q := to.queues[origin]
+			if len(q) > 0 && (first == "" || q[0].Timestamp < to.queues[first][0].Timestamp) {
+				first = origin
+			}
+		}
+		if first == "" || !to.stable(to.queues[first][0].Timestamp) {
+			return
+		}
+
+		to.delivered = append(to.delivered, to.queues[first][0].Message)
+		to.queues[first] = to.queues[first][1:]
+	}
+}
+
+// stable reports whether every node acknowledged ts, so no proposal with a smaller timestamp can still
+// appear. Callers hold mu.
+func (to *totalOrder) stable(ts int) bool {
+	for _, peer := range to.n.NodeIDs() {
+		if peer != to.n.ID() && to.acked[peer] < ts {
+			return false
+		}
+	}
+	return true
+}
@@ -2,88 +2,97 @@ package c4
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
+	"errors"
 	"log"
-	"os"
-	"strings"
+	"log/slog"
+	"time"
 
+	"github.com/HdkTvd/advent-of-distributed-systems/kv"
+	"github.com/HdkTvd/advent-of-distributed-systems/logging"
+	"github.com/HdkTvd/advent-of-distributed-systems/schema"
+	"github.com/HdkTvd/advent-of-distributed-systems/workload"
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
 )
 
+// addTimeout bounds the retries of an add, below the time maelstrom clients
+// wait for a reply.
+const addTimeout = 3 * time.Second
+
+func init() {
+	workload.Register("g-counter", SetupGrowOnlyCounter)
+}
+
 func GrowOnlyCoounter() {
+	n := maelstrom.NewNode()
+	SetupGrowOnlyCounter(n)
+
+	if err := n.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// SetupGrowOnlyCounter registers the grow-only counter handlers on n.
+func SetupGrowOnlyCounter(n *maelstrom.Node) {
 	// topology := make(map[string]interface{}, 0)
 
-	n := maelstrom.NewNode()
-	skv := maelstrom.NewSeqKV(n)
+	// Adds only conflict with each other, so they retry until the request is
+	// given up on rather than after a fixed number of attempts
+	skv := kv.NewSeqKV(n).WithRetry(kv.RetryPolicy{
+		BaseDelay:      kv.DefaultRetryPolicy.BaseDelay,
+		MaxDelay:       kv.DefaultRetryPolicy.MaxDelay,
+		AttemptTimeout: kv.DefaultRetryPolicy.AttemptTimeout,
+	})
+	logger := logging.For(n)
 
 	key := "counter"
 
-	n.Handle("read", func(msg maelstrom.Message) error {
+	logging.Handle(n, logger, "read", func(msg maelstrom.Message, logger *slog.Logger) error {
 		ctx := context.Background()
 
-		val, err := skv.ReadInt(ctx, key)
+		val, err := kv.Read[int](ctx, skv, key)
 		// It's important to ignore this error as it may occure initially
-		if err != nil && !strings.Contains(err.Error(), maelstrom.ErrorCodeText(maelstrom.KeyDoesNotExist)) {
-			fmt.Fprintf(os.Stderr, "Error in sequential counter read - %q\n", err.Error())
+		if err != nil && !errors.Is(err, kv.ErrNotFound) {
+			logger.Error("Error in sequential counter read", "err", err)
 			return err
 		}
 
-		return n.Reply(msg, map[string]any{
-			"type":  "read_ok",
-			"value": val,
-		})
+		return n.Reply(msg, schema.NewCounterReadOK(val))
 	})
 
-	n.Handle("add", func(msg maelstrom.Message) error {
-		ctx := context.Background()
+	logging.Handle(n, logger, "add", func(msg maelstrom.Message, logger *slog.Logger) error {
+		ctx, cancel := context.WithTimeout(context.Background(), addTimeout)
+		defer cancel()
 
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
+		body, err := schema.Decode[schema.Add](msg)
+		if err != nil {
 			return err
 		}
 
-		delta := int(body["delta"].(float64))
+		delta := body.Delta
 
 		// CAS ensures that the new value will only be updated if the old value also matches
 		// this helps avoid race conditions in concurrent enviroments.
-		// This loop guarantees the continuous retries even after the "key does not exist" error OR
-		// the read value does not match the value while udpating with error "error current value a is not x"
-		for {
-			currentVal, err := skv.ReadInt(ctx, key)
-			if err != nil && !strings.Contains(err.Error(), maelstrom.ErrorCodeText(maelstrom.KeyDoesNotExist)) {
-				fmt.Fprintf(os.Stderr, "Error in sequential counter read for add - %q\n", err.Error())
-				return err
-			}
-
-			newVal := currentVal + delta
-			if err := skv.CompareAndSwap(ctx, key, currentVal, newVal, true); err == nil {
-				break
+		// Update retries the read and CAS after the "key does not exist" error OR when
+		// the read value does not match the value while udpating, until addTimeout passes
+		if _, err := kv.Update(ctx, skv, key, 0, func(currentVal int) (int, error) {
+			return currentVal + delta, nil
+		}); err != nil {
+			logger.Error("Error in sequential counter add", "delta", delta, "err", err)
+			// Unless a CAS timed out the delta was never stored, and the client may safely retry
+			if !errors.Is(err, kv.ErrIndeterminate) {
+				return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, "counter add gave up: "+err.Error())
 			}
+			return err
 		}
 
-		return n.Reply(msg, map[string]any{"type": "add_ok"})
+		return n.Reply(msg, schema.NewAddOK())
 	})
 
 	n.Handle("topology", func(msg maelstrom.Message) error {
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
+		if _, err := schema.Decode[schema.Topology](msg); err != nil {
 			return err
 		}
 
-		replyBody := map[string]any{}
-		replyBody["type"] = "topology_ok"
-
-		if source, _ := body["source"].(string); source != "nodeServer" {
-			return n.Reply(msg, replyBody)
-		}
-
-		_ = body["topology"].(map[string]interface{})
-
-		return n.Reply(msg, replyBody)
+		return n.Reply(msg, schema.NewTopologyOK())
 	})
-
-	if err := n.Run(); err != nil {
-		log.Fatal(err)
-	}
 }
@@ -2,29 +2,50 @@ package c5
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
+	"errors"
 	"log"
-	"os"
-	"strings"
+	"log/slog"
+	"sort"
 	"sync"
 
+	"github.com/HdkTvd/advent-of-distributed-systems/kv"
+	"github.com/HdkTvd/advent-of-distributed-systems/logging"
+	"github.com/HdkTvd/advent-of-distributed-systems/schema"
+	"github.com/HdkTvd/advent-of-distributed-systems/workload"
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
 )
 
 // By default, node ID "n0" will be the leader, for simplicity
 
+func init() {
+	workload.Register("kafka-multi", SetupKafkaStyleLogMultiNode)
+}
+
 func KafkaStyleLogMultiNode() {
 	n := maelstrom.NewNode()
-	seqKV := maelstrom.NewSeqKV(n)
-	linKV := maelstrom.NewLinKV(n)
+	SetupKafkaStyleLogMultiNode(n)
+
+	if err := n.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// SetupKafkaStyleLogMultiNode registers the multi node kafka log handlers on n.
+func SetupKafkaStyleLogMultiNode(n *maelstrom.Node) {
+	seqKV := kv.NewSeqKV(n)
+	linKV := kv.NewLinKV(n)
+	logger := logging.For(n)
 
 	Node := struct {
 		committedOffsets map[string]int
 		mu               sync.RWMutex
+		// appending holds a lock per key on the leader. Sends to a key take it from allocating the offset to
+		// appending the entry, so a later offset never shows up in the log before an earlier one
+		appending map[string]*sync.Mutex
 	}{
 		committedOffsets: make(map[string]int),
 		mu:               sync.RWMutex{},
+		appending:        make(map[string]*sync.Mutex),
 	}
 
 	// No leader election algorithm used for simplicity
@@ -32,64 +53,72 @@ func KafkaStyleLogMultiNode() {
 	leader := "n0"
 
 	// handlers
-	n.Handle("send", func(msg maelstrom.Message) error {
+	logging.Handle(n, logger, "send", func(msg maelstrom.Message, logger *slog.Logger) error {
 		ctx := context.Background()
 
-		body := make(map[string]interface{})
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			fmt.Fprintf(os.Stderr, "Error in Unmarshalling request - %q\n", err.Error())
+		body, err := schema.Decode[schema.Send](msg)
+		if err != nil {
+			logger.Error("Error in unmarshalling request", "err", err)
 			return err
 		}
 
-		key := body["key"].(string)
-		data := body["msg"].(float64)
+		key := body.Key
+		data := body.Msg
 
 		var newOffset int
 
 		// current node is the leader
 		if n.ID() == leader {
-			if err := updateOffsetAndLog(ctx, key, int(data), &newOffset, linKV, seqKV); err != nil {
-				fmt.Fprintf(os.Stderr, "Error in updating offset and log for key %q - %q\n", key, err.Error())
+			Node.mu.Lock()
+			keyMu, ok := Node.appending[key]
+			if !ok {
+				keyMu = &sync.Mutex{}
+				Node.appending[key] = keyMu
+			}
+			Node.mu.Unlock()
+
+			keyMu.Lock()
+			err := updateOffsetAndLog(ctx, key, data, &newOffset, linKV, seqKV, logger)
+			keyMu.Unlock()
+			if err != nil {
+				logger.Error("Error in updating offset and log", "key", key, "err", err)
 				return err
 			}
 
-			if err := n.Reply(msg, map[string]interface{}{
-				"type":   "send_ok",
-				"offset": newOffset,
-			}); err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to reply send_ok with new offset value - %q\n", err.Error())
+			if err := n.Reply(msg, schema.NewSendOK(newOffset)); err != nil {
+				logger.Error("Failed to reply send_ok", "offset", newOffset, "err", err)
 				return err
 			}
 		} else {
 			// Relay the message to the leader
 			replyMessage, err := n.SyncRPC(ctx, leader, msg.Body)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to replicate log to Leader %q with key %q - %q\n", leader, key, err.Error())
+				logger.Error("Failed to relay send to leader", "leader", leader, "key", key, "err", err)
 				return err
 			}
 
 			if err := n.Reply(msg, replyMessage.Body); err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to reply send_ok with new offset value - %q\n", err.Error())
+				logger.Error("Failed to reply send_ok", "err", err)
 				return err
 			}
 		}
 
-		fmt.Fprintf(os.Stderr, "send_ok | newOffset - %v | key - %v\n", newOffset, key)
+		logger.Debug("send_ok", "key", key, "offset", newOffset)
 		return nil
 	})
 
-	n.Handle("poll", func(msg maelstrom.Message) error {
+	logging.Handle(n, logger, "poll", func(msg maelstrom.Message, logger *slog.Logger) error {
 		ctx := context.Background()
 
-		body := make(map[string]interface{})
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			fmt.Fprintf(os.Stderr, "Error in Unmarshalling request - %q\n", err.Error())
+		body, err := schema.Decode[schema.Poll](msg)
+		if err != nil {
+			logger.Error("Error in unmarshalling request", "err", err)
 			return err
 		}
 
-		reqLogOffsets := body["offsets"].(map[string]interface{})
+		reqLogOffsets := body.Offsets
 
-		fmt.Fprintf(os.Stderr, "polled offsets - %v \n", reqLogOffsets)
+		logger.Debug("Polled offsets", "offsets", reqLogOffsets)
 
 		var response = make(map[string][][]int, 0)
 
@@ -97,109 +126,105 @@ func KafkaStyleLogMultiNode() {
 		defer Node.mu.RUnlock()
 
 		for key, offset := range reqLogOffsets {
-			startOffset := int(offset.(float64))
-
-			// fmt.Fprintf(os.Stderr, "key %v | logs - %v \n", key, Node.logs[key])
-			fmt.Fprintf(os.Stderr, "individual polled offsets - %v \n", offset)
+			startOffset := offset
 
-			var logs [][]int
-			if err := seqKV.ReadInto(ctx, key, &logs); err != nil && !strings.Contains(err.Error(), maelstrom.ErrorCodeText(maelstrom.KeyDoesNotExist)) {
-				fmt.Fprintf(os.Stderr, "Failed to read data from seqKV fro key %q. ERR - [%v]", key, err)
+			logs, err := kv.Read[[][]int](ctx, seqKV, key)
+			if err != nil && !errors.Is(err, kv.ErrNotFound) {
+				logger.Error("Failed to read log from seq-kv", "key", key, "err", err)
 				continue
 			}
 
-			if logs != nil && startOffset < len(logs) {
-				response[key] = logs[startOffset:]
+			// Entries are kept in offset order, offsets an append gave up on are missing
+			start := sort.Search(len(logs), func(i int) bool { return logs[i][0] >= startOffset })
+			if start < len(logs) {
+				response[key] = logs[start:]
 			} else {
 				response[key] = nil
 			}
 		}
 
-		if err := n.Reply(msg, map[string]interface{}{
-			"type": "poll_ok",
-			"msgs": response,
-		}); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to reply poll_ok. ERR - [%v]", err)
+		if err := n.Reply(msg, schema.NewPollOK(response)); err != nil {
+			logger.Error("Failed to reply poll_ok", "err", err)
 			return err
 		}
 
 		return nil
 	})
 
-	n.Handle("commit_offsets", func(msg maelstrom.Message) error {
-		body := make(map[string]interface{})
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			fmt.Fprintf(os.Stderr, "Error in Unmarshalling request - %q\n", err.Error())
+	logging.Handle(n, logger, "commit_offsets", func(msg maelstrom.Message, logger *slog.Logger) error {
+		body, err := schema.Decode[schema.CommitOffsets](msg)
+		if err != nil {
+			logger.Error("Error in unmarshalling request", "err", err)
 			return err
 		}
 
 		Node.mu.Lock()
-		for key, committedOffset := range body["offsets"].(map[string]interface{}) {
-			Node.committedOffsets[key] = int(committedOffset.(float64))
+		for key, committedOffset := range body.Offsets {
+			Node.committedOffsets[key] = committedOffset
 		}
 		Node.mu.Unlock()
 
-		if err := n.Reply(msg, map[string]string{"type": "commit_offsets_ok"}); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to reply commit_offsets_ok - %q\n", err.Error())
+		if err := n.Reply(msg, schema.NewCommitOffsetsOK()); err != nil {
+			logger.Error("Failed to reply commit_offsets_ok", "err", err)
 			return err
 		}
 
 		return nil
 	})
 
-	n.Handle("list_committed_offsets", func(msg maelstrom.Message) error {
+	logging.Handle(n, logger, "list_committed_offsets", func(msg maelstrom.Message, logger *slog.Logger) error {
 		Node.mu.RLock()
 		defer Node.mu.RUnlock()
 
-		if err := n.Reply(msg, map[string]interface{}{
-			"type":    "list_committed_offsets_ok",
-			"offsets": Node.committedOffsets,
-		}); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to reply commit_offsets_ok - %q\n", err.Error())
+		if err := n.Reply(msg, schema.NewListCommittedOffsetsOK(Node.committedOffsets)); err != nil {
+			logger.Error("Failed to reply commit_offsets_ok", "err", err)
 			return err
 		}
 
 		return nil
 	})
+}
 
-	if err := n.Run(); err != nil {
-		log.Fatal(err)
+func updateOffsetAndLog(ctx context.Context, key string, data int, newOffset *int, linKV, seqKV *kv.Client, logger *slog.Logger) error {
+	offset, err := kv.Update(ctx, linKV, key, -1, func(currentOffset int) (int, error) {
+		return currentOffset + 1, nil
+	})
+	if err != nil {
+		logger.Error("Failed to store offset in lin-kv", "key", key, "err", err)
+		return err
 	}
-}
 
-func updateOffsetAndLog(ctx context.Context, key string, data int, newOffset *int, linKV, seqKV *maelstrom.KV) error {
+	(*newOffset) = offset
+
+	logger.Debug("New offset", "key", key, "offset", offset)
+
+	// data input using seqKV store for common use case. The entry is inserted at its offset, so an append
+	// that lands after a later one or is given up on never shifts other entries, and retrying one with an
+	// unknown outcome is safe
 	for {
-		var currentOffset int = -1
-		if err := linKV.ReadInto(ctx, key, &currentOffset); err != nil && !strings.Contains(err.Error(), maelstrom.ErrorCodeText(maelstrom.KeyDoesNotExist)) {
+		_, err := kv.Update(ctx, seqKV, key, nil, func(currentLogs [][]int) ([][]int, error) {
+			return insertEntry(currentLogs, offset, data), nil
+		})
+		if errors.Is(err, kv.ErrIndeterminate) && ctx.Err() == nil {
+			continue
+		}
+		if err != nil {
+			logger.Error("Failed to store log in seq-kv", "key", key, "offset", offset, "err", err)
 			return err
 		}
+		return nil
+	}
+}
 
-		(*newOffset) = currentOffset + 1
-
-		fmt.Fprintf(os.Stderr, "new offset %v \n", newOffset)
-
-		if err := linKV.CompareAndSwap(ctx, key, currentOffset, newOffset, true); err == nil {
-			// data input using seqKV store for common use case
-			for {
-				var currentLogs [][]int
-				if err := seqKV.ReadInto(ctx, key, &currentLogs); err != nil && !strings.Contains(err.Error(), maelstrom.ErrorCodeText(maelstrom.KeyDoesNotExist)) {
-					return err
-				}
-
-				newLogs := append(currentLogs, []int{*newOffset, data})
-
-				if err := seqKV.CompareAndSwap(ctx, key, currentLogs, newLogs, true); err == nil {
-					break
-				} else {
-					fmt.Fprintf(os.Stderr, "Failed to store value in seqKV - %v", err)
-				}
-			}
-
-			break
-		} else {
-			fmt.Fprintf(os.Stderr, "Failed to store value in linKV - %v", err)
-		}
+// insertEntry returns logs with [offset, data] inserted in offset order, unless an entry with offset exists.
+func insertEntry(logs [][]int, offset, data int) [][]int {
+	i := sort.Search(len(logs), func(i int) bool { return logs[i][0] >= offset })
+	if i < len(logs) && logs[i][0] == offset {
+		return logs
 	}
 
-	return nil
+	next := make([][]int, 0, len(logs)+1)
+	next = append(next, logs[:i]...)
+	next = append(next, []int{offset, data})
+	return append(next, logs[i:]...)
 }
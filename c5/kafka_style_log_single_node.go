@@ -2,19 +2,34 @@ package c5
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
 	"log"
-	"os"
-	"strings"
+	"log/slog"
 	"sync"
 
+	"github.com/HdkTvd/advent-of-distributed-systems/kv"
+	"github.com/HdkTvd/advent-of-distributed-systems/logging"
+	"github.com/HdkTvd/advent-of-distributed-systems/schema"
+	"github.com/HdkTvd/advent-of-distributed-systems/workload"
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
 )
 
+func init() {
+	workload.Register("kafka-single", SetupKafkaStyleLogSingleNode)
+}
+
 func KafkaStyleLogSingleNode() {
 	n := maelstrom.NewNode()
-	seqKV := maelstrom.NewSeqKV(n)
+	SetupKafkaStyleLogSingleNode(n)
+
+	if err := n.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// SetupKafkaStyleLogSingleNode registers the single node kafka log handlers on n.
+func SetupKafkaStyleLogSingleNode(n *maelstrom.Node) {
+	seqKV := kv.NewSeqKV(n)
+	logger := logging.For(n)
 
 	Node := struct {
 		logs             map[string][][]int
@@ -27,71 +42,55 @@ func KafkaStyleLogSingleNode() {
 	}
 
 	// handlers
-	n.Handle("send", func(msg maelstrom.Message) error {
+	logging.Handle(n, logger, "send", func(msg maelstrom.Message, logger *slog.Logger) error {
 		ctx := context.Background()
 
-		body := make(map[string]interface{})
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			fmt.Fprintf(os.Stderr, "Error in Unmarshalling request - %q\n", err.Error())
+		body, err := schema.Decode[schema.Send](msg)
+		if err != nil {
+			logger.Error("Error in unmarshalling request", "err", err)
 			return err
 		}
 
-		key := body["key"].(string)
-		data := body["msg"].(float64)
-		var newOffset int
-
-		for {
-			var currentOffset int = -1
-			if err := seqKV.ReadInto(ctx, key, &currentOffset); err != nil && !strings.Contains(err.Error(), maelstrom.ErrorCodeText(maelstrom.KeyDoesNotExist)) {
-				fmt.Fprintf(os.Stderr, "Error in sequential offset read for send - %q\n", err.Error())
-				return err
-			}
-
-			newOffset = currentOffset + 1
-
-			fmt.Fprintf(os.Stderr, "new offset %v \n", newOffset)
-
-			if err := seqKV.CompareAndSwap(ctx, key, currentOffset, newOffset, true); err == nil {
-				Node.mu.Lock()
-				defer Node.mu.Unlock()
-
-				offsets, ok := Node.logs[key]
-				if !ok {
-					offsets = make([][]int, 0)
-				}
-
-				offsets = append(offsets, []int{newOffset, int(data)})
-				Node.logs[key] = offsets
+		key := body.Key
+		data := body.Msg
+		newOffset, err := kv.Update(ctx, seqKV, key, -1, func(currentOffset int) (int, error) {
+			return currentOffset + 1, nil
+		})
+		if err != nil {
+			logger.Error("Failed to store offset in seq-kv", "key", key, "err", err)
+			return err
+		}
 
-				break
-			} else {
-				fmt.Fprintf(os.Stderr, "Failed to store value in CAS - %v", err)
-			}
+		Node.mu.Lock()
+		offsets, ok := Node.logs[key]
+		if !ok {
+			offsets = make([][]int, 0)
 		}
 
-		fmt.Fprintf(os.Stderr, "send_ok | newOffset - %v | key - %v\n", newOffset, key)
+		offsets = append(offsets, []int{newOffset, data})
+		Node.logs[key] = offsets
+		Node.mu.Unlock()
 
-		if err := n.Reply(msg, map[string]interface{}{
-			"type":   "send_ok",
-			"offset": newOffset,
-		}); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to reply send_ok with new offset value - %q\n", err.Error())
+		logger.Debug("send_ok", "key", key, "offset", newOffset)
+
+		if err := n.Reply(msg, schema.NewSendOK(newOffset)); err != nil {
+			logger.Error("Failed to reply send_ok", "offset", newOffset, "err", err)
 			return err
 		}
 
 		return nil
 	})
 
-	n.Handle("poll", func(msg maelstrom.Message) error {
-		body := make(map[string]interface{})
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			fmt.Fprintf(os.Stderr, "Error in Unmarshalling request - %q\n", err.Error())
+	logging.Handle(n, logger, "poll", func(msg maelstrom.Message, logger *slog.Logger) error {
+		body, err := schema.Decode[schema.Poll](msg)
+		if err != nil {
+			logger.Error("Error in unmarshalling request", "err", err)
 			return err
 		}
 
-		reqLogOffsets := body["offsets"].(map[string]interface{})
+		reqLogOffsets := body.Offsets
 
-		fmt.Fprintf(os.Stderr, "polled offsets - %v \n", reqLogOffsets)
+		logger.Debug("Polled offsets", "offsets", reqLogOffsets)
 
 		var response = make(map[string][][]int, 0)
 
@@ -99,10 +98,7 @@ func KafkaStyleLogSingleNode() {
 		defer Node.mu.RUnlock()
 
 		for key, offset := range reqLogOffsets {
-			startOffset := int(offset.(float64))
-
-			fmt.Fprintf(os.Stderr, "key %v | logs - %v \n", key, Node.logs[key])
-			fmt.Fprintf(os.Stderr, "individual polled offsets - %v \n", offset)
+			startOffset := offset
 
 			log, ok := Node.logs[key]
 			if !ok {
@@ -116,54 +112,44 @@ func KafkaStyleLogSingleNode() {
 			}
 		}
 
-		if err := n.Reply(msg, map[string]interface{}{
-			"type": "poll_ok",
-			"msgs": response,
-		}); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to reply poll_ok. ERR - [%v]", err)
+		if err := n.Reply(msg, schema.NewPollOK(response)); err != nil {
+			logger.Error("Failed to reply poll_ok", "err", err)
 			return err
 		}
 
 		return nil
 	})
 
-	n.Handle("commit_offsets", func(msg maelstrom.Message) error {
-		body := make(map[string]interface{})
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			fmt.Fprintf(os.Stderr, "Error in Unmarshalling request - %q\n", err.Error())
+	logging.Handle(n, logger, "commit_offsets", func(msg maelstrom.Message, logger *slog.Logger) error {
+		body, err := schema.Decode[schema.CommitOffsets](msg)
+		if err != nil {
+			logger.Error("Error in unmarshalling request", "err", err)
 			return err
 		}
 
 		Node.mu.Lock()
-		for key, committedOffset := range body["offsets"].(map[string]interface{}) {
-			Node.committedOffsets[key] = int(committedOffset.(float64))
+		for key, committedOffset := range body.Offsets {
+			Node.committedOffsets[key] = committedOffset
 		}
 		Node.mu.Unlock()
 
-		if err := n.Reply(msg, map[string]string{"type": "commit_offsets_ok"}); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to reply commit_offsets_ok - %q\n", err.Error())
+		if err := n.Reply(msg, schema.NewCommitOffsetsOK()); err != nil {
+			logger.Error("Failed to reply commit_offsets_ok", "err", err)
 			return err
 		}
 
 		return nil
 	})
 
-	n.Handle("list_committed_offsets", func(msg maelstrom.Message) error {
+	logging.Handle(n, logger, "list_committed_offsets", func(msg maelstrom.Message, logger *slog.Logger) error {
 		Node.mu.RLock()
 		defer Node.mu.RUnlock()
 
-		if err := n.Reply(msg, map[string]interface{}{
-			"type":    "list_committed_offsets_ok",
-			"offsets": Node.committedOffsets,
-		}); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to reply commit_offsets_ok - %q\n", err.Error())
+		if err := n.Reply(msg, schema.NewListCommittedOffsetsOK(Node.committedOffsets)); err != nil {
+			logger.Error("Failed to reply commit_offsets_ok", "err", err)
 			return err
 		}
 
 		return nil
 	})
-
-	if err := n.Run(); err != nil {
-		log.Fatal(err)
-	}
 }
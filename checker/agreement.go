@@ -0,0 +1,80 @@
+package checker
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/HdkTvd/advent-of-distributed-systems/schema"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// DeliveryHistory keeps the completed read requests of the Bracha broadcast,
+// with the deliveries they returned as output.
+func DeliveryHistory(ops []Operation) []Operation {
+	return convert(ops, func(op Operation, typ string, req, reply json.RawMessage) []Operation {
+		if typ != "read" || reply == nil {
+			return nil
+		}
+		res, err := schema.Decode[schema.BrachaReadOK](maelstrom.Message{Body: reply})
+		if err != nil {
+			return nil
+		}
+		return []Operation{withOutput(op, "read", res.Deliveries, true)}
+	})
+}
+
+// AgreementResult is the outcome of CheckAgreement.
+type AgreementResult struct {
+	Agree bool
+	// A and B are two reads that returned different values for the Seq-th
+	// value of Sender
+	A, B   Operation
+	Sender string
+	Seq    int
+}
+
+func (r AgreementResult) String() string {
+	if r.Agree {
+		return "deliveries agree"
+	}
+	return fmt.Sprintf("reads delivered %d and %d as %s's value %d:\n  p%d [%d, %d]\n  p%d [%d, %d]",
+		delivered(r.A, r.Sender, r.Seq), delivered(r.B, r.Sender, r.Seq), r.Sender, r.Seq,
+		r.A.Process, r.A.Call, r.A.Return, r.B.Process, r.B.Call, r.B.Return)
+}
+
+// delivered returns the value read delivered for (sender, seq).
+func delivered(read Operation, sender string, seq int) int {
+	for _, d := range read.Output.([]schema.Delivery) {
+		if d.Sender == sender && d.Seq == seq {
+			return d.Message
+		}
+	}
+	return 0
+}
+
+// CheckAgreement checks that the reads of DeliveryHistory never returned two
+// different values for the same sender and sequence number, within one read
+// or across reads. Reads of Byzantine nodes must be left out, they can return
+// anything.
+func CheckAgreement(reads []Operation) AgreementResult {
+	type key struct {
+		sender string
+		seq    int
+	}
+	first := make(map[key]Operation)
+
+	for _, read := range reads {
+		for _, d := range read.Output.([]schema.Delivery) {
+			k := key{d.Sender, d.Seq}
+			prev, ok := first[k]
+			if !ok {
+				first[k] = read
+				continue
+			}
+			if delivered(prev, d.Sender, d.Seq) != d.Message {
+				return AgreementResult{A: prev, B: read, Sender: d.Sender, Seq: d.Seq}
+			}
+		}
+	}
+	return AgreementResult{Agree: true}
+}
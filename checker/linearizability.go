@@ -0,0 +1,285 @@
+// Package checker verifies recorded operation histories, such as checking that
+// a history of client operations is linearizable against a sequential model.
+package checker
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Unknown is the Return time of an operation whose outcome is unknown, for
+// example because the client timed out. It may take effect at any point
+// after its call, or not at all.
+const Unknown = math.MaxInt64
+
+// Operation is a completed (or indeterminate) client operation.
+type Operation struct {
+	Process int
+	Input   any
+	// Output is nil when Return is Unknown.
+	Output any
+	Call   int64
+	Return int64
+}
+
+func (op Operation) String() string {
+	ret := "?"
+	if op.Return != Unknown {
+		ret = fmt.Sprint(op.Return)
+	}
+	return fmt.Sprintf("p%d [%d, %s] %+v -> %+v", op.Process, op.Call, ret, op.Input, op.Output)
+}
+
+// Model is a sequential specification of the object the history operated on.
+type Model struct {
+	// Partition splits a history into independent sub-histories, e.g. per key.
+	// Nil checks the whole history at once.
+	Partition func(ops []Operation) [][]Operation
+	// Init returns the initial state.
+	Init func() any
+	// Step returns every state the object can be in after applying input to
+	// state and observing output. A nil output (unknown) should allow every
+	// outcome, including the operation having no effect. No states means the
+	// operation is not legal in state.
+	Step func(state, input, output any) []any
+	// Equal compares states, defaults to reflect.DeepEqual.
+	Equal func(a, b any) bool
+}
+
+// Result is the outcome of Check.
+type Result struct {
+	Linearizable bool
+	// Longest is the longest linearization found in the failing partition.
+	Longest []Operation
+	// CounterExample is a minimal sub-history of the failing partition that is
+	// not linearizable on its own. It is nil for partitions too large to
+	// minimize.
+	CounterExample []Operation
+}
+
+func (r Result) String() string {
+	if r.Linearizable {
+		return "linearizable"
+	}
+
+	var sb strings.Builder
+	sb.WriteString("not linearizable\ncounter-example:\n")
+	for _, op := range r.CounterExample {
+		fmt.Fprintf(&sb, "  %v\n", op)
+	}
+	sb.WriteString("longest linearization:\n")
+	for _, op := range r.Longest {
+		fmt.Fprintf(&sb, "  %v\n", op)
+	}
+	return sb.String()
+}
+
+// Check reports whether history is linearizable with respect to model. It
+// returns ctx's error if the search does not finish in time.
+func Check(ctx context.Context, model Model, history []Operation) (Result, error) {
+	partitions := [][]Operation{history}
+	if model.Partition != nil {
+		partitions = model.Partition(history)
+	}
+
+	for _, ops := range partitions {
+		ok, longest, err := search(ctx, model, ops)
+		if err != nil {
+			return Result{}, err
+		}
+		if ok {
+			continue
+		}
+
+		return Result{Longest: longest, CounterExample: minimize(ctx, model, ops)}, nil
+	}
+
+	return Result{Linearizable: true}, nil
+}
+
+// searcher is a Wing & Gong search with Lowe's cache of visited
+// (linearized set, state) pairs.
+type searcher struct {
+	ctx     context.Context
+	model   Model
+	ops     []Operation
+	done    bitset
+	order   []int
+	longest []int
+	cache   map[string][]any
+	steps   int
+}
+
+func search(ctx context.Context, model Model, history []Operation) (bool, []Operation, error) {
+	// Trying the operations that return first keeps the search close to the
+	// real order, which finds a linearization quickly for most histories
+	ops := append([]Operation(nil), history...)
+	sort.SliceStable(ops, func(i, j int) bool { return ops[i].Return < ops[j].Return })
+
+	s := &searcher{
+		ctx:   ctx,
+		model: model,
+		ops:   ops,
+		done:  newBitset(len(ops)),
+		cache: make(map[string][]any),
+	}
+
+	ok, err := s.visit(model.Init())
+	if err != nil {
+		return false, nil, err
+	}
+
+	longest := make([]Operation, len(s.longest))
+	for i, idx := range s.longest {
+		longest[i] = ops[idx]
+	}
+	return ok, longest, nil
+}
+
+func (s *searcher) visit(state any) (bool, error) {
+	if len(s.order) == len(s.ops) {
+		return true, nil
+	}
+
+	s.steps++
+	if s.steps%1024 == 0 {
+		if err := s.ctx.Err(); err != nil {
+			return false, err
+		}
+	}
+
+	if !s.remember(state) {
+		return false, nil
+	}
+
+	// Only operations called before every pending operation returned can be
+	// linearized next. ops is sorted by return, so the first pending one
+	// returns first.
+	minReturn := int64(Unknown)
+	for i, op := range s.ops {
+		if !s.done.get(i) {
+			minReturn = op.Return
+			break
+		}
+	}
+
+	// An operation that is legal now and leaves the state unchanged, like a
+	// read, can always be linearized right away: the pending operations did
+	// not return before it was called, and nothing else observes it. Taking it
+	// without branching avoids trying every interleaving of concurrent reads.
+	steps := make([][]any, len(s.ops))
+	for i, op := range s.ops {
+		if s.done.get(i) || op.Call > minReturn {
+			continue
+		}
+
+		steps[i] = s.model.Step(state, op.Input, op.Output)
+		if len(steps[i]) == 1 && s.equal(steps[i][0], state) {
+			return s.linearize(i, state)
+		}
+	}
+
+	for i := range s.ops {
+		for _, next := range steps[i] {
+			ok, err := s.linearize(i, next)
+			if ok || err != nil {
+				return ok, err
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// linearize appends op i to the linearization, continues the search from
+// state and undoes the step if that fails.
+func (s *searcher) linearize(i int, state any) (bool, error) {
+	s.done.set(i)
+	s.order = append(s.order, i)
+	if len(s.order) > len(s.longest) {
+		s.longest = append(s.longest[:0], s.order...)
+	}
+
+	ok, err := s.visit(state)
+	if ok || err != nil {
+		return ok, err
+	}
+
+	s.order = s.order[:len(s.order)-1]
+	s.done.clear(i)
+
+	return false, nil
+}
+
+func (s *searcher) equal(a, b any) bool {
+	if s.model.Equal != nil {
+		return s.model.Equal(a, b)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// remember records state for the current linearized set and reports whether
+// it was new.
+func (s *searcher) remember(state any) bool {
+	key := s.done.key()
+	for _, seen := range s.cache[key] {
+		if s.equal(seen, state) {
+			return false
+		}
+	}
+	s.cache[key] = append(s.cache[key], state)
+
+	return true
+}
+
+// maxMinimize is the largest history minimize drops operations from.
+const maxMinimize = 256
+
+// minimize drops operations from a non-linearizable history for as long as
+// the rest stays non-linearizable. It tries the latest operations first, so
+// the earlier operations that explain a violation are kept. If ctx ends the
+// example found so far is returned.
+func minimize(ctx context.Context, model Model, ops []Operation) []Operation {
+	if len(ops) > maxMinimize {
+		return nil
+	}
+
+	example := append([]Operation(nil), ops...)
+	sort.SliceStable(example, func(i, j int) bool { return example[i].Return < example[j].Return })
+
+	for i := len(example) - 1; i >= 0; i-- {
+		candidate := append(append([]Operation(nil), example[:i]...), example[i+1:]...)
+		ok, _, err := search(ctx, model, candidate)
+		if err != nil {
+			break
+		}
+		if !ok {
+			example = candidate
+		}
+	}
+
+	return example
+}
+
+type bitset []uint64
+
+func newBitset(n int) bitset {
+	return make(bitset, (n+63)/64)
+}
+
+func (b bitset) get(i int) bool { return b[i/64]&(1<<(i%64)) != 0 }
+func (b bitset) set(i int)      { b[i/64] |= 1 << (i % 64) }
+func (b bitset) clear(i int)    { b[i/64] &^= 1 << (i % 64) }
+
+func (b bitset) key() string {
+	buf := make([]byte, 0, len(b)*8)
+	for _, w := range b {
+		buf = binary.LittleEndian.AppendUint64(buf, w)
+	}
+	return string(buf)
+}
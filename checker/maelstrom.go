@@ -0,0 +1,216 @@
+package checker
+
+import (
+	"encoding/json"
+
+	"github.com/HdkTvd/advent-of-distributed-systems/schema"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// The functions below turn a history of raw maelstrom bodies, as recorded by
+// the harness with json.RawMessage inputs and outputs, into the typed inputs
+// and outputs of a model. Operations that failed with a definite error are
+// dropped, those that timed out or crashed are kept as unknown.
+
+// RegisterHistory converts read, write and cas requests to a key/value service.
+func RegisterHistory(ops []Operation) []Operation {
+	return convert(ops, func(op Operation, typ string, req, reply json.RawMessage) []Operation {
+		var body struct {
+			Key               any  `json:"key"`
+			Value             any  `json:"value"`
+			From              any  `json:"from"`
+			To                any  `json:"to"`
+			CreateIfNotExists bool `json:"create_if_not_exists"`
+		}
+		if json.Unmarshal(req, &body) != nil {
+			return nil
+		}
+		in := RegisterInput{Key: keyString(body.Key), Value: body.Value, From: body.From, To: body.To, Create: body.CreateIfNotExists}
+
+		var out RegisterOutput
+		switch typ {
+		case "read":
+			in.Kind = RegisterRead
+			if reply != nil {
+				var res struct {
+					Value any `json:"value"`
+				}
+				if code := errorCode(reply); code == maelstrom.KeyDoesNotExist {
+					out = RegisterOutput{}
+				} else if code >= 0 || json.Unmarshal(reply, &res) != nil {
+					return nil
+				} else {
+					out = RegisterOutput{Value: res.Value, Exists: true}
+				}
+			}
+		case "write":
+			in.Kind = RegisterWrite
+		case "cas":
+			in.Kind = RegisterCAS
+			if reply != nil {
+				switch errorCode(reply) {
+				case -1:
+					out.OK = true
+				case maelstrom.PreconditionFailed, maelstrom.KeyDoesNotExist:
+					out.OK = false
+				default:
+					return nil
+				}
+			}
+		default:
+			return nil
+		}
+
+		return []Operation{withOutput(op, in, out, reply != nil)}
+	}, maelstrom.KeyDoesNotExist, maelstrom.PreconditionFailed)
+}
+
+// CounterHistory converts add and read requests of the grow-only counter.
+func CounterHistory(ops []Operation) []Operation {
+	return convert(ops, func(op Operation, typ string, req, reply json.RawMessage) []Operation {
+		switch typ {
+		case "add":
+			body, err := schema.Decode[schema.Add](maelstrom.Message{Body: req})
+			if err != nil {
+				return nil
+			}
+			return []Operation{withOutput(op, CounterInput{Kind: CounterAdd, Delta: body.Delta}, CounterOutput{}, reply != nil)}
+		case "read":
+			in := CounterInput{Kind: CounterRead}
+			if reply == nil {
+				return []Operation{withOutput(op, in, nil, false)}
+			}
+			res, err := schema.Decode[schema.CounterReadOK](maelstrom.Message{Body: reply})
+			if err != nil {
+				return nil
+			}
+			return []Operation{withOutput(op, in, CounterOutput{Value: res.Value}, true)}
+		}
+		return nil
+	})
+}
+
+// LogHistory converts send and poll requests of the kafka-style log. A poll
+// of several keys becomes one operation per key.
+func LogHistory(ops []Operation) []Operation {
+	return convert(ops, func(op Operation, typ string, req, reply json.RawMessage) []Operation {
+		switch typ {
+		case "send":
+			body, err := schema.Decode[schema.Send](maelstrom.Message{Body: req})
+			if err != nil {
+				return nil
+			}
+			in := LogInput{Kind: LogSend, Key: body.Key, Msg: body.Msg}
+			if reply == nil {
+				return []Operation{withOutput(op, in, nil, false)}
+			}
+			res, err := schema.Decode[schema.SendOK](maelstrom.Message{Body: reply})
+			if err != nil {
+				return nil
+			}
+			return []Operation{withOutput(op, in, LogOutput{Offset: res.Offset}, true)}
+		case "poll":
+			body, err := schema.Decode[schema.Poll](maelstrom.Message{Body: req})
+			if err != nil {
+				return nil
+			}
+			var res schema.PollOK
+			if reply != nil {
+				if res, err = schema.Decode[schema.PollOK](maelstrom.Message{Body: reply}); err != nil {
+					return nil
+				}
+			}
+
+			var converted []Operation
+			for key, offset := range body.Offsets {
+				in := LogInput{Kind: LogPoll, Key: key, Offset: offset}
+				if reply == nil {
+					converted = append(converted, withOutput(op, in, nil, false))
+					continue
+				}
+				var out LogOutput
+				for _, m := range res.Msgs[key] {
+					if len(m) == 2 {
+						out.Msgs = append(out.Msgs, [2]int{m[0], m[1]})
+					}
+				}
+				converted = append(converted, withOutput(op, in, out, true))
+			}
+			return converted
+		}
+		return nil
+	})
+}
+
+// convert decodes the request type and reply of each raw operation and hands
+// them to fn. A nil reply means the outcome is unknown. Error replies with
+// a code in passThrough reach fn, other definite errors drop the operation.
+func convert(ops []Operation, fn func(op Operation, typ string, req, reply json.RawMessage) []Operation, passThrough ...int) []Operation {
+	var converted []Operation
+	for _, op := range ops {
+		req, ok := op.Input.(json.RawMessage)
+		if !ok {
+			continue
+		}
+		reply, _ := op.Output.(json.RawMessage)
+		if op.Return == Unknown {
+			reply = nil
+		}
+
+		if code := errorCode(reply); code >= 0 && !contains(passThrough, code) {
+			if code == maelstrom.Timeout || code == maelstrom.Crash {
+				reply = nil
+			} else {
+				continue
+			}
+		}
+
+		converted = append(converted, fn(op, (&maelstrom.Message{Body: req}).Type(), req, reply)...)
+	}
+
+	return converted
+}
+
+// errorCode returns the error code of an error reply, or -1.
+func errorCode(reply json.RawMessage) int {
+	if reply == nil {
+		return -1
+	}
+	msg := maelstrom.Message{Body: reply}
+	if msg.Type() != "error" {
+		return -1
+	}
+	// RPCError reports code 0 as no error, but for an error reply it is a timeout
+	if err := msg.RPCError(); err != nil {
+		return err.Code
+	}
+	return maelstrom.Timeout
+}
+
+func withOutput(op Operation, input, output any, known bool) Operation {
+	op.Input = input
+	if known {
+		op.Output = output
+	} else {
+		op.Output = nil
+		op.Return = Unknown
+	}
+	return op
+}
+
+func keyString(key any) string {
+	if k, ok := key.(string); ok {
+		return k
+	}
+	b, _ := json.Marshal(key)
+	return string(b)
+}
+
+func contains(codes []int, code int) bool {
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
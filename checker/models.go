@@ -0,0 +1,297 @@
+package checker
+
+import (
+	"math"
+	"reflect"
+)
+
+// RegisterKind is the kind of a register operation.
+type RegisterKind int
+
+const (
+	RegisterRead RegisterKind = iota
+	RegisterWrite
+	RegisterCAS
+)
+
+func (k RegisterKind) String() string {
+	return [...]string{"read", "write", "cas"}[k]
+}
+
+// RegisterInput is a read, write or cas on the register named Key. A cas
+// with Create also applies when the register was never written.
+type RegisterInput struct {
+	Kind     RegisterKind
+	Key      string
+	Value    any
+	From, To any
+	Create   bool
+}
+
+// RegisterOutput is the outcome of a register operation. Exists and Value are
+// set by reads, OK tells whether a cas was applied.
+type RegisterOutput struct {
+	Value  any
+	Exists bool
+	OK     bool
+}
+
+// registerState is the value of a register, Exists is false until written.
+type registerState struct {
+	Value  any
+	Exists bool
+}
+
+// RegisterModel is a set of independent compare-and-set registers, one per
+// key, like the maelstrom key/value services.
+func RegisterModel() Model {
+	return Model{
+		Partition: PartitionBy(func(input any) string { return input.(RegisterInput).Key }),
+		Init:      func() any { return registerState{} },
+		Step: func(state, input, output any) []any {
+			st, in := state.(registerState), input.(RegisterInput)
+			out, known := output.(RegisterOutput)
+
+			switch in.Kind {
+			case RegisterRead:
+				if !known || (out.Exists == st.Exists && (!st.Exists || reflect.DeepEqual(out.Value, st.Value))) {
+					return []any{st}
+				}
+				return nil
+			case RegisterWrite:
+				next := registerState{Value: in.Value, Exists: true}
+				if !known {
+					return []any{st, next}
+				}
+				return []any{next}
+			case RegisterCAS:
+				matches := (st.Exists && reflect.DeepEqual(st.Value, in.From)) || (!st.Exists && in.Create)
+				next := registerState{Value: in.To, Exists: true}
+				switch {
+				case !known && matches:
+					return []any{st, next}
+				case !known, known && !out.OK && !matches:
+					return []any{st}
+				case known && out.OK && matches:
+					return []any{next}
+				}
+				return nil
+			}
+
+			return nil
+		},
+	}
+}
+
+// CounterKind is the kind of a counter operation.
+type CounterKind int
+
+const (
+	CounterAdd CounterKind = iota
+	CounterRead
+)
+
+func (k CounterKind) String() string {
+	return [...]string{"add", "read"}[k]
+}
+
+// CounterInput adds Delta to the counter, or reads it.
+type CounterInput struct {
+	Kind  CounterKind
+	Delta int
+}
+
+// CounterOutput is the value returned by a read.
+type CounterOutput struct {
+	Value int
+}
+
+// CounterModel is a single counter starting at zero, like the grow-only
+// counter workload.
+func CounterModel() Model {
+	return Model{
+		Init: func() any { return 0 },
+		Step: func(state, input, output any) []any {
+			value, in := state.(int), input.(CounterInput)
+			out, known := output.(CounterOutput)
+
+			switch in.Kind {
+			case CounterAdd:
+				if !known {
+					return []any{value, value + in.Delta}
+				}
+				return []any{value + in.Delta}
+			case CounterRead:
+				if !known || out.Value == value {
+					return []any{value}
+				}
+			}
+
+			return nil
+		},
+	}
+}
+
+// LogKind is the kind of a log operation.
+type LogKind int
+
+const (
+	LogSend LogKind = iota
+	LogPoll
+)
+
+func (k LogKind) String() string {
+	return [...]string{"send", "poll"}[k]
+}
+
+// LogInput appends Msg to the log named Key, or polls it from Offset.
+type LogInput struct {
+	Kind   LogKind
+	Key    string
+	Msg    int
+	Offset int
+}
+
+// LogOutput is the offset a send was stored at, or the [offset, msg] pairs
+// returned by a poll.
+type LogOutput struct {
+	Offset int
+	Msgs   [][2]int
+}
+
+// LogModel is a set of append-only logs, one per key, like the kafka-style log
+// workload. Offsets must grow with every send but may skip values. A poll may
+// return any prefix of the entries at or after its offset. A send with an
+// unknown outcome either never happened or appended at an offset that stays
+// open until a poll shows it.
+func LogModel() Model {
+	return Model{
+		Partition: PartitionBy(func(input any) string { return input.(LogInput).Key }),
+		Init:      func() any { return [][2]int(nil) },
+		Step: func(state, input, output any) []any {
+			entries, in := state.([][2]int), input.(LogInput)
+			out, known := output.(LogOutput)
+
+			switch in.Kind {
+			case LogSend:
+				if !known {
+					// It may have landed, anywhere after the last entry
+					return []any{entries, append(append([][2]int(nil), entries...), [2]int{openOffset, in.Msg})}
+				}
+				next := append(append([][2]int(nil), entries...), [2]int{out.Offset, in.Msg})
+				if !ordered(next) {
+					return nil
+				}
+				return []any{next}
+			case LogPoll:
+				if !known || len(out.Msgs) == 0 {
+					return []any{entries}
+				}
+				return pollStates(entries, in.Offset, out.Msgs)
+			}
+
+			return nil
+		},
+		Equal: func(a, b any) bool {
+			x, y := a.([][2]int), b.([][2]int)
+			if len(x) != len(y) {
+				return false
+			}
+			for i := range x {
+				if x[i] != y[i] {
+					return false
+				}
+			}
+			return true
+		},
+	}
+}
+
+// openOffset is the offset of a log entry whose send has an unknown outcome and
+// that no poll has shown yet.
+const openOffset = -1
+
+// ordered reports whether the open offsets of entries can be filled in so that
+// offsets grow with every entry.
+func ordered(entries [][2]int) bool {
+	lowest := -1
+	for _, e := range entries {
+		if e[0] == openOffset {
+			lowest++
+			continue
+		}
+		if e[0] <= lowest {
+			return false
+		}
+		lowest = e[0]
+	}
+	return true
+}
+
+// pollStates returns the logs in which msgs are the entries at or after offset,
+// with the offsets of the open entries among them filled in.
+func pollStates(entries [][2]int, offset int, msgs [][2]int) []any {
+	// lowest and highest bound the offset every entry can have
+	lowest, highest := make([]int, len(entries)), make([]int, len(entries))
+	for i, bound := 0, -1; i < len(entries); i++ {
+		bound++
+		if entries[i][0] != openOffset {
+			bound = entries[i][0]
+		}
+		lowest[i] = bound
+	}
+	for i, bound := len(entries)-1, math.MaxInt; i >= 0; i-- {
+		bound--
+		if entries[i][0] != openOffset {
+			bound = entries[i][0]
+		}
+		highest[i] = bound
+	}
+
+	var states []any
+	for start := 0; start+len(msgs) <= len(entries); start++ {
+		// Entries before start are below offset, the one at start is not
+		if start > 0 && lowest[start-1] >= offset {
+			break
+		}
+		if highest[start] < offset {
+			continue
+		}
+
+		next := append([][2]int(nil), entries...)
+		ok := true
+		for i, m := range msgs {
+			e := &next[start+i]
+			if e[1] != m[1] || m[0] < offset || e[0] != openOffset && e[0] != m[0] {
+				ok = false
+				break
+			}
+			e[0] = m[0]
+		}
+		if ok && ordered(next) {
+			states = append(states, next)
+		}
+	}
+
+	return states
+}
+
+// PartitionBy splits a history by the key of each operation's input, in order
+// of first appearance.
+func PartitionBy(key func(input any) string) func(ops []Operation) [][]Operation {
+	return func(ops []Operation) [][]Operation {
+		index := make(map[string]int)
+		var partitions [][]Operation
+		for _, op := range ops {
+			k := key(op.Input)
+			i, ok := index[k]
+			if !ok {
+				i = len(partitions)
+				index[k] = i
+				partitions = append(partitions, nil)
+			}
+			partitions[i] = append(partitions[i], op)
+		}
+		return partitions
+	}
+}
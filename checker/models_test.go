@@ -0,0 +1,165 @@
+package checker
+
+import (
+	"context"
+	"testing"
+)
+
+// op is an operation of process p called at call and returning at ret, Unknown if ret is 0.
+func op(p int, call, ret int64, input, output any) Operation {
+	if ret == 0 {
+		return Operation{Process: p, Input: input, Call: call, Return: Unknown}
+	}
+	return Operation{Process: p, Input: input, Output: output, Call: call, Return: ret}
+}
+
+func check(t *testing.T, model Model, history []Operation, want bool) {
+	t.Helper()
+
+	result, err := Check(context.Background(), model, history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Linearizable != want {
+		t.Fatalf("linearizable = %v, want %v\n%s", result.Linearizable, want, result)
+	}
+	if !want && len(result.CounterExample) == 0 {
+		t.Fatal("no counter-example")
+	}
+}
+
+func TestRegisterModel(t *testing.T) {
+	write := func(v any) RegisterInput { return RegisterInput{Kind: RegisterWrite, Key: "x", Value: v} }
+	read := RegisterInput{Kind: RegisterRead, Key: "x"}
+	cas := func(from, to any) RegisterInput {
+		return RegisterInput{Kind: RegisterCAS, Key: "x", From: from, To: to}
+	}
+
+	t.Run("linearizable", func(t *testing.T) {
+		check(t, RegisterModel(), []Operation{
+			op(0, 0, 10, write(1), RegisterOutput{}),
+			op(1, 5, 20, cas(1, 2), RegisterOutput{OK: true}),
+			op(2, 6, 8, read, RegisterOutput{Value: 1, Exists: true}),
+			op(2, 25, 30, read, RegisterOutput{Value: 2, Exists: true}),
+		}, true)
+	})
+
+	t.Run("stale read", func(t *testing.T) {
+		check(t, RegisterModel(), []Operation{
+			op(0, 0, 10, write(1), RegisterOutput{}),
+			op(1, 11, 20, cas(1, 2), RegisterOutput{OK: true}),
+			op(2, 21, 30, read, RegisterOutput{Value: 1, Exists: true}),
+		}, false)
+	})
+
+	t.Run("two cas from the same value", func(t *testing.T) {
+		check(t, RegisterModel(), []Operation{
+			op(0, 0, 10, write(1), RegisterOutput{}),
+			op(1, 11, 20, cas(1, 2), RegisterOutput{OK: true}),
+			op(2, 11, 20, cas(1, 3), RegisterOutput{OK: true}),
+		}, false)
+	})
+
+	t.Run("unknown write may land later", func(t *testing.T) {
+		check(t, RegisterModel(), []Operation{
+			op(0, 0, 0, write(1), nil),
+			op(1, 5, 10, read, RegisterOutput{}),
+			op(1, 15, 20, read, RegisterOutput{Value: 1, Exists: true}),
+		}, true)
+	})
+}
+
+func TestCounterModel(t *testing.T) {
+	add := func(d int) CounterInput { return CounterInput{Kind: CounterAdd, Delta: d} }
+	read := CounterInput{Kind: CounterRead}
+
+	t.Run("linearizable", func(t *testing.T) {
+		check(t, CounterModel(), []Operation{
+			op(0, 0, 10, add(1), CounterOutput{}),
+			op(1, 0, 10, add(2), CounterOutput{}),
+			op(2, 5, 8, read, CounterOutput{Value: 2}),
+			op(2, 11, 12, read, CounterOutput{Value: 3}),
+		}, true)
+	})
+
+	t.Run("lost add", func(t *testing.T) {
+		check(t, CounterModel(), []Operation{
+			op(0, 0, 10, add(1), CounterOutput{}),
+			op(1, 0, 10, add(2), CounterOutput{}),
+			op(2, 11, 12, read, CounterOutput{Value: 2}),
+		}, false)
+	})
+}
+
+func TestLogModel(t *testing.T) {
+	send := func(msg int) LogInput { return LogInput{Kind: LogSend, Key: "k", Msg: msg} }
+	poll := func(offset int) LogInput { return LogInput{Kind: LogPoll, Key: "k", Offset: offset} }
+
+	t.Run("linearizable", func(t *testing.T) {
+		check(t, LogModel(), []Operation{
+			op(0, 0, 10, send(7), LogOutput{Offset: 0}),
+			op(1, 5, 15, send(8), LogOutput{Offset: 3}),
+			op(2, 6, 9, poll(0), LogOutput{Msgs: [][2]int{{0, 7}}}),
+			op(2, 20, 25, poll(1), LogOutput{Msgs: [][2]int{{3, 8}}}),
+		}, true)
+	})
+
+	t.Run("offsets go back", func(t *testing.T) {
+		check(t, LogModel(), []Operation{
+			op(0, 0, 10, send(7), LogOutput{Offset: 2}),
+			op(1, 11, 15, send(8), LogOutput{Offset: 1}),
+		}, false)
+	})
+
+	t.Run("poll skips an entry", func(t *testing.T) {
+		check(t, LogModel(), []Operation{
+			op(0, 0, 10, send(7), LogOutput{Offset: 0}),
+			op(0, 11, 20, send(8), LogOutput{Offset: 1}),
+			op(1, 21, 30, poll(0), LogOutput{Msgs: [][2]int{{1, 8}}}),
+		}, false)
+	})
+
+	t.Run("unknown send that landed", func(t *testing.T) {
+		check(t, LogModel(), []Operation{
+			op(0, 0, 10, send(7), LogOutput{Offset: 0}),
+			op(0, 11, 0, send(8), nil),
+			op(1, 21, 30, poll(0), LogOutput{Msgs: [][2]int{{0, 7}, {1, 8}}}),
+		}, true)
+	})
+
+	t.Run("unknown send that did not land", func(t *testing.T) {
+		check(t, LogModel(), []Operation{
+			op(0, 0, 0, send(8), nil),
+			op(1, 11, 20, send(7), LogOutput{Offset: 0}),
+			op(1, 21, 30, poll(0), LogOutput{Msgs: [][2]int{{0, 7}}}),
+		}, true)
+	})
+
+	t.Run("unknown send that landed at a skipped offset", func(t *testing.T) {
+		check(t, LogModel(), []Operation{
+			op(0, 0, 10, send(7), LogOutput{Offset: 0}),
+			op(0, 11, 0, send(8), nil),
+			op(1, 21, 30, poll(1), LogOutput{Msgs: [][2]int{{4, 8}}}),
+			op(1, 31, 40, send(9), LogOutput{Offset: 5}),
+			op(1, 41, 50, poll(0), LogOutput{Msgs: [][2]int{{0, 7}, {4, 8}, {5, 9}}}),
+		}, true)
+	})
+
+	t.Run("unknown send polled at two offsets", func(t *testing.T) {
+		check(t, LogModel(), []Operation{
+			op(0, 0, 10, send(7), LogOutput{Offset: 0}),
+			op(0, 11, 0, send(8), nil),
+			op(1, 21, 30, poll(0), LogOutput{Msgs: [][2]int{{0, 7}, {4, 8}}}),
+			op(1, 31, 40, poll(0), LogOutput{Msgs: [][2]int{{0, 7}, {3, 8}}}),
+		}, false)
+	})
+
+	t.Run("known send below an unknown one", func(t *testing.T) {
+		check(t, LogModel(), []Operation{
+			op(0, 0, 10, send(7), LogOutput{Offset: 2}),
+			op(0, 11, 0, send(8), nil),
+			op(1, 21, 30, poll(0), LogOutput{Msgs: [][2]int{{2, 7}, {6, 8}}}),
+			op(1, 31, 40, send(9), LogOutput{Offset: 5}),
+		}, false)
+	})
+}
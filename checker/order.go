@@ -0,0 +1,84 @@
+package checker
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/HdkTvd/advent-of-distributed-systems/schema"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// ReadHistory keeps the completed read requests of a broadcast, with the
+// values they returned, in order, as output.
+func ReadHistory(ops []Operation) []Operation {
+	return convert(ops, func(op Operation, typ string, req, reply json.RawMessage) []Operation {
+		if typ != "read" || reply == nil {
+			return nil
+		}
+		res, err := schema.Decode[schema.ReadOK](maelstrom.Message{Body: reply})
+		if err != nil {
+			return nil
+		}
+		return []Operation{withOutput(op, "read", res.Messages, true)}
+	})
+}
+
+// PrefixResult is the outcome of CheckPrefixes.
+type PrefixResult struct {
+	Compatible bool
+	// A and B are two reads neither of which is a prefix of the other, they first differ at Index
+	A, B  Operation
+	Index int
+	// Duplicate is set instead if A returned a value twice
+	Duplicate bool
+}
+
+func (r PrefixResult) String() string {
+	switch {
+	case r.Compatible:
+		return "prefix compatible"
+	case r.Duplicate:
+		return fmt.Sprintf("read returned %v twice:\n  %s", r.A.Output.([]int)[r.Index], around(r.A, r.Index))
+	}
+	return fmt.Sprintf("reads differ at index %d:\n  %s\n  %s", r.Index, around(r.A, r.Index), around(r.B, r.Index))
+}
+
+// around describes a read by the values it returned next to index i, its full output is often too long.
+func around(read Operation, i int) string {
+	values := read.Output.([]int)
+	from, to := max(i-3, 0), min(i+4, len(values))
+	return fmt.Sprintf("p%d [%d, %d] read of %d values, %d..%d: %v", read.Process, read.Call, read.Return, len(values), from, to-1, values[from:to])
+}
+
+// CheckPrefixes checks that the reads of ReadHistory saw one total order:
+// of any two reads, one returned a prefix of the other, and no read returned
+// a value twice. Every read must then be a prefix of the longest one, which
+// makes the check linear in the size of the history.
+func CheckPrefixes(reads []Operation) PrefixResult {
+	reads = append([]Operation(nil), reads...)
+	sort.SliceStable(reads, func(i, j int) bool {
+		return len(reads[i].Output.([]int)) > len(reads[j].Output.([]int))
+	})
+	if len(reads) == 0 {
+		return PrefixResult{Compatible: true}
+	}
+
+	longest := reads[0].Output.([]int)
+	seen := make(map[int]bool, len(longest))
+	for i, v := range longest {
+		if seen[v] {
+			return PrefixResult{A: reads[0], Index: i, Duplicate: true}
+		}
+		seen[v] = true
+	}
+
+	for _, read := range reads[1:] {
+		for i, v := range read.Output.([]int) {
+			if v != longest[i] {
+				return PrefixResult{A: reads[0], B: read, Index: i}
+			}
+		}
+	}
+	return PrefixResult{Compatible: true}
+}
@@ -0,0 +1,56 @@
+package checker
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// read is a completed read of process p that returned values.
+func read(p int, values ...int) Operation {
+	if values == nil {
+		values = []int{}
+	}
+	return Operation{Process: p, Input: "read", Output: values, Call: int64(p), Return: int64(p) + 1}
+}
+
+func TestCheckPrefixes(t *testing.T) {
+	t.Run("compatible", func(t *testing.T) {
+		result := CheckPrefixes([]Operation{read(0, 3, 1), read(1, 3, 1, 4, 2), read(2), read(3, 3)})
+		if !result.Compatible {
+			t.Fatal(result)
+		}
+	})
+
+	t.Run("diverging", func(t *testing.T) {
+		result := CheckPrefixes([]Operation{read(0, 3, 1, 4), read(1, 3, 4)})
+		if result.Compatible || result.Duplicate {
+			t.Fatal(result)
+		}
+		if result.Index != 1 {
+			t.Fatalf("reads differ at index %d, want 1", result.Index)
+		}
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		result := CheckPrefixes([]Operation{read(0, 3, 1, 3)})
+		if result.Compatible || !result.Duplicate {
+			t.Fatal(result)
+		}
+	})
+}
+
+func TestReadHistory(t *testing.T) {
+	ops := []Operation{
+		{Input: json.RawMessage(`{"type":"read","msg_id":1}`), Output: json.RawMessage(`{"type":"read_ok","in_reply_to":1,"messages":[2,1]}`), Return: 1},
+		{Input: json.RawMessage(`{"type":"broadcast","msg_id":2,"message":1}`), Output: json.RawMessage(`{"type":"broadcast_ok","in_reply_to":2}`), Return: 2},
+		{Input: json.RawMessage(`{"type":"read","msg_id":3}`), Return: Unknown},
+	}
+
+	reads := ReadHistory(ops)
+	if len(reads) != 1 {
+		t.Fatalf("got %d reads, want 1", len(reads))
+	}
+	if got := reads[0].Output.([]int); len(got) != 2 || got[0] != 2 || got[1] != 1 {
+		t.Fatalf("read returned %v, want the values in reply order", got)
+	}
+}
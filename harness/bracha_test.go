@@ -0,0 +1,80 @@
+package harness_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/HdkTvd/advent-of-distributed-systems/c3"
+	"github.com/HdkTvd/advent-of-distributed-systems/checker"
+	"github.com/HdkTvd/advent-of-distributed-systems/harness"
+)
+
+// brachaReads broadcasts values through every node, the liars included, and returns the deliveries the
+// correct nodes read once every correct node delivered want values.
+func brachaReads(t *testing.T, ctx context.Context, net *harness.Network, correct []string, values, want int) []checker.Operation {
+	t.Helper()
+
+	ids := net.NodeIDs()
+	client := net.Client("c1")
+	for i := 0; i < values; i++ {
+		if _, err := client.RPC(ctx, ids[i%len(ids)], map[string]any{"type": "broadcast", "message": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reader := net.Client("c2")
+	eventually(t, 20*time.Second, func() error {
+		for _, id := range correct {
+			var body struct {
+				Messages []int `json:"messages"`
+			}
+			if err := reader.Call(ctx, id, map[string]any{"type": "read"}, &body); err != nil {
+				return err
+			}
+			if len(body.Messages) < want {
+				return fmt.Errorf("%s delivered %d of %d values", id, len(body.Messages), want)
+			}
+		}
+		return nil
+	})
+
+	for _, id := range correct {
+		if _, err := reader.RPC(ctx, id, map[string]any{"type": "read"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return checker.DeliveryHistory(net.History())
+}
+
+func TestBrachaBroadcastAgreement(t *testing.T) {
+	net, ctx := start(t, 7, c3.SetupBrachaBroadcast)
+	ids := net.NodeIDs()
+	net.Nemesis().SetByzantine(ids[6], harness.Byzantine{Equivocate: ids[:3], Drop: 0.2})
+
+	// Values broadcast through correct nodes reach every correct node, the liar's may or may not
+	const values = 28
+	reads := brachaReads(t, ctx, net, ids[:6], values, values*6/7)
+	if result := checker.CheckAgreement(reads); !result.Agree {
+		t.Fatal(result)
+	}
+	if stats := net.Nemesis().Stats(); stats.Tampered == 0 {
+		t.Fatal("the liar never lied")
+	}
+}
+
+func TestBrachaAgreementCheckerCatchesConflicts(t *testing.T) {
+	net, ctx := start(t, 4, c3.SetupBrachaBroadcast)
+	ids := net.NodeIDs()
+
+	// Two liars out of four are more than Bracha tolerates, together they make n0 deliver what n1 does not
+	for _, id := range ids[2:] {
+		net.Nemesis().SetByzantine(id, harness.Byzantine{Equivocate: ids[:1]})
+	}
+
+	reads := brachaReads(t, ctx, net, ids[:2], 8, 4)
+	if result := checker.CheckAgreement(reads); result.Agree {
+		t.Fatal("the checker missed the deliveries the liars forced apart")
+	}
+}
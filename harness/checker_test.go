@@ -0,0 +1,75 @@
+package harness_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HdkTvd/advent-of-distributed-systems/checker"
+	"github.com/HdkTvd/advent-of-distributed-systems/harness"
+	"github.com/HdkTvd/advent-of-distributed-systems/kv"
+	"github.com/HdkTvd/advent-of-distributed-systems/schema"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// setupBrokenCounter is a counter whose add ignores a failed CAS, so concurrent adds get lost.
+func setupBrokenCounter(n *maelstrom.Node) {
+	lin := kv.NewLinKV(n)
+
+	n.Handle("add", func(msg maelstrom.Message) error {
+		ctx := context.Background()
+		body, err := schema.Decode[schema.Add](msg)
+		if err != nil {
+			return err
+		}
+
+		current, err := kv.Read[int](ctx, lin, "counter")
+		if err != nil && !errors.Is(err, kv.ErrNotFound) {
+			return err
+		}
+		// Leave room for another add to read the same value
+		time.Sleep(2 * time.Millisecond)
+		_ = kv.CAS(ctx, lin, "counter", current, current+body.Delta, true)
+
+		return n.Reply(msg, schema.NewAddOK())
+	})
+
+	n.Handle("read", func(msg maelstrom.Message) error {
+		value, err := kv.Read[int](context.Background(), lin, "counter")
+		if err != nil && !errors.Is(err, kv.ErrNotFound) {
+			return err
+		}
+		return n.Reply(msg, schema.NewCounterReadOK(value))
+	})
+}
+
+func TestCheckerRejectsBrokenCASLoop(t *testing.T) {
+	net, ctx := start(t, 3, setupBrokenCounter, harness.NewLinKV())
+	ids := net.NodeIDs()
+
+	var wg sync.WaitGroup
+	for c := 1; c <= 4; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			client := net.Client(fmt.Sprintf("c%d", c))
+			for i := 0; i < 5; i++ {
+				if _, err := client.RPC(ctx, ids[(c+i)%len(ids)], map[string]any{"type": "add", "delta": 1}); err != nil {
+					t.Error(err)
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	if _, err := net.Client("c9").RPC(ctx, ids[0], map[string]any{"type": "read"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := net.CheckLinearizable(ctx, checker.CounterModel(), checker.CounterHistory); err == nil {
+		t.Fatal("the history of a counter that loses adds passed the checker")
+	}
+}
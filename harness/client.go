@@ -0,0 +1,90 @@
+package harness
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/HdkTvd/advent-of-distributed-systems/checker"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Client injects operations into the network the way a maelstrom client does.
+type Client struct {
+	net *Network
+	id  string
+}
+
+// Client returns a client that sends from id, e.g. "c1".
+func (net *Network) Client(id string) *Client {
+	return &Client{net: net, id: id}
+}
+
+// ID returns the client id used as the src of its messages.
+func (c *Client) ID() string {
+	return c.id
+}
+
+// RPC sends body to dest and waits for the reply or for ctx to end. An error
+// reply is returned as a *maelstrom.RPCError along with the message.
+func (c *Client) RPC(ctx context.Context, dest string, body any) (maelstrom.Message, error) {
+	if c.net.Node(dest) == nil {
+		return maelstrom.Message{}, fmt.Errorf("unknown destination %q", dest)
+	}
+
+	c.net.mu.Lock()
+	c.net.nextMsgID++
+	msgID := c.net.nextMsgID
+	key := pendingKey{client: c.id, msgID: msgID}
+	replyCh := make(chan maelstrom.Message, 1)
+	c.net.pending[key] = replyCh
+	c.net.mu.Unlock()
+
+	defer func() {
+		c.net.mu.Lock()
+		delete(c.net.pending, key)
+		c.net.mu.Unlock()
+	}()
+
+	// Inject the message id the same way maelstrom.Node.RPC does
+	b := make(map[string]any)
+	if buf, err := json.Marshal(body); err != nil {
+		return maelstrom.Message{}, err
+	} else if err := json.Unmarshal(buf, &b); err != nil {
+		return maelstrom.Message{}, err
+	}
+	b["msg_id"] = msgID
+
+	raw, err := json.Marshal(b)
+	if err != nil {
+		return maelstrom.Message{}, err
+	}
+
+	op := checker.Operation{Input: json.RawMessage(raw), Call: c.net.now()}
+	c.net.route(maelstrom.Message{Src: c.id, Dest: dest, Body: raw})
+
+	select {
+	case <-ctx.Done():
+		op.Return = checker.Unknown
+		c.net.record(c.id, op)
+		return maelstrom.Message{}, ctx.Err()
+	case msg := <-replyCh:
+		op.Return = c.net.now()
+		op.Output = msg.Body
+		c.net.record(c.id, op)
+
+		if err := msg.RPCError(); err != nil {
+			return msg, err
+		}
+		return msg, nil
+	}
+}
+
+// Call is RPC followed by decoding the reply body into v.
+func (c *Client) Call(ctx context.Context, dest string, body, v any) error {
+	msg, err := c.RPC(ctx, dest, body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(msg.Body, v)
+}
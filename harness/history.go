@@ -0,0 +1,55 @@
+package harness
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/HdkTvd/advent-of-distributed-systems/checker"
+)
+
+// History returns every client operation sent through the network so far,
+// except the init and topology setup. Inputs and outputs are the raw request
+// and reply bodies, to be converted with e.g. checker.LogHistory.
+func (net *Network) History() []checker.Operation {
+	net.historyMu.Lock()
+	defer net.historyMu.Unlock()
+	return append([]checker.Operation(nil), net.history...)
+}
+
+// CheckLinearizable converts the recorded history with convert and checks it
+// against model. It returns an error describing the counter-example if the
+// history is not linearizable.
+func (net *Network) CheckLinearizable(ctx context.Context, model checker.Model, convert func([]checker.Operation) []checker.Operation) error {
+	result, err := checker.Check(ctx, model, convert(net.History()))
+	if err != nil {
+		return err
+	}
+	if !result.Linearizable {
+		return errors.New(result.String())
+	}
+	return nil
+}
+
+// now is the time since the network was created, used for history timestamps.
+func (net *Network) now() int64 {
+	return int64(time.Since(net.start))
+}
+
+// record appends op to the history under the process of client.
+func (net *Network) record(client string, op checker.Operation) {
+	if client == setupClient {
+		return
+	}
+
+	net.historyMu.Lock()
+	defer net.historyMu.Unlock()
+
+	process, ok := net.processes[client]
+	if !ok {
+		process = len(net.processes)
+		net.processes[client] = process
+	}
+	op.Process = process
+	net.history = append(net.history, op)
+}
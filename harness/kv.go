@@ -0,0 +1,214 @@
+package harness
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sync"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// maxVersions is how many past values a store keeps per key to serve stale reads.
+const maxVersions = 16
+
+// KVStore is a local stand-in for maelstrom's key/value services. It answers
+// read, write and cas on a node named after the service, with the same error
+// codes as maelstrom (KeyDoesNotExist and PreconditionFailed).
+//
+// Writes and cas always apply to the latest value. Reads on lin-kv return the
+// latest value. Reads on seq-kv may return an older version, but never one
+// older than what the same client already observed. Reads on lww-kv may return
+// any retained version.
+type KVStore struct {
+	name string
+
+	mu       sync.Mutex
+	versions map[string][]any
+	// seen tracks the newest version of a key each client has observed, to
+	// keep seq-kv reads monotonic per client
+	seen map[string]map[string]int
+
+	rng        *rand.Rand
+	staleReads float64
+	monotonic  bool
+}
+
+// NewLinKV returns a linearizable store served as lin-kv.
+func NewLinKV() *KVStore {
+	return newKVStore(maelstrom.LinKV, 0, 0, true)
+}
+
+// NewSeqKV returns a sequentially consistent store served as seq-kv. A read is
+// served from a stale version with probability staleReads, drawn from seed.
+func NewSeqKV(staleReads float64, seed int64) *KVStore {
+	return newKVStore(maelstrom.SeqKV, staleReads, seed, true)
+}
+
+// NewLWWKV returns a last-write-wins store served as lww-kv. A read is served
+// from a stale version with probability staleReads, drawn from seed.
+func NewLWWKV(staleReads float64, seed int64) *KVStore {
+	return newKVStore(maelstrom.LWWKV, staleReads, seed, false)
+}
+
+func newKVStore(name string, staleReads float64, seed int64, monotonic bool) *KVStore {
+	return &KVStore{
+		name:       name,
+		versions:   make(map[string][]any),
+		seen:       make(map[string]map[string]int),
+		rng:        rand.New(rand.NewSource(seed)),
+		staleReads: staleReads,
+		monotonic:  monotonic,
+	}
+}
+
+// Name returns the node id the store is served under.
+func (s *KVStore) Name() string {
+	return s.name
+}
+
+// Value returns the latest value of key, decoded from JSON.
+func (s *KVStore) Value(key string) (any, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vs := s.versions[key]
+	if len(vs) == 0 {
+		return nil, false
+	}
+	return vs[len(vs)-1], true
+}
+
+// Setup registers the read, write and cas handlers on n.
+func (s *KVStore) Setup(n *maelstrom.Node) {
+	n.Handle("read", func(msg maelstrom.Message) error {
+		var body struct {
+			Key any `json:"key"`
+		}
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+		}
+
+		value, err := s.read(msg.Src, keyString(body.Key))
+		if err != nil {
+			return err
+		}
+
+		return n.Reply(msg, map[string]any{"type": "read_ok", "value": value})
+	})
+
+	n.Handle("write", func(msg maelstrom.Message) error {
+		var body struct {
+			Key   any `json:"key"`
+			Value any `json:"value"`
+		}
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+		}
+
+		s.mu.Lock()
+		s.append(msg.Src, keyString(body.Key), body.Value)
+		s.mu.Unlock()
+
+		return n.Reply(msg, map[string]any{"type": "write_ok"})
+	})
+
+	n.Handle("cas", func(msg maelstrom.Message) error {
+		var body struct {
+			Key               any  `json:"key"`
+			From              any  `json:"from"`
+			To                any  `json:"to"`
+			CreateIfNotExists bool `json:"create_if_not_exists"`
+		}
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+		}
+
+		if err := s.cas(msg.Src, keyString(body.Key), body.From, body.To, body.CreateIfNotExists); err != nil {
+			return err
+		}
+
+		return n.Reply(msg, map[string]any{"type": "cas_ok"})
+	})
+}
+
+func (s *KVStore) read(client, key string) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vs := s.versions[key]
+	latest := len(vs) - 1
+	if latest < 0 {
+		return nil, maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, fmt.Sprintf("key %q does not exist", key))
+	}
+
+	version := latest
+	if s.staleReads > 0 && s.rng.Float64() < s.staleReads {
+		oldest := 0
+		if s.monotonic {
+			oldest = s.seen[client][key]
+		}
+		version = oldest + s.rng.Intn(latest-oldest+1)
+	}
+	s.observe(client, key, version)
+
+	return vs[version], nil
+}
+
+func (s *KVStore) cas(client, key string, from, to any, createIfNotExists bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vs := s.versions[key]
+	if len(vs) == 0 {
+		if !createIfNotExists {
+			return maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, fmt.Sprintf("key %q does not exist", key))
+		}
+		s.append(client, key, to)
+		return nil
+	}
+
+	if current := vs[len(vs)-1]; !reflect.DeepEqual(current, from) {
+		return maelstrom.NewRPCError(maelstrom.PreconditionFailed, fmt.Sprintf("current value %v is not %v", current, from))
+	}
+	s.append(client, key, to)
+
+	return nil
+}
+
+// append stores value as the newest version of key. Callers hold s.mu.
+func (s *KVStore) append(client, key string, value any) {
+	vs := append(s.versions[key], value)
+	if len(vs) > maxVersions {
+		drop := len(vs) - maxVersions
+		vs = vs[drop:]
+		for _, seen := range s.seen {
+			seen[key] = max(seen[key]-drop, 0)
+		}
+	}
+	s.versions[key] = vs
+	s.observe(client, key, len(vs)-1)
+}
+
+func (s *KVStore) observe(client, key string, version int) {
+	seen, ok := s.seen[client]
+	if !ok {
+		seen = make(map[string]int)
+		s.seen[client] = seen
+	}
+	seen[key] = max(seen[key], version)
+}
+
+// keyString normalizes a key, as maelstrom workloads use both string and integer keys.
+func keyString(key any) string {
+	if k, ok := key.(string); ok {
+		return k
+	}
+	return fmt.Sprint(key)
+}
+
+// AddKV attaches store to the network under its service name.
+func (net *Network) AddKV(store *KVStore) *maelstrom.Node {
+	return net.AddService(store.Name(), store.Setup)
+}
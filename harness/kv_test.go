@@ -0,0 +1,113 @@
+package harness
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// write stores value as the newest version of key on behalf of client.
+func write(s *KVStore, client, key string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.append(client, key, value)
+}
+
+func readInt(t *testing.T, s *KVStore, client, key string) int {
+	t.Helper()
+
+	v, err := s.read(client, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v.(int)
+}
+
+func rpcCode(err error) int {
+	var rpcErr *maelstrom.RPCError
+	if !errors.As(err, &rpcErr) {
+		return -1
+	}
+	return rpcErr.Code
+}
+
+func TestSeqKVStaleReadsAreMonotonicPerClient(t *testing.T) {
+	s := NewSeqKV(1, 1)
+	for i := 0; i < 10; i++ {
+		write(s, "w", "k", i)
+	}
+
+	last := -1
+	for i := 0; i < 100; i++ {
+		v := readInt(t, s, "a", "k")
+		if v < last {
+			t.Fatalf("read %d after %d", v, last)
+		}
+		last = v
+	}
+
+	// What a has seen does not hold back other clients
+	older := false
+	for c := 0; c < 20; c++ {
+		older = older || readInt(t, s, fmt.Sprintf("b%d", c), "k") < last
+	}
+	if !older {
+		t.Fatal("no other client read a version older than a's")
+	}
+}
+
+func TestLinKVReadsLatest(t *testing.T) {
+	s := NewLinKV()
+	for i := 0; i < 10; i++ {
+		write(s, "w", "k", i)
+		if v := readInt(t, s, "a", "k"); v != i {
+			t.Fatalf("read %d, want %d", v, i)
+		}
+	}
+}
+
+func TestKVTrimsVersions(t *testing.T) {
+	s := NewSeqKV(1, 1)
+	for i := 0; i < maxVersions; i++ {
+		write(s, "a", "k", i)
+	}
+	for i := maxVersions; i < maxVersions+10; i++ {
+		write(s, "w", "k", i)
+	}
+
+	if got := len(s.versions["k"]); got != maxVersions {
+		t.Fatalf("kept %d versions, want %d", got, maxVersions)
+	}
+	// a's newest write moved down by the 10 versions trimmed below it
+	if got := s.seen["a"]["k"]; got != maxVersions-11 {
+		t.Fatalf("a has seen version %d, want %d", got, maxVersions-11)
+	}
+	for i := 0; i < 100; i++ {
+		if v := readInt(t, s, "a", "k"); v < maxVersions-1 {
+			t.Fatalf("a read %d, older than its own write of %d", v, maxVersions-1)
+		}
+	}
+}
+
+func TestKVCAS(t *testing.T) {
+	s := NewLinKV()
+
+	if err := s.cas("a", "k", 0, 1, false); rpcCode(err) != maelstrom.KeyDoesNotExist {
+		t.Fatalf("cas on a missing key returned %v", err)
+	}
+	if err := s.cas("a", "k", 0, 1, true); err != nil {
+		t.Fatalf("cas creating the key returned %v", err)
+	}
+	if err := s.cas("a", "k", 0, 2, true); rpcCode(err) != maelstrom.PreconditionFailed {
+		t.Fatalf("cas from a stale value returned %v", err)
+	}
+	if err := s.cas("a", "k", 1, 2, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, ok := s.Value("k"); !ok || v != 2 {
+		t.Fatalf("value is %v, want 2", v)
+	}
+}
@@ -0,0 +1,341 @@
+package harness
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Latency draws the delay added to a single message.
+type Latency interface {
+	Delay(rng *rand.Rand) time.Duration
+}
+
+// ConstantLatency delays every message by the same amount.
+type ConstantLatency time.Duration
+
+func (l ConstantLatency) Delay(*rand.Rand) time.Duration {
+	return time.Duration(l)
+}
+
+// UniformLatency delays messages uniformly between Min and Max.
+type UniformLatency struct {
+	Min, Max time.Duration
+}
+
+func (l UniformLatency) Delay(rng *rand.Rand) time.Duration {
+	if l.Max <= l.Min {
+		return l.Min
+	}
+	return l.Min + time.Duration(rng.Int63n(int64(l.Max-l.Min)))
+}
+
+// ExponentialLatency delays messages exponentially with the given mean, like
+// maelstrom's default latency distribution.
+type ExponentialLatency struct {
+	Mean time.Duration
+}
+
+func (l ExponentialLatency) Delay(rng *rand.Rand) time.Duration {
+	return time.Duration(rng.ExpFloat64() * float64(l.Mean))
+}
+
+// Faults are the probabilistic faults applied to every message between two
+// cluster nodes.
+type Faults struct {
+	// Drop is the probability a message is lost.
+	Drop float64
+	// Duplicate is the probability a message is delivered twice.
+	Duplicate float64
+	// Latency delays each delivery, nil delivers immediately.
+	Latency Latency
+	// Reorder is the probability a message is held back by up to
+	// ReorderWindow, letting later messages overtake it.
+	Reorder       float64
+	ReorderWindow time.Duration
+}
+
+// Byzantine makes a cluster node misbehave on the wire, whatever workload it
+// runs.
+type Byzantine struct {
+	// Drop is the probability a message the node sends is lost.
+	Drop float64
+	// Equivocate lists the nodes the node lies to: the integer Field of every
+	// message to them is replaced by -v-1, a value no client broadcast.
+	Equivocate []string
+	// Field is the body field to alter, "message" if empty.
+	Field string
+}
+
+// NemesisStats counts what the nemesis did to messages between cluster nodes.
+type NemesisStats struct {
+	Delivered  int
+	Dropped    int
+	Duplicated int
+	Delayed    int
+	Cut        int
+	Tampered   int
+}
+
+// Nemesis injects faults into the links between cluster nodes. Messages from
+// and to clients and services are never affected, matching maelstrom's
+// partition nemesis.
+type Nemesis struct {
+	mu     sync.Mutex
+	rng    *rand.Rand
+	faults Faults
+	cut    map[link]bool
+	stats  NemesisStats
+	// byzantine holds the misbehaviour of every Byzantine node
+	byzantine map[string]Byzantine
+}
+
+// link is a directed edge between two nodes.
+type link struct {
+	src, dest string
+}
+
+func newNemesis(seed int64) *Nemesis {
+	return &Nemesis{
+		rng:       rand.New(rand.NewSource(seed)),
+		cut:       make(map[link]bool),
+		byzantine: make(map[string]Byzantine),
+	}
+}
+
+// Nemesis returns the fault injector of the network.
+func (net *Network) Nemesis() *Nemesis {
+	return net.nemesis
+}
+
+// Seed resets the random source used for faults and random partitions.
+func (nem *Nemesis) Seed(seed int64) {
+	nem.mu.Lock()
+	nem.rng = rand.New(rand.NewSource(seed))
+	nem.mu.Unlock()
+}
+
+// SetFaults replaces the probabilistic faults.
+func (nem *Nemesis) SetFaults(f Faults) {
+	nem.mu.Lock()
+	nem.faults = f
+	nem.mu.Unlock()
+}
+
+// Partition cuts every link between nodes of different groups. Nodes not
+// listed in any group keep all their links.
+func (nem *Nemesis) Partition(groups ...[]string) {
+	nem.mu.Lock()
+	defer nem.mu.Unlock()
+
+	for i, a := range groups {
+		for j, b := range groups {
+			if i == j {
+				continue
+			}
+			for _, src := range a {
+				for _, dest := range b {
+					nem.cut[link{src, dest}] = true
+				}
+			}
+		}
+	}
+}
+
+// PartitionRandomHalves splits nodeIDs into two random halves and cuts the
+// links between them, and returns the halves.
+func (nem *Nemesis) PartitionRandomHalves(nodeIDs []string) ([]string, []string) {
+	nem.mu.Lock()
+	ids := append([]string(nil), nodeIDs...)
+	nem.rng.Shuffle(len(ids), func(i, j int) { ids[i], ids[j] = ids[j], ids[i] })
+	nem.mu.Unlock()
+
+	a, b := ids[:len(ids)/2], ids[len(ids)/2:]
+	nem.Partition(a, b)
+
+	return a, b
+}
+
+// Cut drops every message sent from src to dest.
+func (nem *Nemesis) Cut(src, dest string) {
+	nem.mu.Lock()
+	nem.cut[link{src, dest}] = true
+	nem.mu.Unlock()
+}
+
+// Heal restores every cut link.
+func (nem *Nemesis) Heal() {
+	nem.mu.Lock()
+	nem.cut = make(map[link]bool)
+	nem.mu.Unlock()
+}
+
+// SetByzantine makes node misbehave as b, the zero Byzantine makes it correct
+// again.
+func (nem *Nemesis) SetByzantine(node string, b Byzantine) {
+	nem.mu.Lock()
+	defer nem.mu.Unlock()
+
+	if b.Drop == 0 && len(b.Equivocate) == 0 {
+		delete(nem.byzantine, node)
+		return
+	}
+	if b.Field == "" {
+		b.Field = "message"
+	}
+	nem.byzantine[node] = b
+}
+
+// Reset heals all links, clears the faults and makes every node correct.
+func (nem *Nemesis) Reset() {
+	nem.mu.Lock()
+	nem.cut = make(map[link]bool)
+	nem.faults = Faults{}
+	nem.byzantine = make(map[string]Byzantine)
+	nem.mu.Unlock()
+}
+
+// Stats returns the counters collected so far.
+func (nem *Nemesis) Stats() NemesisStats {
+	nem.mu.Lock()
+	defer nem.mu.Unlock()
+	return nem.stats
+}
+
+// deliver applies the current faults to msg and hands every surviving copy to
+// push, possibly later.
+func (nem *Nemesis) deliver(msg maelstrom.Message, push func(maelstrom.Message)) {
+	msg, ok := nem.tamper(msg)
+	if !ok {
+		return
+	}
+	for _, d := range nem.delays(msg) {
+		if d <= 0 {
+			push(msg)
+			continue
+		}
+		time.AfterFunc(d, func() { push(msg) })
+	}
+}
+
+// tamper applies the misbehaviour of the sender of msg, if it is Byzantine. It
+// returns the message to send, and false if it is dropped.
+func (nem *Nemesis) tamper(msg maelstrom.Message) (maelstrom.Message, bool) {
+	nem.mu.Lock()
+	defer nem.mu.Unlock()
+
+	b, ok := nem.byzantine[msg.Src]
+	if !ok {
+		return msg, true
+	}
+	if b.Drop > 0 && nem.rng.Float64() < b.Drop {
+		nem.stats.Dropped++
+		return msg, false
+	}
+
+	lie := false
+	for _, victim := range b.Equivocate {
+		lie = lie || victim == msg.Dest
+	}
+	if !lie {
+		return msg, true
+	}
+
+	var fields map[string]json.RawMessage
+	var v int
+	if err := json.Unmarshal(msg.Body, &fields); err != nil || json.Unmarshal(fields[b.Field], &v) != nil {
+		return msg, true
+	}
+	fields[b.Field], _ = json.Marshal(-v - 1)
+	body, err := json.Marshal(fields)
+	if err != nil {
+		return msg, true
+	}
+
+	nem.stats.Tampered++
+	msg.Body = body
+	return msg, true
+}
+
+// delays decides what happens to msg under the current faults and returns
+// the delay of every copy to deliver, none if it is lost.
+func (nem *Nemesis) delays(msg maelstrom.Message) []time.Duration {
+	nem.mu.Lock()
+	defer nem.mu.Unlock()
+
+	if nem.cut[link{msg.Src, msg.Dest}] {
+		nem.stats.Cut++
+		return nil
+	}
+
+	f := nem.faults
+	if f.Drop > 0 && nem.rng.Float64() < f.Drop {
+		nem.stats.Dropped++
+		return nil
+	}
+
+	copies := 1
+	if f.Duplicate > 0 && nem.rng.Float64() < f.Duplicate {
+		copies++
+		nem.stats.Duplicated++
+	}
+
+	delays := make([]time.Duration, copies)
+	for i := range delays {
+		if f.Latency != nil {
+			delays[i] = f.Latency.Delay(nem.rng)
+		}
+		if f.Reorder > 0 && f.ReorderWindow > 0 && nem.rng.Float64() < f.Reorder {
+			delays[i] += time.Duration(nem.rng.Int63n(int64(f.ReorderWindow)))
+		}
+		if delays[i] > 0 {
+			nem.stats.Delayed++
+		}
+	}
+	nem.stats.Delivered += copies
+
+	return delays
+}
+
+// Step is one entry of a nemesis script, applied At after the script starts.
+type Step struct {
+	At    time.Duration
+	Apply func(nem *Nemesis)
+}
+
+// PartitionAt returns a step that partitions the given groups.
+func PartitionAt(at time.Duration, groups ...[]string) Step {
+	return Step{At: at, Apply: func(nem *Nemesis) { nem.Partition(groups...) }}
+}
+
+// HealAt returns a step that heals all partitions.
+func HealAt(at time.Duration) Step {
+	return Step{At: at, Apply: (*Nemesis).Heal}
+}
+
+// FaultsAt returns a step that replaces the probabilistic faults.
+func FaultsAt(at time.Duration, f Faults) Step {
+	return Step{At: at, Apply: func(nem *Nemesis) { nem.SetFaults(f) }}
+}
+
+// Run applies the steps at their offsets, in order, and returns once the last
+// step is applied or ctx ends. Steps must be sorted by At.
+func (nem *Nemesis) Run(ctx context.Context, steps []Step) error {
+	start := time.Now()
+	for _, step := range steps {
+		timer := time.NewTimer(time.Until(start.Add(step.At)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		step.Apply(nem)
+	}
+
+	return nil
+}
@@ -0,0 +1,389 @@
+// Package harness runs maelstrom nodes in a single process, wired through pipes,
+// so workloads can be driven from go test without the maelstrom binary.
+package harness
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/HdkTvd/advent-of-distributed-systems/checker"
+	"github.com/HdkTvd/advent-of-distributed-systems/workload"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// setupClient is the client id used for the init and topology messages, the
+// same one maelstrom uses for them.
+const setupClient = "c0"
+
+// closeTimeout bounds how long Close waits for a node's Run loop to return.
+// Handlers blocked on a SyncRPC with a background context never finish.
+const closeTimeout = 5 * time.Second
+
+// Network runs maelstrom nodes in one process. Every node reads its messages
+// from a pipe and writes to a router that delivers each line to the
+// destination's pipe, to a waiting client, or drops it if nobody owns the id.
+type Network struct {
+	mu        sync.Mutex
+	members   map[string]*member
+	nodeIDs   []string
+	pending   map[pendingKey]chan maelstrom.Message
+	nextMsgID int
+	started   bool
+	nemesis   *Nemesis
+
+	start     time.Time
+	historyMu sync.Mutex
+	history   []checker.Operation
+	processes map[string]int
+
+	errMu sync.Mutex
+	errs  []error
+}
+
+// member is a node attached to the network together with its inbox.
+type member struct {
+	id    string
+	node  *maelstrom.Node
+	inbox *mailbox
+	stdin *io.PipeWriter
+	pipe  *io.PipeReader
+	done  chan struct{}
+}
+
+type pendingKey struct {
+	client string
+	msgID  int
+}
+
+// New returns a network of nodeCount cluster nodes named n0..n(N-1), each
+// with the handlers registered by setup.
+func New(nodeCount int, setup workload.Func) *Network {
+	net := &Network{
+		members: make(map[string]*member),
+		pending: make(map[pendingKey]chan maelstrom.Message),
+		nemesis: newNemesis(1),
+
+		start:     time.Now(),
+		processes: make(map[string]int),
+	}
+
+	for i := 0; i < nodeCount; i++ {
+		id := "n" + strconv.Itoa(i)
+		net.nodeIDs = append(net.nodeIDs, id)
+		net.attach(id, setup)
+	}
+
+	return net
+}
+
+// AddService attaches a node that is reachable by id but is not part of the
+// cluster, like maelstrom's built-in services. It never receives an init
+// message, so its id is set directly.
+func (net *Network) AddService(id string, setup workload.Func) *maelstrom.Node {
+	m := net.attach(id, setup)
+	m.node.Init(id, nil)
+	if net.isStarted() {
+		net.run(m)
+	}
+	return m.node
+}
+
+// NodeIDs returns the ids of the cluster nodes.
+func (net *Network) NodeIDs() []string {
+	return append([]string(nil), net.nodeIDs...)
+}
+
+// Node returns the maelstrom node attached under id.
+func (net *Network) Node(id string) *maelstrom.Node {
+	net.mu.Lock()
+	defer net.mu.Unlock()
+
+	if m, ok := net.members[id]; ok {
+		return m.node
+	}
+	return nil
+}
+
+// Start runs every node and initializes the cluster nodes, waiting for all
+// init_ok replies.
+func (net *Network) Start(ctx context.Context) error {
+	net.mu.Lock()
+	if net.started {
+		net.mu.Unlock()
+		return errors.New("network already started")
+	}
+	net.started = true
+	members := make([]*member, 0, len(net.members))
+	for _, m := range net.members {
+		members = append(members, m)
+	}
+	net.mu.Unlock()
+
+	for _, m := range members {
+		net.run(m)
+	}
+
+	// maelstrom sends every init at once, so nodes may hear from peers that
+	// are not initialized yet
+	client := net.Client(setupClient)
+	errs := make([]error, len(net.nodeIDs))
+	var wg sync.WaitGroup
+	for i, id := range net.nodeIDs {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			if _, err := client.RPC(ctx, id, maelstrom.InitMessageBody{
+				MessageBody: maelstrom.MessageBody{Type: "init"},
+				NodeID:      id,
+				NodeIDs:     net.NodeIDs(),
+			}); err != nil {
+				errs[i] = fmt.Errorf("init %s: %w", id, err)
+			}
+		}(i, id)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// Topology sends the topology message to every cluster node.
+func (net *Network) Topology(ctx context.Context, topology map[string][]string) error {
+	client := net.Client(setupClient)
+	for _, id := range net.nodeIDs {
+		if _, err := client.RPC(ctx, id, map[string]any{
+			"type":     "topology",
+			"topology": topology,
+		}); err != nil {
+			return fmt.Errorf("topology %s: %w", id, err)
+		}
+	}
+
+	return nil
+}
+
+// Close stops delivering messages, closes every node's input and waits for
+// the Run loops to return. It reports the errors the nodes exited with.
+func (net *Network) Close() error {
+	net.mu.Lock()
+	members := make([]*member, 0, len(net.members))
+	for _, m := range net.members {
+		members = append(members, m)
+	}
+	net.mu.Unlock()
+
+	for _, m := range members {
+		m.inbox.close()
+	}
+
+	timeout := time.After(closeTimeout)
+	for _, m := range members {
+		select {
+		case <-m.done:
+		case <-timeout:
+			net.recordErr(fmt.Errorf("node %s did not stop within %v", m.id, closeTimeout))
+		}
+	}
+
+	net.errMu.Lock()
+	defer net.errMu.Unlock()
+	return errors.Join(net.errs...)
+}
+
+func (net *Network) attach(id string, setup workload.Func) *member {
+	r, w := io.Pipe()
+
+	n := maelstrom.NewNode()
+	n.Stdin = r
+	n.Stdout = &lineWriter{deliver: net.route}
+	setup(n)
+
+	m := &member{
+		id:    id,
+		node:  n,
+		inbox: newMailbox(),
+		stdin: w,
+		pipe:  r,
+		done:  make(chan struct{}),
+	}
+
+	net.mu.Lock()
+	if _, ok := net.members[id]; ok {
+		net.mu.Unlock()
+		panic(fmt.Sprintf("duplicate node id %q", id))
+	}
+	net.members[id] = m
+	net.mu.Unlock()
+
+	return m
+}
+
+func (net *Network) isStarted() bool {
+	net.mu.Lock()
+	defer net.mu.Unlock()
+	return net.started
+}
+
+// run starts the node's Run loop and the pump feeding its stdin.
+func (net *Network) run(m *member) {
+	go func() {
+		m.inbox.pump(m.stdin)
+		m.stdin.Close()
+	}()
+
+	go func() {
+		defer close(m.done)
+		err := m.node.Run()
+		// Unblock the pump if the node stopped reading early
+		m.pipe.Close()
+		if err != nil {
+			net.recordErr(fmt.Errorf("node %s: %w", m.id, err))
+		}
+	}()
+}
+
+// route delivers a message written by a node or a client. It never blocks, as
+// nodes write while holding their own lock. Messages between cluster nodes go
+// through the nemesis.
+func (net *Network) route(msg maelstrom.Message) {
+	net.mu.Lock()
+	m, ok := net.members[msg.Dest]
+	net.mu.Unlock()
+
+	if ok {
+		if net.isClusterNode(msg.Src) && net.isClusterNode(msg.Dest) {
+			net.nemesis.deliver(msg, m.inbox.push)
+		} else {
+			m.inbox.push(msg)
+		}
+		return
+	}
+
+	var body maelstrom.MessageBody
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		log.Printf("harness: dropping malformed message %s: %v", msg.Body, err)
+		return
+	}
+
+	net.mu.Lock()
+	key := pendingKey{client: msg.Dest, msgID: body.InReplyTo}
+	ch, ok := net.pending[key]
+	delete(net.pending, key)
+	net.mu.Unlock()
+
+	if !ok {
+		log.Printf("harness: dropping message to %s with no receiver: %s", msg.Dest, msg.Body)
+		return
+	}
+	ch <- msg
+}
+
+func (net *Network) isClusterNode(id string) bool {
+	for _, nodeID := range net.nodeIDs {
+		if nodeID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (net *Network) recordErr(err error) {
+	net.errMu.Lock()
+	net.errs = append(net.errs, err)
+	net.errMu.Unlock()
+}
+
+// lineWriter splits a node's output into messages. maelstrom writes a message
+// and its trailing newline in two calls.
+type lineWriter struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	deliver func(maelstrom.Message)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf.Write(p)
+	for {
+		line, err := w.buf.ReadBytes('\n')
+		if err != nil {
+			// Keep the partial line for the next write
+			w.buf.Write(line)
+			return len(p), nil
+		}
+
+		var msg maelstrom.Message
+		if err := json.Unmarshal(line, &msg); err != nil {
+			return len(p), fmt.Errorf("unmarshal message: %w", err)
+		}
+		w.deliver(msg)
+	}
+}
+
+// mailbox is an unbounded queue of messages waiting to be written to a node.
+type mailbox struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []maelstrom.Message
+	closed bool
+}
+
+func newMailbox() *mailbox {
+	mb := &mailbox{}
+	mb.cond = sync.NewCond(&mb.mu)
+	return mb
+}
+
+func (mb *mailbox) push(msg maelstrom.Message) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if mb.closed {
+		return
+	}
+	mb.queue = append(mb.queue, msg)
+	mb.cond.Signal()
+}
+
+func (mb *mailbox) close() {
+	mb.mu.Lock()
+	mb.closed = true
+	mb.queue = nil
+	mb.cond.Broadcast()
+	mb.mu.Unlock()
+}
+
+// pump writes queued messages to w until the mailbox is closed.
+func (mb *mailbox) pump(w io.Writer) {
+	for {
+		mb.mu.Lock()
+		for len(mb.queue) == 0 && !mb.closed {
+			mb.cond.Wait()
+		}
+		if mb.closed {
+			mb.mu.Unlock()
+			return
+		}
+		msg := mb.queue[0]
+		mb.queue = mb.queue[1:]
+		mb.mu.Unlock()
+
+		line, err := json.Marshal(msg)
+		if err != nil {
+			log.Printf("harness: marshal message to %s: %v", msg.Dest, err)
+			continue
+		}
+		if _, err := w.Write(append(line, '\n')); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,384 @@
+package harness_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HdkTvd/advent-of-distributed-systems/c3"
+	"github.com/HdkTvd/advent-of-distributed-systems/c4"
+	"github.com/HdkTvd/advent-of-distributed-systems/c5"
+	"github.com/HdkTvd/advent-of-distributed-systems/checker"
+	"github.com/HdkTvd/advent-of-distributed-systems/harness"
+	"github.com/HdkTvd/advent-of-distributed-systems/workload"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// start runs a network of n nodes with the given services and closes it when the test ends.
+func start(t *testing.T, n int, setup workload.Func, services ...*harness.KVStore) (*harness.Network, context.Context) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	t.Cleanup(cancel)
+
+	net := harness.New(n, setup)
+	for _, s := range services {
+		net.AddKV(s)
+	}
+	if err := net.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := net.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	return net, ctx
+}
+
+// eventually polls cond until it holds or timeout passes.
+func eventually(t *testing.T, timeout time.Duration, cond func() error) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		err := cond()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func line(ids []string) map[string][]string {
+	topology := make(map[string][]string, len(ids))
+	for i, id := range ids {
+		topology[id] = []string{}
+		if i > 0 {
+			topology[id] = append(topology[id], ids[i-1])
+		}
+		if i+1 < len(ids) {
+			topology[id] = append(topology[id], ids[i+1])
+		}
+	}
+	return topology
+}
+
+func TestFaultTolerantBroadcast(t *testing.T) {
+	net, ctx := start(t, 5, c3.SetupFaultTolerantBroadcast)
+	ids := net.NodeIDs()
+	if err := net.Topology(ctx, line(ids)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Values broadcast on either side of a partition reach every node after it heals
+	net.Nemesis().Partition(ids[:2], ids[2:])
+	client := net.Client("c1")
+	const values = 40
+	for i := 0; i < values; i++ {
+		if _, err := client.RPC(ctx, ids[i%len(ids)], map[string]any{"type": "broadcast", "message": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	net.Nemesis().Heal()
+
+	eventually(t, 20*time.Second, func() error {
+		for _, id := range ids {
+			var body struct {
+				Messages []int `json:"messages"`
+			}
+			if err := client.Call(ctx, id, map[string]any{"type": "read"}, &body); err != nil {
+				return err
+			}
+			if len(body.Messages) != values {
+				return fmt.Errorf("%s read %d of %d values", id, len(body.Messages), values)
+			}
+		}
+		return nil
+	})
+}
+
+func TestGrowOnlyCounter(t *testing.T) {
+	net, ctx := start(t, 3, c4.SetupGrowOnlyCounter, harness.NewSeqKV(0.2, 1))
+	ids := net.NodeIDs()
+
+	var wg sync.WaitGroup
+	want := 0
+	for c := 0; c < 3; c++ {
+		want += 10 * (c + 1) * 10
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			client := net.Client(fmt.Sprintf("c%d", c+1))
+			for i := 0; i < 10; i++ {
+				if _, err := client.RPC(ctx, ids[(c+i)%len(ids)], map[string]any{"type": "add", "delta": 10 * (c + 1)}); err != nil {
+					t.Error(err)
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	// Reads served from stale values catch up with every add
+	client := net.Client("c9")
+	eventually(t, 10*time.Second, func() error {
+		for _, id := range ids {
+			var body struct {
+				Value int `json:"value"`
+			}
+			if err := client.Call(ctx, id, map[string]any{"type": "read"}, &body); err != nil {
+				return err
+			}
+			if body.Value != want {
+				return fmt.Errorf("%s read %d, want %d", id, body.Value, want)
+			}
+		}
+		return nil
+	})
+}
+
+func TestKafkaStyleLogMultiNode(t *testing.T) {
+	net, ctx := start(t, 3, c5.SetupKafkaStyleLogMultiNode, harness.NewLinKV(), harness.NewSeqKV(0, 1))
+	ids := net.NodeIDs()
+
+	var wg sync.WaitGroup
+	for c := 0; c < 3; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			client := net.Client(fmt.Sprintf("c%d", c+1))
+			for i := 0; i < 10; i++ {
+				key := fmt.Sprintf("k%d", i%2)
+				if _, err := client.RPC(ctx, ids[(c+i)%len(ids)], map[string]any{"type": "send", "key": key, "msg": c*100 + i}); err != nil {
+					t.Error(err)
+				}
+				if _, err := client.RPC(ctx, ids[(c+i+1)%len(ids)], map[string]any{"type": "poll", "offsets": map[string]int{key: 0}}); err != nil {
+					t.Error(err)
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	if err := net.CheckLinearizable(ctx, checker.LogModel(), checker.LogHistory); err != nil {
+		t.Fatal(err)
+	}
+
+	var body struct {
+		Msgs map[string][][2]int `json:"msgs"`
+	}
+	if err := net.Client("c9").Call(ctx, ids[0], map[string]any{"type": "poll", "offsets": map[string]int{"k0": 0, "k1": 0}}, &body); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(body.Msgs["k0"]) + len(body.Msgs["k1"]); got != 30 {
+		t.Fatalf("polled %d messages, want 30", got)
+	}
+}
+
+func TestPlumtreeBroadcast(t *testing.T) {
+	net, ctx := start(t, 6, c3.SetupPlumtreeBroadcast)
+	ids := net.NodeIDs()
+	topology := make(map[string][]string, len(ids))
+	for i, id := range ids {
+		topology[id] = []string{ids[(i+1)%len(ids)], ids[(i+len(ids)-1)%len(ids)], ids[(i+3)%len(ids)]}
+	}
+	if err := net.Topology(ctx, topology); err != nil {
+		t.Fatal(err)
+	}
+
+	// Several clients broadcast at once, so every node is the origin of a tree
+	const values = 30
+	var wg sync.WaitGroup
+	for c := 1; c <= 3; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			client := net.Client(fmt.Sprintf("c%d", c))
+			for i := c - 1; i < values; i += 3 {
+				if _, err := client.RPC(ctx, ids[i%len(ids)], map[string]any{"type": "broadcast", "message": i}); err != nil {
+					t.Error(err)
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	client := net.Client("c9")
+	eventually(t, 10*time.Second, func() error {
+		for _, id := range ids {
+			var body struct {
+				Messages []int `json:"messages"`
+			}
+			if err := client.Call(ctx, id, map[string]any{"type": "read"}, &body); err != nil {
+				return err
+			}
+			if len(body.Messages) != values {
+				return fmt.Errorf("%s read %d of %d values", id, len(body.Messages), values)
+			}
+		}
+		return nil
+	})
+}
+
+func TestHyParViewJoinsWithoutFirstNode(t *testing.T) {
+	net := harness.New(5, c3.SetupPlumtreeBroadcastHyParView)
+	ids := net.NodeIDs()
+
+	// Joins through the first node time out, so the others have to join through each other
+	net.Nemesis().Partition(ids[:1], ids[1:])
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := net.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := net.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	client := net.Client("c1")
+	// read counts the values id has that match keep
+	read := func(id string, keep func(int) bool) (int, error) {
+		var body struct {
+			Messages []int `json:"messages"`
+		}
+		if err := client.Call(ctx, id, map[string]any{"type": "read"}, &body); err != nil {
+			return 0, err
+		}
+		count := 0
+		for _, m := range body.Messages {
+			if keep(m) {
+				count++
+			}
+		}
+		return count, nil
+	}
+
+	// Gossip is sent once, so wait until the overlay has formed and carries a fresh probe to everyone
+	probe := 0
+	eventually(t, 20*time.Second, func() error {
+		probe--
+		if _, err := client.RPC(ctx, ids[1], map[string]any{"type": "broadcast", "message": probe}); err != nil {
+			return err
+		}
+		time.Sleep(500 * time.Millisecond)
+		for _, id := range ids[1:] {
+			if n, err := read(id, func(m int) bool { return m == probe }); err != nil {
+				return err
+			} else if n == 0 {
+				return fmt.Errorf("%s did not get probe %d", id, probe)
+			}
+		}
+		return nil
+	})
+
+	const values = 20
+	for i := 0; i < values; i++ {
+		if _, err := client.RPC(ctx, ids[1+i%4], map[string]any{"type": "broadcast", "message": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	eventually(t, 10*time.Second, func() error {
+		for _, id := range ids[1:] {
+			n, err := read(id, func(m int) bool { return m >= 0 })
+			if err != nil {
+				return err
+			}
+			if n != values {
+				return fmt.Errorf("%s read %d of %d values", id, n, values)
+			}
+		}
+		return nil
+	})
+}
+
+func TestPlumtreeBroadcastAfterPartition(t *testing.T) {
+	net, ctx := start(t, 6, c3.SetupPlumtreeBroadcast)
+	ids := net.NodeIDs()
+	topology := make(map[string][]string, len(ids))
+	for i, id := range ids {
+		topology[id] = []string{ids[(i+1)%len(ids)], ids[(i+len(ids)-1)%len(ids)], ids[(i+3)%len(ids)]}
+	}
+	if err := net.Topology(ctx, topology); err != nil {
+		t.Fatal(err)
+	}
+
+	// Gossip that never crossed the partition is repaired once it heals
+	net.Nemesis().Partition(ids[:2], ids[2:])
+	client := net.Client("c1")
+	const values = 30
+	for i := 0; i < values; i++ {
+		if _, err := client.RPC(ctx, ids[i%len(ids)], map[string]any{"type": "broadcast", "message": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	net.Nemesis().Heal()
+
+	eventually(t, 20*time.Second, func() error {
+		for _, id := range ids {
+			var body struct {
+				Messages []int `json:"messages"`
+			}
+			if err := client.Call(ctx, id, map[string]any{"type": "read"}, &body); err != nil {
+				return err
+			}
+			if len(body.Messages) != values {
+				return fmt.Errorf("%s read %d of %d values", id, len(body.Messages), values)
+			}
+		}
+		return nil
+	})
+}
+
+func TestCausalBroadcastClock(t *testing.T) {
+	net, ctx := start(t, 3, c3.SetupCausalBroadcast)
+	ids := net.NodeIDs()
+	if err := net.Topology(ctx, line(ids)); err != nil {
+		t.Fatal(err)
+	}
+	client := net.Client("c1")
+
+	// A clock counting values nobody broadcast is refused instead of holding back the node's values
+	_, err := client.RPC(ctx, ids[0], map[string]any{"type": "broadcast", "message": 1, "clock": map[string]int{ids[1]: 1000}})
+	var rpcErr *maelstrom.RPCError
+	if !errors.As(err, &rpcErr) || rpcErr.Code != maelstrom.TemporarilyUnavailable {
+		t.Fatalf("broadcast with a made up clock returned %v", err)
+	}
+
+	if _, err := client.RPC(ctx, ids[2], map[string]any{"type": "broadcast", "message": 2}); err != nil {
+		t.Fatal(err)
+	}
+	var read struct {
+		Messages []int          `json:"messages"`
+		Clock    map[string]int `json:"clock"`
+	}
+	if err := client.Call(ctx, ids[2], map[string]any{"type": "read"}, &read); err != nil {
+		t.Fatal(err)
+	}
+
+	// A reply through the other end of the line is accepted once the value it replies to got there
+	eventually(t, 5*time.Second, func() error {
+		_, err := client.RPC(ctx, ids[0], map[string]any{"type": "broadcast", "message": 3, "clock": read.Clock})
+		return err
+	})
+	eventually(t, 5*time.Second, func() error {
+		for _, id := range ids {
+			if err := client.Call(ctx, id, map[string]any{"type": "read"}, &read); err != nil {
+				return err
+			}
+			if fmt.Sprint(read.Messages) != "[2 3]" {
+				return fmt.Errorf("%s read %v, want [2 3]", id, read.Messages)
+			}
+		}
+		return nil
+	})
+}
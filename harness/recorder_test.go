@@ -0,0 +1,115 @@
+package harness_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/HdkTvd/advent-of-distributed-systems/checker"
+	"github.com/HdkTvd/advent-of-distributed-systems/history"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// setupOutcomes replies to each request type with a different kind of completion.
+func setupOutcomes(n *maelstrom.Node) {
+	n.Handle("write", func(msg maelstrom.Message) error {
+		return n.Reply(msg, map[string]any{"type": "write_ok"})
+	})
+	n.Handle("cas", func(msg maelstrom.Message) error {
+		return maelstrom.NewRPCError(maelstrom.PreconditionFailed, "stale")
+	})
+	n.Handle("read", func(msg maelstrom.Message) error {
+		return maelstrom.NewRPCError(maelstrom.Timeout, "slow")
+	})
+	n.Handle("crash", func(msg maelstrom.Message) error {
+		return errors.New("boom")
+	})
+}
+
+func TestRecorder(t *testing.T) {
+	rec := history.NewRecorder()
+	net, ctx := start(t, 1, rec.Wrap(setupOutcomes))
+
+	requests := []struct {
+		client string
+		body   map[string]any
+	}{
+		{"c1", map[string]any{"type": "write", "value": 1}},
+		{"c1", map[string]any{"type": "cas", "from": 1, "to": 2}},
+		{"c2", map[string]any{"type": "read"}},
+		{"c1", map[string]any{"type": "crash"}},
+	}
+	for _, r := range requests {
+		_, _ = net.Client(r.client).RPC(ctx, "n0", r.body)
+	}
+
+	events := rec.Events()
+	want := []struct {
+		typ, f  string
+		value   string
+		process int
+	}{
+		{history.Invoke, "write", `{"value" 1}`, 1},
+		{history.OK, "write", "nil", 1},
+		{history.Invoke, "cas", `{"from" 1, "to" 2}`, 1},
+		{history.Fail, "cas", `{"code" 22, "text" "stale"}`, 1},
+		{history.Invoke, "read", "nil", 2},
+		{history.Info, "read", `{"text" "slow"}`, 2},
+		{history.Invoke, "crash", "nil", 1},
+		{history.Info, "crash", `{"code" 13, "text" "boom"}`, 1},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("recorded %d events, want %d: %+v", len(events), len(want), events)
+	}
+
+	var wantJSONL, wantEDN strings.Builder
+	for i, w := range want {
+		e := events[i]
+		if e.Index != i || e.Type != w.typ || e.F != w.f || e.Process != w.process || e.Node != "n0" {
+			t.Errorf("event %d = %+v, want %s %s by process %d on n0", i, e, w.typ, w.f, w.process)
+		}
+		if i > 0 && e.Time < events[i-1].Time {
+			t.Errorf("event %d at %d is before event %d at %d", i, e.Time, i-1, events[i-1].Time)
+		}
+
+		jsonValue := strings.NewReplacer(`" `, `":`, `, "`, `,"`, "nil", "null").Replace(w.value)
+		fmt.Fprintf(&wantJSONL, `{"index":%d,"type":%q,"f":%q,"value":%s,"process":%d,"time":%d,"node":"n0"}`+"\n",
+			i, w.typ, w.f, jsonValue, w.process, e.Time)
+		fmt.Fprintf(&wantEDN, `{:index %d, :type :%s, :f :%s, :value %s, :process %d, :time %d, :node "n0"}`+"\n",
+			i, w.typ, w.f, w.value, w.process, e.Time)
+	}
+
+	var jsonl, edn bytes.Buffer
+	if err := rec.WriteJSONL(&jsonl); err != nil {
+		t.Fatal(err)
+	}
+	if got := jsonl.String(); got != wantJSONL.String() {
+		t.Errorf("JSONL:\n%s\nwant:\n%s", got, wantJSONL.String())
+	}
+	if err := rec.WriteEDN(&edn); err != nil {
+		t.Fatal(err)
+	}
+	if got := edn.String(); got != wantEDN.String() {
+		t.Errorf("EDN:\n%s\nwant:\n%s", got, wantEDN.String())
+	}
+
+	ops := rec.Operations()
+	if len(ops) != len(requests) {
+		t.Fatalf("got %d operations, want %d", len(ops), len(requests))
+	}
+	for i, op := range ops {
+		if op.Process != want[2*i].process || op.Call != events[2*i].Time {
+			t.Errorf("operation %d = %+v, want process %d called at %d", i, op, want[2*i].process, events[2*i].Time)
+		}
+		// Only ok and fail completions have a known return
+		known := want[2*i+1].typ != history.Info
+		if known && (op.Return != events[2*i+1].Time || op.Output == nil) {
+			t.Errorf("operation %d = %+v, want it to return at %d with a reply", i, op, events[2*i+1].Time)
+		}
+		if !known && (op.Return != checker.Unknown || op.Output != nil) {
+			t.Errorf("operation %d = %+v, want an unknown return", i, op)
+		}
+	}
+}
@@ -0,0 +1,442 @@
+package harness
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"runtime"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/HdkTvd/advent-of-distributed-systems/checker"
+	"github.com/HdkTvd/advent-of-distributed-systems/sim"
+	"github.com/HdkTvd/advent-of-distributed-systems/workload"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// stallTimeout bounds how long a single simulation step may run in real time.
+// A step that takes longer is blocked on something the scheduler does not
+// control, like a SyncRPC or a channel.
+const stallTimeout = 10 * time.Second
+
+// epoch is the virtual time a simulation starts at.
+var epoch = time.Unix(0, 0).UTC()
+
+// Simulation runs a cluster on a virtual clock, one step at a time: a message
+// delivery, or a goroutine started with the node's sim.Clock running until it
+// sleeps or returns. Every random choice comes from the seed, so two
+// simulations with the same seed, workload and calls produce the same Trace.
+//
+// Handlers must not block on other nodes (SyncRPC) and background work must
+// use the node's sim.Env instead of the go statement, time and math/rand.
+// A Simulation is not safe for concurrent use.
+type Simulation struct {
+	seed    int64
+	now     time.Duration
+	seq     uint64
+	queue   eventQueue
+	nodes   map[string]*maelstrom.Node
+	nodeIDs []string
+	nemesis *Nemesis
+
+	// current is the task that runs now, nil while a handler runs
+	current *task
+	parked  map[*task]bool
+	yield   chan struct{}
+
+	nextMsgID int
+	pending   map[pendingKey]*simCall
+	history   []checker.Operation
+	processes map[string]int
+	trace     []string
+	errs      []error
+}
+
+// task is a goroutine started with the simulation clock.
+type task struct {
+	// resume is sent true to continue after a Sleep and false to exit
+	resume chan bool
+}
+
+type simCall struct {
+	op      checker.Operation
+	client  string
+	onReply func(maelstrom.Message)
+}
+
+// NewSimulation returns a simulation of nodeCount cluster nodes named
+// n0..n(N-1). Each node gets a sim.Env with the simulation's clock and a
+// random source derived from seed.
+func NewSimulation(seed int64, nodeCount int, setup workload.Func) *Simulation {
+	seeds := rand.New(rand.NewSource(seed))
+
+	s := &Simulation{
+		seed:      seed,
+		nodes:     make(map[string]*maelstrom.Node),
+		nemesis:   newNemesis(seeds.Int63()),
+		parked:    make(map[*task]bool),
+		yield:     make(chan struct{}),
+		pending:   make(map[pendingKey]*simCall),
+		processes: make(map[string]int),
+	}
+
+	for i := 0; i < nodeCount; i++ {
+		id := "n" + strconv.Itoa(i)
+		s.nodeIDs = append(s.nodeIDs, id)
+
+		n := maelstrom.NewNode()
+		n.Stdout = &lineWriter{deliver: s.route}
+		sim.Attach(n, sim.Env{Clock: s, Rand: sim.NewRand(seeds.Int63())})
+		setup(n)
+		s.nodes[id] = n
+	}
+
+	return s
+}
+
+// Seed returns the seed the simulation was created with.
+func (s *Simulation) Seed() int64 {
+	return s.seed
+}
+
+// NodeIDs returns the ids of the cluster nodes.
+func (s *Simulation) NodeIDs() []string {
+	return append([]string(nil), s.nodeIDs...)
+}
+
+// Node returns the cluster node with the given id.
+func (s *Simulation) Node(id string) *maelstrom.Node {
+	return s.nodes[id]
+}
+
+// Nemesis returns the fault injector of the simulated network. Its random
+// choices are derived from the simulation's seed too.
+func (s *Simulation) Nemesis() *Nemesis {
+	return s.nemesis
+}
+
+// Start sends init to every cluster node. Like every call below it only
+// schedules messages, RunFor delivers them.
+func (s *Simulation) Start() {
+	for _, id := range s.nodeIDs {
+		s.Invoke(setupClient, id, maelstrom.InitMessageBody{
+			MessageBody: maelstrom.MessageBody{Type: "init"},
+			NodeID:      id,
+			NodeIDs:     s.NodeIDs(),
+		}, nil)
+	}
+}
+
+// Topology sends the topology message to every cluster node.
+func (s *Simulation) Topology(topology map[string][]string) {
+	for _, id := range s.nodeIDs {
+		s.Invoke(setupClient, id, map[string]any{
+			"type":     "topology",
+			"topology": topology,
+		}, nil)
+	}
+}
+
+// Invoke sends body from client to dest now and records the operation in the
+// history. onReply, if not nil, is called with the reply when it arrives.
+func (s *Simulation) Invoke(client, dest string, body any, onReply func(maelstrom.Message)) {
+	s.nextMsgID++
+	msgID := s.nextMsgID
+
+	b := make(map[string]any)
+	if buf, err := json.Marshal(body); err != nil {
+		panic(err)
+	} else if err := json.Unmarshal(buf, &b); err != nil {
+		panic(err)
+	}
+	b["msg_id"] = msgID
+
+	raw, err := json.Marshal(b)
+	if err != nil {
+		panic(err)
+	}
+
+	s.pending[pendingKey{client: client, msgID: msgID}] = &simCall{
+		op:      checker.Operation{Input: json.RawMessage(raw), Call: int64(s.now), Return: checker.Unknown},
+		client:  client,
+		onReply: onReply,
+	}
+	s.route(maelstrom.Message{Src: client, Dest: dest, Body: raw})
+}
+
+// Schedule calls f on the scheduler after d of virtual time, e.g. to invoke
+// operations or change faults mid-run.
+func (s *Simulation) Schedule(d time.Duration, f func()) {
+	s.schedule(d, f)
+}
+
+// Script schedules the steps of a nemesis script relative to now.
+func (s *Simulation) Script(steps []Step) {
+	for _, step := range steps {
+		step := step
+		s.schedule(step.At, func() { step.Apply(s.nemesis) })
+	}
+}
+
+// RunFor runs every step due within d of virtual time and advances the clock
+// by d.
+func (s *Simulation) RunFor(d time.Duration) {
+	deadline := s.now + d
+	for s.queue.Len() > 0 && s.queue[0].at <= deadline {
+		ev := heap.Pop(&s.queue).(*event)
+		s.now = ev.at
+		if !ev.cancelled {
+			ev.run()
+		}
+	}
+	s.now = deadline
+}
+
+// Elapsed returns the virtual time since the simulation started.
+func (s *Simulation) Elapsed() time.Duration {
+	return s.now
+}
+
+// Trace returns every message sent so far with its virtual send time. Two
+// runs with the same seed have identical traces.
+func (s *Simulation) Trace() []string {
+	return append([]string(nil), s.trace...)
+}
+
+// History returns the completed and pending client operations, except the
+// init and topology setup, with virtual timestamps.
+func (s *Simulation) History() []checker.Operation {
+	history := append([]checker.Operation(nil), s.history...)
+
+	keys := make([]pendingKey, 0, len(s.pending))
+	for key := range s.pending {
+		if key.client != setupClient {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].msgID < keys[j].msgID })
+	for _, key := range keys {
+		history = append(history, s.processOp(s.pending[key]))
+	}
+
+	return history
+}
+
+// Close stops every goroutine started with the simulation clock and reports
+// the errors the nodes exited with.
+func (s *Simulation) Close() error {
+	for t := range s.parked {
+		s.current = t
+		t.resume <- false
+		s.wait()
+	}
+	s.parked = make(map[*task]bool)
+	s.current = nil
+	s.queue = nil
+
+	for _, n := range s.nodes {
+		sim.Detach(n)
+	}
+
+	return errors.Join(s.errs...)
+}
+
+// Now implements sim.Clock.
+func (s *Simulation) Now() time.Time {
+	return epoch.Add(s.now)
+}
+
+// Go implements sim.Clock. f starts as its own step after the current one.
+func (s *Simulation) Go(f func()) {
+	s.schedule(0, func() { s.start(f) })
+}
+
+// AfterFunc implements sim.Clock.
+func (s *Simulation) AfterFunc(d time.Duration, f func()) func() bool {
+	ev := s.schedule(d, func() { s.start(f) })
+	return func() bool {
+		if ev.cancelled || ev.index < 0 {
+			return false
+		}
+		ev.cancelled = true
+		return true
+	}
+}
+
+// Sleep implements sim.Clock. It parks the calling task and lets the
+// scheduler run other steps until d has passed.
+func (s *Simulation) Sleep(d time.Duration) {
+	t := s.current
+	if t == nil {
+		panic("harness: Sleep outside a simulation task, start the goroutine with sim.Clock.Go")
+	}
+
+	s.parked[t] = true
+	s.schedule(d, func() {
+		delete(s.parked, t)
+		s.current = t
+		t.resume <- true
+		s.wait()
+		s.current = nil
+	})
+
+	s.yield <- struct{}{}
+	if !<-t.resume {
+		runtime.Goexit()
+	}
+}
+
+// start runs f as a new task until it sleeps or returns.
+func (s *Simulation) start(f func()) {
+	t := &task{resume: make(chan bool)}
+	s.current = t
+	go func() {
+		defer func() { s.yield <- struct{}{} }()
+		f()
+	}()
+	s.wait()
+	s.current = nil
+}
+
+// wait blocks until the running task sleeps or returns.
+func (s *Simulation) wait() {
+	timer := time.NewTimer(stallTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-s.yield:
+	case <-timer.C:
+		panic(fmt.Sprintf("harness: simulation step at %v did not yield within %v", s.now, stallTimeout))
+	}
+}
+
+// route sends a message written by a node or a client. Messages between
+// cluster nodes go through the nemesis.
+func (s *Simulation) route(msg maelstrom.Message) {
+	s.trace = append(s.trace, fmt.Sprintf("%d %s->%s %s", s.now, msg.Src, msg.Dest, msg.Body))
+
+	if _, ok := s.nodes[msg.Dest]; ok {
+		delays := []time.Duration{0}
+		if s.nodes[msg.Src] != nil {
+			tampered, ok := s.nemesis.tamper(msg)
+			if !ok {
+				return
+			}
+			msg, delays = tampered, s.nemesis.delays(tampered)
+		}
+		for _, d := range delays {
+			s.schedule(d, func() { s.deliver(msg) })
+		}
+		return
+	}
+
+	var body maelstrom.MessageBody
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		log.Printf("harness: dropping malformed message %s: %v", msg.Body, err)
+		return
+	}
+
+	key := pendingKey{client: msg.Dest, msgID: body.InReplyTo}
+	call, ok := s.pending[key]
+	if !ok {
+		log.Printf("harness: dropping message to %s with no receiver: %s", msg.Dest, msg.Body)
+		return
+	}
+	delete(s.pending, key)
+
+	call.op.Return = int64(s.now)
+	call.op.Output = msg.Body
+	if call.client != setupClient {
+		s.history = append(s.history, s.processOp(call))
+	}
+	if call.onReply != nil {
+		s.schedule(0, func() { call.onReply(msg) })
+	}
+}
+
+// deliver hands msg to its node and waits for the handler to return. Run
+// reads a single line, so it returns once that message is handled.
+func (s *Simulation) deliver(msg maelstrom.Message) {
+	line, err := json.Marshal(msg)
+	if err != nil {
+		s.errs = append(s.errs, fmt.Errorf("marshal message to %s: %w", msg.Dest, err))
+		return
+	}
+
+	n := s.nodes[msg.Dest]
+	n.Stdin = bytes.NewReader(append(line, '\n'))
+	go func() {
+		if err := n.Run(); err != nil {
+			s.errs = append(s.errs, fmt.Errorf("node %s: %w", msg.Dest, err))
+		}
+		s.yield <- struct{}{}
+	}()
+	s.wait()
+}
+
+// processOp returns the call's operation under the process of its client.
+func (s *Simulation) processOp(call *simCall) checker.Operation {
+	process, ok := s.processes[call.client]
+	if !ok {
+		process = len(s.processes)
+		s.processes[call.client] = process
+	}
+
+	op := call.op
+	op.Process = process
+	return op
+}
+
+func (s *Simulation) schedule(d time.Duration, run func()) *event {
+	s.seq++
+	ev := &event{at: s.now + d, seq: s.seq, run: run}
+	heap.Push(&s.queue, ev)
+	return ev
+}
+
+type event struct {
+	at        time.Duration
+	seq       uint64
+	run       func()
+	cancelled bool
+	// index is the position in the queue, -1 once popped
+	index int
+}
+
+// eventQueue orders events by time, then by the order they were scheduled in.
+type eventQueue []*event
+
+func (q eventQueue) Len() int { return len(q) }
+
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at != q[j].at {
+		return q[i].at < q[j].at
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q eventQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *eventQueue) Push(x any) {
+	ev := x.(*event)
+	ev.index = len(*q)
+	*q = append(*q, ev)
+}
+
+func (q *eventQueue) Pop() any {
+	old := *q
+	ev := old[len(old)-1]
+	old[len(old)-1] = nil
+	ev.index = -1
+	*q = old[:len(old)-1]
+	return ev
+}
@@ -0,0 +1,74 @@
+package harness_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/HdkTvd/advent-of-distributed-systems/c3"
+	"github.com/HdkTvd/advent-of-distributed-systems/harness"
+)
+
+// simulate runs the fault tolerant broadcast under drops, reordering and a partition and returns the
+// trace and the history.
+func simulate(seed int64) ([]string, []string) {
+	s := harness.NewSimulation(seed, 5, c3.SetupFaultTolerantBroadcast)
+	defer s.Close()
+
+	ids := s.NodeIDs()
+	s.Nemesis().SetFaults(harness.Faults{
+		Drop:          0.1,
+		Duplicate:     0.05,
+		Latency:       harness.UniformLatency{Min: time.Millisecond, Max: 20 * time.Millisecond},
+		Reorder:       0.2,
+		ReorderWindow: 50 * time.Millisecond,
+	})
+	s.Start()
+	s.Topology(line(ids))
+	for i := 0; i < 50; i++ {
+		i := i
+		s.Schedule(time.Duration(i)*10*time.Millisecond, func() {
+			s.Invoke("c1", ids[i%len(ids)], map[string]any{"type": "broadcast", "message": i}, nil)
+		})
+	}
+	s.Script([]harness.Step{
+		harness.PartitionAt(100*time.Millisecond, ids[:2], ids[2:]),
+		harness.HealAt(time.Second),
+	})
+	s.RunFor(3 * time.Second)
+	for _, id := range ids {
+		s.Invoke("c2", id, map[string]any{"type": "read"}, nil)
+	}
+	s.RunFor(time.Second)
+
+	var history []string
+	for _, op := range s.History() {
+		history = append(history, fmt.Sprint(op))
+	}
+	return s.Trace(), history
+}
+
+func TestSimulationReplaysSeed(t *testing.T) {
+	trace, history := simulate(42)
+	if len(trace) == 0 || len(history) == 0 {
+		t.Fatal("the simulation sent nothing")
+	}
+
+	again, againHistory := simulate(42)
+	if !reflect.DeepEqual(trace, again) {
+		for i := range trace {
+			if i >= len(again) || trace[i] != again[i] {
+				t.Fatalf("traces of seed 42 differ at message %d:\n  %s\n  %s", i, trace[i], again[min(i, len(again)-1)])
+			}
+		}
+		t.Fatalf("the replay sent %d messages, the first run %d", len(again), len(trace))
+	}
+	if !reflect.DeepEqual(history, againHistory) {
+		t.Fatal("histories of seed 42 differ")
+	}
+
+	if other, _ := simulate(43); reflect.DeepEqual(trace, other) {
+		t.Fatal("seeds 42 and 43 gave the same trace")
+	}
+}
@@ -0,0 +1,92 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WriteJSONL writes the history as one JSON object per line.
+func (r *Recorder) WriteJSONL(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for _, e := range r.Events() {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteEDN writes the history as one EDN map per line, the way Jepsen stores
+// history.edn. Map keys inside values stay strings.
+func (r *Recorder) WriteEDN(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, e := range r.Events() {
+		fmt.Fprintf(bw, "{:index %d, :type :%s, :f :%s, :value %s, :process %d, :time %d, :node %s}\n",
+			e.Index, e.Type, e.F, edn(e.Value), e.Process, e.Time, strconv.Quote(e.Node))
+	}
+
+	return bw.Flush()
+}
+
+// WriteFile writes the history to path, as EDN if it ends in .edn and as
+// JSONL otherwise.
+func (r *Recorder) WriteFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	write := r.WriteJSONL
+	if filepath.Ext(path) == ".edn" {
+		write = r.WriteEDN
+	}
+
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// edn formats a JSON-decoded value as EDN.
+func edn(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return strconv.Quote(v)
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = edn(item)
+		}
+		return "[" + strings.Join(items, " ") + "]"
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		items := make([]string, len(keys))
+		for i, k := range keys {
+			items[i] = strconv.Quote(k) + " " + edn(v[k])
+		}
+		return "{" + strings.Join(items, ", ") + "}"
+	default:
+		return strconv.Quote(fmt.Sprint(v))
+	}
+}
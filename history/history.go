@@ -0,0 +1,259 @@
+// Package history records the client operations a node sees in the layout of
+// a Jepsen history, and writes them out as JSONL or EDN.
+package history
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HdkTvd/advent-of-distributed-systems/checker"
+	"github.com/HdkTvd/advent-of-distributed-systems/workload"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Event types, as in Jepsen histories.
+const (
+	Invoke = "invoke"
+	OK     = "ok"
+	Fail   = "fail"
+	Info   = "info"
+)
+
+// Event is one entry of the history. An invoke is followed by an ok, fail or
+// info completion of the same process.
+type Event struct {
+	Index   int    `json:"index"`
+	Type    string `json:"type"`
+	F       string `json:"f"`
+	Value   any    `json:"value"`
+	Process int    `json:"process"`
+	// Time is in nanoseconds from a monotonic clock started with the recorder.
+	Time int64 `json:"time"`
+	// Node is the node that handled the operation.
+	Node string `json:"node"`
+
+	body json.RawMessage
+}
+
+// Recorder collects the history of every node it wraps.
+type Recorder struct {
+	mu      sync.Mutex
+	start   time.Time
+	events  []Event
+	pending map[pendingKey]int
+}
+
+type pendingKey struct {
+	node   string
+	client string
+	msgID  int
+}
+
+// NewRecorder returns an empty recorder whose clock starts now.
+func NewRecorder() *Recorder {
+	return &Recorder{
+		start:   time.Now(),
+		pending: make(map[pendingKey]int),
+	}
+}
+
+// Wrap returns setup with the node's input and output tapped, so every
+// request from a client is recorded as an invoke and every reply to it as a
+// completion. Handlers are left untouched.
+func (r *Recorder) Wrap(setup workload.Func) workload.Func {
+	return func(n *maelstrom.Node) {
+		n.Stdin = &tapReader{r: n.Stdin, tap: func(msg maelstrom.Message) { r.received(n, msg) }}
+		n.Stdout = &tapWriter{w: n.Stdout, tap: func(msg maelstrom.Message) { r.sent(n, msg) }}
+		setup(n)
+	}
+}
+
+// Events returns the history recorded so far.
+func (r *Recorder) Events() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Event(nil), r.events...)
+}
+
+// Operations pairs every invoke with its completion as a checker operation
+// with the raw request and reply bodies, for converters like
+// checker.LogHistory. Failed operations are kept with their error reply, and
+// operations without an ok or fail completion have an unknown return.
+func (r *Recorder) Operations() []checker.Operation {
+	events := r.Events()
+
+	open := make(map[int]int)
+	var ops []checker.Operation
+	for _, e := range events {
+		switch e.Type {
+		case Invoke:
+			open[e.Process] = len(ops)
+			ops = append(ops, checker.Operation{
+				Process: e.Process,
+				Input:   e.body,
+				Call:    e.Time,
+				Return:  checker.Unknown,
+			})
+		case OK, Fail:
+			if i, ok := open[e.Process]; ok {
+				ops[i].Output = e.body
+				ops[i].Return = e.Time
+				delete(open, e.Process)
+			}
+		case Info:
+			delete(open, e.Process)
+		}
+	}
+
+	return ops
+}
+
+func (r *Recorder) received(n *maelstrom.Node, msg maelstrom.Message) {
+	if !isClient(msg.Src) {
+		return
+	}
+
+	var body maelstrom.MessageBody
+	if err := json.Unmarshal(msg.Body, &body); err != nil || body.InReplyTo != 0 || body.Type == "init" {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.pending[pendingKey{n.ID(), msg.Src, body.MsgID}] = r.append(Event{
+		Type:    Invoke,
+		F:       body.Type,
+		Value:   value(msg.Body),
+		Process: process(msg.Src),
+		Node:    n.ID(),
+		body:    msg.Body,
+	})
+}
+
+func (r *Recorder) sent(n *maelstrom.Node, msg maelstrom.Message) {
+	if !isClient(msg.Dest) {
+		return
+	}
+
+	var body maelstrom.MessageBody
+	if err := json.Unmarshal(msg.Body, &body); err != nil || body.InReplyTo == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := pendingKey{n.ID(), msg.Dest, body.InReplyTo}
+	invoke, ok := r.pending[key]
+	if !ok {
+		return
+	}
+	delete(r.pending, key)
+
+	typ := OK
+	if body.Type == "error" {
+		typ = Fail
+		// Timeouts and crashes may or may not have taken effect
+		if body.Code == maelstrom.Timeout || body.Code == maelstrom.Crash {
+			typ = Info
+		}
+	}
+
+	r.append(Event{
+		Type:    typ,
+		F:       r.events[invoke].F,
+		Value:   value(msg.Body),
+		Process: process(msg.Dest),
+		Node:    n.ID(),
+		body:    msg.Body,
+	})
+}
+
+// append stamps e with its index and time and returns the index. Callers hold r.mu.
+func (r *Recorder) append(e Event) int {
+	e.Index = len(r.events)
+	e.Time = int64(time.Since(r.start))
+	r.events = append(r.events, e)
+	return e.Index
+}
+
+// value is the body without the fields maelstrom uses for routing.
+func value(raw json.RawMessage) any {
+	var body map[string]any
+	if err := json.Unmarshal(raw, &body); err != nil {
+		return nil
+	}
+	for _, k := range []string{"type", "msg_id", "in_reply_to"} {
+		delete(body, k)
+	}
+	if len(body) == 0 {
+		return nil
+	}
+	return body
+}
+
+func isClient(id string) bool {
+	return strings.HasPrefix(id, "c")
+}
+
+// process maps a client id like "c12" to its Jepsen process number.
+func process(client string) int {
+	p, err := strconv.Atoi(strings.TrimPrefix(client, "c"))
+	if err != nil {
+		return -1
+	}
+	return p
+}
+
+// tapReader hands every complete line read through it to tap.
+type tapReader struct {
+	r   io.Reader
+	buf bytes.Buffer
+	tap func(maelstrom.Message)
+}
+
+func (t *tapReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.buf.Write(p[:n])
+	tapLines(&t.buf, t.tap)
+	return n, err
+}
+
+// tapWriter hands every complete line written through it to tap.
+type tapWriter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	buf bytes.Buffer
+	tap func(maelstrom.Message)
+}
+
+func (t *tapWriter) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	t.buf.Write(p)
+	tapLines(&t.buf, t.tap)
+	t.mu.Unlock()
+
+	return t.w.Write(p)
+}
+
+func tapLines(buf *bytes.Buffer, tap func(maelstrom.Message)) {
+	for {
+		line, err := buf.ReadBytes('\n')
+		if err != nil {
+			// Keep the partial line for the next call
+			buf.Write(line)
+			return
+		}
+
+		var msg maelstrom.Message
+		if json.Unmarshal(line, &msg) == nil {
+			tap(msg)
+		}
+	}
+}
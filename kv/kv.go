@@ -0,0 +1,132 @@
+// Package kv wraps maelstrom's key/value clients with sentinel errors, typed
+// helpers and a bounded retry policy for read-modify-CAS loops.
+package kv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+
+	"github.com/HdkTvd/advent-of-distributed-systems/sim"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+var (
+	// ErrNotFound is returned when the key does not exist.
+	ErrNotFound = errors.New("kv: key does not exist")
+	// ErrPreconditionFailed is returned when a CAS finds a different value.
+	ErrPreconditionFailed = errors.New("kv: precondition failed")
+	// ErrTimeout is returned when the service or the context deadline times out.
+	ErrTimeout = errors.New("kv: timeout")
+	// ErrUnavailable is returned when the service is temporarily unavailable.
+	ErrUnavailable = errors.New("kv: temporarily unavailable")
+	// ErrIndeterminate is returned by Update when a CAS timed out, it may or
+	// may not have been applied.
+	ErrIndeterminate = errors.New("kv: outcome unknown")
+)
+
+// Client is a key/value client that reports failures as the sentinel errors
+// above, wrapping the original *maelstrom.RPCError.
+type Client struct {
+	kv    *maelstrom.KV
+	retry RetryPolicy
+	// rng jitters the retries
+	rng *rand.Rand
+}
+
+// New wraps kv with the default retry policy, jittering retries with rng.
+func New(kv *maelstrom.KV, rng *rand.Rand) *Client {
+	return &Client{kv: kv, retry: DefaultRetryPolicy, rng: rng}
+}
+
+// NewSeqKV returns a client of the seq-kv service for n.
+func NewSeqKV(n *maelstrom.Node) *Client {
+	return New(maelstrom.NewSeqKV(n), sim.For(n).Rand)
+}
+
+// NewLinKV returns a client of the lin-kv service for n.
+func NewLinKV(n *maelstrom.Node) *Client {
+	return New(maelstrom.NewLinKV(n), sim.For(n).Rand)
+}
+
+// WithRetry returns a copy of the client that retries with p.
+func (c *Client) WithRetry(p RetryPolicy) *Client {
+	return &Client{kv: c.kv, retry: p, rng: c.rng}
+}
+
+// Write overwrites the value of key.
+func (c *Client) Write(ctx context.Context, key string, value any) error {
+	return translate(c.kv.Write(ctx, key, value))
+}
+
+// Read reads key into a value of type T.
+func Read[T any](ctx context.Context, c *Client, key string) (T, error) {
+	var v T
+	err := c.kv.ReadInto(ctx, key, &v)
+	return v, translate(err)
+}
+
+// CAS replaces the value of key with to if it currently is from. The key is
+// created with to if it does not exist and create is set.
+func CAS[T any](ctx context.Context, c *Client, key string, from, to T, create bool) error {
+	return translate(c.kv.CompareAndSwap(ctx, key, from, to, create))
+}
+
+// Update applies fn to the current value of key and stores the result with a
+// CAS, starting from initial when the key does not exist. Reads and CAS
+// conflicts are retried with the client's retry policy. A CAS that times out
+// is not retried, as fn would be applied twice if it landed, and Update fails
+// with ErrIndeterminate instead. It returns the value that was stored.
+func Update[T any](ctx context.Context, c *Client, key string, initial T, fn func(current T) (T, error)) (T, error) {
+	var stored T
+	err := c.retry.Do(ctx, c.rng, func(ctx context.Context) error {
+		current, err := Read[T](ctx, c, key)
+		if errors.Is(err, ErrNotFound) {
+			current = initial
+		} else if err != nil {
+			return err
+		}
+
+		next, err := fn(current)
+		if err != nil {
+			return Permanent(err)
+		}
+
+		if err := CAS(ctx, c, key, current, next, true); err != nil {
+			if errors.Is(err, ErrTimeout) {
+				return Permanent(fmt.Errorf("%w: %w", ErrIndeterminate, err))
+			}
+			return err
+		}
+		stored = next
+
+		return nil
+	})
+
+	return stored, err
+}
+
+// translate maps maelstrom errors onto the sentinel errors, keeping the
+// original error in the chain.
+func translate(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+
+	switch maelstrom.ErrorCode(err) {
+	case maelstrom.KeyDoesNotExist:
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	case maelstrom.PreconditionFailed:
+		return fmt.Errorf("%w: %w", ErrPreconditionFailed, err)
+	case maelstrom.Timeout:
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	case maelstrom.TemporarilyUnavailable:
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+
+	return err
+}
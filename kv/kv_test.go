@@ -0,0 +1,151 @@
+package kv_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/HdkTvd/advent-of-distributed-systems/harness"
+	"github.com/HdkTvd/advent-of-distributed-systems/kv"
+	"github.com/HdkTvd/advent-of-distributed-systems/sim"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// start runs a single idle node next to the given services and returns it.
+func start(t *testing.T, services func(net *harness.Network)) (*maelstrom.Node, context.Context) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+
+	net := harness.New(1, func(*maelstrom.Node) {})
+	services(net)
+	if err := net.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := net.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	return net.Node(net.NodeIDs()[0]), ctx
+}
+
+func TestSentinelErrors(t *testing.T) {
+	n, ctx := start(t, func(net *harness.Network) {
+		net.AddKV(harness.NewLinKV())
+		net.AddService(maelstrom.SeqKV, func(s *maelstrom.Node) {
+			s.Handle("read", func(msg maelstrom.Message) error {
+				return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, "busy")
+			})
+		})
+	})
+	lin := kv.NewLinKV(n)
+
+	_, err := kv.Read[int](ctx, lin, "k")
+	if !errors.Is(err, kv.ErrNotFound) || maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
+		t.Fatalf("read of a missing key returned %v", err)
+	}
+
+	if err := lin.Write(ctx, "k", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.CAS(ctx, lin, "k", 2, 3, false); !errors.Is(err, kv.ErrPreconditionFailed) {
+		t.Fatalf("cas from a stale value returned %v", err)
+	}
+
+	if _, err := kv.Read[int](ctx, kv.NewSeqKV(n), "k"); !errors.Is(err, kv.ErrUnavailable) || !kv.Retryable(err) {
+		t.Fatalf("read of an unavailable service returned %v", err)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	n, ctx := start(t, func(net *harness.Network) {
+		net.AddKV(harness.NewLinKV())
+	})
+	lin := kv.NewLinKV(n)
+
+	for i := 1; i <= 3; i++ {
+		v, err := kv.Update(ctx, lin, "counter", 0, func(current int) (int, error) { return current + 1, nil })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != i {
+			t.Fatalf("stored %d, want %d", v, i)
+		}
+	}
+
+	refused := errors.New("refused")
+	if _, err := kv.Update(ctx, lin, "counter", 0, func(int) (int, error) { return 0, refused }); !errors.Is(err, refused) {
+		t.Fatalf("update returned %v, want the error of fn", err)
+	}
+}
+
+func TestUpdateDoesNotRetryATimedOutCAS(t *testing.T) {
+	var cas atomic.Int32
+	n, ctx := start(t, func(net *harness.Network) {
+		net.AddService(maelstrom.LinKV, func(s *maelstrom.Node) {
+			s.Handle("read", func(msg maelstrom.Message) error {
+				return s.Reply(msg, map[string]any{"type": "read_ok", "value": 1})
+			})
+			// The cas may or may not apply, the reply never comes
+			s.Handle("cas", func(msg maelstrom.Message) error {
+				cas.Add(1)
+				return nil
+			})
+		})
+	})
+	lin := kv.NewLinKV(n).WithRetry(kv.RetryPolicy{MaxAttempts: 5, AttemptTimeout: 50 * time.Millisecond})
+
+	applied := 0
+	_, err := kv.Update(ctx, lin, "counter", 0, func(current int) (int, error) {
+		applied++
+		return current + 1, nil
+	})
+	if !errors.Is(err, kv.ErrIndeterminate) || !errors.Is(err, kv.ErrTimeout) {
+		t.Fatalf("update returned %v, want ErrIndeterminate", err)
+	}
+	if applied != 1 || cas.Load() != 1 {
+		t.Fatalf("fn ran %d times and %d cas were sent, want 1 each", applied, cas.Load())
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	ctx := context.Background()
+	rng := sim.NewRand(1)
+	p := kv.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}
+
+	attempts := 0
+	err := p.Do(ctx, rng, func(context.Context) error {
+		attempts++
+		return kv.ErrPreconditionFailed
+	})
+	if attempts != 3 || !errors.Is(err, kv.ErrPreconditionFailed) {
+		t.Fatalf("%d attempts returned %v, want 3 and the last error", attempts, err)
+	}
+
+	refused := errors.New("refused")
+	for _, fail := range []error{refused, kv.Permanent(refused)} {
+		attempts = 0
+		err := p.Do(ctx, rng, func(context.Context) error {
+			attempts++
+			return fail
+		})
+		if attempts != 1 || err != refused {
+			t.Fatalf("%d attempts returned %v, want 1 and %v", attempts, err, refused)
+		}
+	}
+
+	// Without a limit on attempts only the context ends the retries
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	err = kv.RetryPolicy{BaseDelay: time.Millisecond}.Do(ctx, rng, func(context.Context) error {
+		return kv.ErrUnavailable
+	})
+	if !errors.Is(err, kv.ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unlimited retries returned %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy bounds how often and how long an operation is retried.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, 0 means unlimited until
+	// the context ends.
+	MaxAttempts int
+	// BaseDelay is the wait after the first failure, doubled after each
+	// further failure up to MaxDelay. Every wait is jittered.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// AttemptTimeout bounds a single attempt, 0 leaves it to the context.
+	AttemptTimeout time.Duration
+}
+
+// DefaultRetryPolicy retries contended CAS loops for about a second.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    20,
+	BaseDelay:      5 * time.Millisecond,
+	MaxDelay:       100 * time.Millisecond,
+	AttemptTimeout: time.Second,
+}
+
+// permanentError stops the retry loop.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// Retryable reports whether err is a transient key/value failure.
+func Retryable(err error) bool {
+	var perm *permanentError
+	if errors.As(err, &perm) {
+		return false
+	}
+
+	return errors.Is(err, ErrPreconditionFailed) ||
+		errors.Is(err, ErrTimeout) ||
+		errors.Is(err, ErrUnavailable)
+}
+
+// Do runs fn until it succeeds, fails with a non retryable error, runs out of
+// attempts or ctx ends, drawing the jitter of the waits from rng. The last
+// error is returned.
+func (p RetryPolicy) Do(ctx context.Context, rng *rand.Rand, fn func(ctx context.Context) error) error {
+	delay := p.BaseDelay
+	for attempt := 1; ; attempt++ {
+		err := p.attempt(ctx, fn)
+		if err == nil {
+			return nil
+		}
+
+		var perm *permanentError
+		if errors.As(err, &perm) {
+			return perm.err
+		}
+		if !Retryable(err) {
+			return err
+		}
+		if p.MaxAttempts > 0 && attempt >= p.MaxAttempts {
+			return fmt.Errorf("gave up after %d attempts: %w", attempt, err)
+		}
+
+		wait := delay
+		if wait > 0 {
+			wait = wait/2 + time.Duration(rng.Int63n(int64(wait)/2+1))
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w: %w", ErrTimeout, ctx.Err())
+		case <-timer.C:
+		}
+
+		delay *= 2
+		if p.MaxDelay > 0 && delay > p.MaxDelay {
+			delay = p.MaxDelay
+		}
+	}
+}
+
+func (p RetryPolicy) attempt(ctx context.Context, fn func(ctx context.Context) error) error {
+	if p.AttemptTimeout <= 0 {
+		return fn(ctx)
+	}
+
+	attemptCtx, cancel := context.WithTimeout(ctx, p.AttemptTimeout)
+	defer cancel()
+
+	return fn(attemptCtx)
+}
@@ -0,0 +1,173 @@
+// Package logging writes leveled, structured logs to stderr, as JSON or
+// logfmt, with the node id and message fields attached to every record.
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Environment variables read when the package is loaded, as maelstrom starts
+// nodes without arguments.
+const (
+	LevelEnv  = "AODS_LOG_LEVEL"
+	FormatEnv = "AODS_LOG_FORMAT"
+)
+
+// Output formats.
+const (
+	JSON   = "json"
+	Logfmt = "logfmt"
+)
+
+var (
+	level = new(slog.LevelVar)
+
+	mu      sync.RWMutex
+	handler slog.Handler
+)
+
+func init() {
+	if l, err := ParseLevel(os.Getenv(LevelEnv)); err == nil {
+		level.Set(l)
+	}
+	if err := Configure(os.Stderr, os.Getenv(FormatEnv)); err != nil {
+		Configure(os.Stderr, Logfmt)
+	}
+
+	// maelstrom logs every message it sends and receives through the log
+	// package, which is only useful when debugging
+	slog.SetLogLoggerLevel(slog.LevelDebug)
+}
+
+// Configure sends the output of loggers created from now on to w in the given
+// format, logfmt if format is empty. It also replaces slog's default logger.
+func Configure(w io.Writer, format string) error {
+	opts := &slog.HandlerOptions{Level: level}
+
+	var h slog.Handler
+	switch strings.ToLower(format) {
+	case JSON:
+		h = slog.NewJSONHandler(w, opts)
+	case Logfmt, "":
+		h = slog.NewTextHandler(w, opts)
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+
+	mu.Lock()
+	handler = h
+	mu.Unlock()
+
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// SetLevel changes the level of every logger while the node runs.
+func SetLevel(l slog.Level) {
+	level.Set(l)
+}
+
+// Level returns the current level.
+func Level() slog.Level {
+	return level.Level()
+}
+
+// ParseLevel parses debug, info, warn or error.
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	err := l.UnmarshalText([]byte(s))
+	return l, err
+}
+
+// For returns a logger that adds the node id to every record. The id is read
+// when logging, so the logger can be created before init.
+func For(n *maelstrom.Node) *slog.Logger {
+	mu.RLock()
+	h := handler
+	mu.RUnlock()
+
+	return slog.New(nodeHandler{Handler: h, n: n})
+}
+
+// Handler is a maelstrom.HandlerFunc that also gets a logger with the fields
+// of the message it handles.
+type Handler func(msg maelstrom.Message, logger *slog.Logger) error
+
+// Handle registers h for messages of type typ on n, passing it logger with
+// the fields of each message attached.
+func Handle(n *maelstrom.Node, logger *slog.Logger, typ string, h Handler) {
+	n.Handle(typ, func(msg maelstrom.Message) error {
+		return h(msg, WithMsg(logger, msg))
+	})
+}
+
+// WithMsg returns a logger that adds the routing fields of msg to every
+// record. The body is only decoded when a record is logged.
+func WithMsg(logger *slog.Logger, msg maelstrom.Message) *slog.Logger {
+	return slog.New(msgHandler{Handler: logger.Handler(), msg: msg})
+}
+
+// Msg returns the routing fields of msg, to be passed to a logging call.
+func Msg(msg maelstrom.Message) slog.Attr {
+	var body maelstrom.MessageBody
+	_ = json.Unmarshal(msg.Body, &body)
+
+	attrs := []any{"src", msg.Src, "dest", msg.Dest, "type", body.Type}
+	if body.MsgID != 0 {
+		attrs = append(attrs, "msg_id", body.MsgID)
+	}
+	if body.InReplyTo != 0 {
+		attrs = append(attrs, "in_reply_to", body.InReplyTo)
+	}
+
+	// An empty group key inlines the fields
+	return slog.Group("", attrs...)
+}
+
+// nodeHandler adds the node id when a record is handled. slog's handlers
+// resolve the attributes given to With right away, before init set the id.
+type nodeHandler struct {
+	slog.Handler
+	n *maelstrom.Node
+}
+
+func (h nodeHandler) Handle(ctx context.Context, r slog.Record) error {
+	r.AddAttrs(slog.String("node", h.n.ID()))
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h nodeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return nodeHandler{Handler: h.Handler.WithAttrs(attrs), n: h.n}
+}
+
+func (h nodeHandler) WithGroup(name string) slog.Handler {
+	return nodeHandler{Handler: h.Handler.WithGroup(name), n: h.n}
+}
+
+// msgHandler adds the routing fields of a message when a record is handled.
+type msgHandler struct {
+	slog.Handler
+	msg maelstrom.Message
+}
+
+func (h msgHandler) Handle(ctx context.Context, r slog.Record) error {
+	r.AddAttrs(Msg(h.msg))
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h msgHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return msgHandler{Handler: h.Handler.WithAttrs(attrs), msg: h.msg}
+}
+
+func (h msgHandler) WithGroup(name string) slog.Handler {
+	return msgHandler{Handler: h.Handler.WithGroup(name), msg: h.msg}
+}
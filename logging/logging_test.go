@@ -0,0 +1,131 @@
+package logging_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/HdkTvd/advent-of-distributed-systems/logging"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// capture sends logs to a buffer as JSON until the test ends.
+func capture(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := logging.Configure(&buf, logging.JSON); err != nil {
+		t.Fatal(err)
+	}
+
+	prev := logging.Level()
+	t.Cleanup(func() {
+		logging.SetLevel(prev)
+		logging.Configure(os.Stderr, logging.Logfmt)
+	})
+
+	return &buf
+}
+
+func records(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var out []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var r map[string]any
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+		out = append(out, r)
+	}
+
+	return out
+}
+
+func TestNodeID(t *testing.T) {
+	buf := capture(t)
+
+	n := maelstrom.NewNode()
+	logger := logging.For(n).With("component", "test")
+	// The id is set by init, after the logger was created
+	n.Init("n3", []string{"n3"})
+	logger.Info("hello")
+
+	rs := records(t, buf)
+	if len(rs) != 1 {
+		t.Fatalf("got %d records, want 1", len(rs))
+	}
+	if rs[0]["node"] != "n3" || rs[0]["component"] != "test" || rs[0]["msg"] != "hello" {
+		t.Errorf("record = %v, want node n3 and component test", rs[0])
+	}
+}
+
+func TestHandleAttachesMsg(t *testing.T) {
+	buf := capture(t)
+
+	n := maelstrom.NewNode()
+	n.Init("n3", []string{"n3"})
+	n.Stdin = strings.NewReader(`{"src":"c1","dest":"n3","body":{"type":"echo","msg_id":7}}` + "\n")
+	n.Stdout = io.Discard
+
+	logging.Handle(n, logging.For(n), "echo", func(msg maelstrom.Message, logger *slog.Logger) error {
+		logger.With("component", "test").Info("handled")
+		return nil
+	})
+	if err := n.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	rs := records(t, buf)
+	if len(rs) != 1 {
+		t.Fatalf("got %d records, want 1", len(rs))
+	}
+	want := map[string]any{"node": "n3", "component": "test", "src": "c1", "dest": "n3", "type": "echo", "msg_id": 7.0}
+	for k, v := range want {
+		if rs[0][k] != v {
+			t.Errorf("record = %v, want %s %v", rs[0], k, v)
+		}
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	buf := capture(t)
+
+	n := maelstrom.NewNode()
+	n.Init("n0", []string{"n0"})
+	logger := logging.For(n)
+
+	logging.SetLevel(slog.LevelWarn)
+	logger.Info("dropped")
+	logger.Warn("kept")
+
+	// Loggers created earlier follow the new level
+	logging.SetLevel(slog.LevelDebug)
+	logger.Debug("debug")
+
+	var msgs []string
+	for _, r := range records(t, buf) {
+		msgs = append(msgs, r["msg"].(string))
+	}
+	if got := strings.Join(msgs, ","); got != "kept,debug" {
+		t.Errorf("logged %q, want kept,debug", got)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for s, want := range map[string]slog.Level{"debug": slog.LevelDebug, "INFO": slog.LevelInfo, "warn": slog.LevelWarn, "error": slog.LevelError} {
+		if l, err := logging.ParseLevel(s); err != nil || l != want {
+			t.Errorf("ParseLevel(%q) = %v, %v, want %v", s, l, err, want)
+		}
+	}
+	if _, err := logging.ParseLevel("loud"); err == nil {
+		t.Error("ParseLevel accepted an unknown level")
+	}
+}
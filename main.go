@@ -1,19 +1,185 @@
 package main
 
 import (
-	"github.com/HdkTvd/advent-of-distributed-systems/c5"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	mst "github.com/HdkTvd/advent-of-distributed-systems/MST"
+	"github.com/HdkTvd/advent-of-distributed-systems/antientropy"
+	"github.com/HdkTvd/advent-of-distributed-systems/history"
+	"github.com/HdkTvd/advent-of-distributed-systems/logging"
+	"github.com/HdkTvd/advent-of-distributed-systems/wal"
+	"github.com/HdkTvd/advent-of-distributed-systems/workload"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	// Challenges register their workloads on import
+	_ "github.com/HdkTvd/advent-of-distributed-systems/c1"
+	_ "github.com/HdkTvd/advent-of-distributed-systems/c2"
+	_ "github.com/HdkTvd/advent-of-distributed-systems/c3"
+	_ "github.com/HdkTvd/advent-of-distributed-systems/c4"
+	_ "github.com/HdkTvd/advent-of-distributed-systems/c5"
 )
 
+// workloadEnv is read when neither a subcommand nor --workload is given,
+// as maelstrom starts the binary without arguments.
+const workloadEnv = "AODS_WORKLOAD"
+
+const defaultWorkload = "kafka-multi"
+
+// historyEnv names the file the client history is written to, for the same
+// reason as workloadEnv.
+const historyEnv = "AODS_HISTORY"
+
 func main() {
-	// Import challenges and test
-
-	// c1.Maelstrom_echo()
-	// c2.Unique_id_generation()
-	// c3.Single_node_broadcast()
-	// c3.Multi_node_broadcast()
-	// c3.Fault_tolerant_broadcast()
-	// c3.Efficient_broadcast()
-	// c4.GrowOnlyCoounter()
-	// c5.KafkaStyleLogSingleNode()
-	c5.KafkaStyleLogMultiNode()
+	name := flag.String("workload", "", "name of the workload to run")
+	list := flag.Bool("list", false, "print the registered workloads and exit")
+	logLevel := flag.String("log-level", "", "log level - debug, info, warn or error (default "+logging.LevelEnv+" or info)")
+	logFormat := flag.String("log-format", "", "log format - logfmt or json (default "+logging.FormatEnv+" or logfmt)")
+	antiEntropy := flag.String("anti-entropy", "", "how broadcast neighbors reconcile their values - merkle or iblt (default "+antientropy.MethodEnv+" or merkle)")
+	dataDir := flag.String("data-dir", wal.Dir(), "directory the fault tolerant broadcast keeps its log in, one file per node (default "+wal.DirEnv+", in memory if empty)")
+	topology := flag.String("topology", "", "graph the efficient broadcast spans a tree over - mst, tree:k, grid, ring, line, star, complete, regular:k, balanced:depth:degree or bounded:depth:degree (default "+mst.TopologyEnv+" or the topology maelstrom sends)")
+	historyPath := flag.String("history", os.Getenv(historyEnv), "write each node's client history to this file with the node id added before the extension, as EDN if it ends in .edn and JSONL otherwise")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [--list] [--log-level level] [--log-format format] [--anti-entropy method] [--data-dir dir] [--topology spec] [--history file] [--workload name | name]\n\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintf(flag.CommandLine.Output(), "\nThe workload can also be set with %s (default %q).\n", workloadEnv, defaultWorkload)
+	}
+	flag.Parse()
+
+	// flag.Parse stops at the subcommand, the flags after it are parsed again
+	args := flag.Args()
+	if len(args) > 0 {
+		flag.CommandLine.Parse(args[1:])
+		if flag.NArg() > 0 {
+			fmt.Fprintf(os.Stderr, "Unexpected arguments after the workload - %s\n", strings.Join(flag.Args(), " "))
+			os.Exit(2)
+		}
+		args = args[:1]
+	}
+
+	if *list {
+		for _, w := range workload.Names() {
+			fmt.Println(w)
+		}
+		return
+	}
+
+	if err := configureLogging(*logLevel, *logFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if *antiEntropy != "" {
+		m, err := antientropy.ParseMethod(*antiEntropy)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		antientropy.SetMethod(m)
+	}
+
+	wal.SetDir(*dataDir)
+
+	if *topology != "" {
+		if err := mst.SetTopology(*topology); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	selected := resolveWorkload(*name, args)
+
+	setup, ok := workload.Lookup(selected)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown workload %q, available - %s\n", selected, strings.Join(workload.Names(), ", "))
+		os.Exit(2)
+	}
+
+	n := maelstrom.NewNode()
+
+	if *historyPath != "" {
+		rec := history.NewRecorder()
+		setup = rec.Wrap(setup)
+		defer writeHistory(rec, n, *historyPath)
+
+		// maelstrom stops nodes with a signal rather than closing stdin
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+		go func() {
+			<-sig
+			writeHistory(rec, n, *historyPath)
+			os.Exit(0)
+		}()
+	}
+
+	setup(n)
+
+	if err := n.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// writeHistory writes rec to path with the node id added, as every node of a
+// maelstrom run shares the same flags and environment.
+func writeHistory(rec *history.Recorder, n *maelstrom.Node, path string) {
+	ext := filepath.Ext(path)
+	path = strings.TrimSuffix(path, ext) + "." + n.ID() + ext
+
+	if err := rec.WriteFile(path); err != nil {
+		slog.Error("Failed to write history", "path", path, "err", err)
+	}
+}
+
+// configureLogging applies the log flags and lets SIGUSR1 and SIGUSR2 switch
+// between debug and the configured level while the node runs.
+func configureLogging(level, format string) error {
+	if level != "" {
+		l, err := logging.ParseLevel(level)
+		if err != nil {
+			return err
+		}
+		logging.SetLevel(l)
+	}
+	if format != "" {
+		if err := logging.Configure(os.Stderr, format); err != nil {
+			return err
+		}
+	}
+
+	configured := logging.Level()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for s := range sig {
+			if s == syscall.SIGUSR1 {
+				logging.SetLevel(slog.LevelDebug)
+			} else {
+				logging.SetLevel(configured)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// resolveWorkload picks the workload name by precedence: subcommand, flag, environment, default.
+func resolveWorkload(flagValue string, args []string) string {
+	if len(args) > 0 {
+		return args[0]
+	}
+	if flagValue != "" {
+		return flagValue
+	}
+	if env := os.Getenv(workloadEnv); env != "" {
+		return env
+	}
+
+	return defaultWorkload
 }
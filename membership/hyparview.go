@@ -0,0 +1,621 @@
+package membership
+
+import (
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/HdkTvd/advent-of-distributed-systems/logging"
+	"github.com/HdkTvd/advent-of-distributed-systems/schema"
+	"github.com/HdkTvd/advent-of-distributed-systems/sim"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// HyParView (Leitao et al., HyParView: a membership protocol for reliable gossip-based broadcast)
+// 1. Every node keeps a small, symmetric active view - its neighbors - and a larger passive view of backup peers.
+// 2. A new node joins through a contact, which walks a FORWARDJOIN through the overlay so the node lands in
+// the active views of random peers, and in passive views along the way.
+// 3. SHUFFLE random walks exchange samples of the views, which keeps the passive views fresh.
+// 4. An active peer that misses its heartbeats is dropped and a passive peer is promoted in its place.
+
+// Config sizes the views and paces the protocol.
+type Config struct {
+	ActiveSize  int
+	PassiveSize int
+	// ActiveWalk is the length of a FORWARDJOIN walk, PassiveWalk the hop at which it adds the joining node to
+	// the passive view.
+	ActiveWalk  int
+	PassiveWalk int
+	// ShuffleActive and ShufflePassive are how many peers of each view a SHUFFLE carries.
+	ShuffleActive  int
+	ShufflePassive int
+
+	ShuffleInterval   time.Duration
+	HeartbeatInterval time.Duration
+	// FailureTimeout is how long an active peer may stay silent, and how long a promotion may go unanswered.
+	FailureTimeout time.Duration
+}
+
+// DefaultConfig suits clusters of a few dozen nodes.
+func DefaultConfig() Config {
+	return Config{
+		ActiveSize:        4,
+		PassiveSize:       24,
+		ActiveWalk:        6,
+		PassiveWalk:       3,
+		ShuffleActive:     3,
+		ShufflePassive:    4,
+		ShuffleInterval:   time.Second,
+		HeartbeatInterval: 500 * time.Millisecond,
+		FailureTimeout:    2 * time.Second,
+	}
+}
+
+// HyParView is a View over the active view of a HyParView overlay.
+type HyParView struct {
+	mu     sync.Mutex
+	n      *maelstrom.Node
+	cfg    Config
+	clock  sim.Clock
+	rng    *rand.Rand
+	logger *slog.Logger
+
+	active  []string
+	passive []string
+	// lastSeen is when each active peer last answered or sent a heartbeat
+	lastSeen map[string]time.Time
+
+	joined bool
+	// rejoin is set once the node lost every peer or a join failed, it then joins through a random node
+	rejoin bool
+	// joining is set while a JOIN is unanswered, join tells the attempts apart
+	joining     bool
+	joinAttempt int
+	lastShuffle time.Time
+	// shuffled is the sample sent with the last SHUFFLE, evicted first when the reply fills the passive view
+	shuffled []string
+	// promoting is the passive peer asked to become a neighbor, promotion tells the attempts apart
+	promoting string
+	promotion int
+
+	listeners []func(neighbors []string)
+}
+
+// NewHyParView registers the membership handlers on n. The overlay forms once Start is called from the
+// workload's init handler.
+func NewHyParView(n *maelstrom.Node, cfg Config) *HyParView {
+	env := sim.For(n)
+	h := &HyParView{
+		n:        n,
+		cfg:      cfg,
+		clock:    env.Clock,
+		rng:      env.Rand,
+		logger:   logging.For(n),
+		lastSeen: make(map[string]time.Time),
+	}
+
+	n.Handle("join", func(msg maelstrom.Message) error {
+		h.onJoin(msg.Src)
+		return n.Reply(msg, schema.NewJoinOK())
+	})
+
+	n.Handle("neighbor", func(msg maelstrom.Message) error {
+		body, err := schema.Decode[schema.Neighbor](msg)
+		if err != nil {
+			return err
+		}
+
+		return n.Reply(msg, schema.NewNeighborOK(h.onNeighbor(msg.Src, body.HighPriority)))
+	})
+
+	n.Handle("heartbeat", func(msg maelstrom.Message) error {
+		return n.Reply(msg, schema.NewHeartbeatOK(h.onHeartbeat(msg.Src)))
+	})
+
+	// The messages below are sent without msg_id and need no reply
+	logging.Handle(n, h.logger, "forward_join", func(msg maelstrom.Message, logger *slog.Logger) error {
+		body, err := schema.Decode[schema.ForwardJoin](msg)
+		if err != nil {
+			logger.Warn("Dropping malformed forward_join", "err", err)
+			return nil
+		}
+
+		h.onForwardJoin(msg.Src, body.Node, body.TTL)
+		return nil
+	})
+
+	n.Handle("disconnect", func(msg maelstrom.Message) error {
+		h.onDisconnect(msg.Src)
+		return nil
+	})
+
+	logging.Handle(n, h.logger, "shuffle", func(msg maelstrom.Message, logger *slog.Logger) error {
+		body, err := schema.Decode[schema.Shuffle](msg)
+		if err != nil {
+			logger.Warn("Dropping malformed shuffle", "err", err)
+			return nil
+		}
+
+		h.onShuffle(msg.Src, body.Origin, body.Nodes, body.TTL)
+		return nil
+	})
+
+	logging.Handle(n, h.logger, "shuffle_reply", func(msg maelstrom.Message, logger *slog.Logger) error {
+		body, err := schema.Decode[schema.ShuffleReply](msg)
+		if err != nil {
+			logger.Warn("Dropping malformed shuffle_reply", "err", err)
+			return nil
+		}
+
+		h.mu.Lock()
+		h.integrate(body.Nodes, h.shuffled)
+		h.mu.Unlock()
+		return nil
+	})
+
+	return h
+}
+
+// OnChange registers f to be called with the new neighbors whenever the active view changes. f runs while
+// the view is locked and must not call back into h.
+func (h *HyParView) OnChange(f func(neighbors []string)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.listeners = append(h.listeners, f)
+}
+
+func (h *HyParView) Neighbors() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return sorted(h.active)
+}
+
+// Passive returns the passive view in sorted order.
+func (h *HyParView) Passive() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return sorted(h.passive)
+}
+
+// Start joins the overlay through the first node of the cluster and keeps the views up to date. It must be
+// called after init, when the node knows its id.
+func (h *HyParView) Start() {
+	h.mu.Lock()
+	h.joined = h.n.ID() == h.contact()
+	h.lastShuffle = h.clock.Now()
+	h.mu.Unlock()
+
+	h.clock.Go(func() {
+		for {
+			h.tick()
+			h.clock.Sleep(h.cfg.HeartbeatInterval)
+		}
+	})
+}
+
+func (h *HyParView) contact() string {
+	return sorted(h.n.NodeIDs())[0]
+}
+
+func (h *HyParView) tick() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.clock.Now()
+
+	// A node that lost every peer, e.g. behind a partition, starts over from a random node
+	if h.joined && len(h.active) == 0 && len(h.passive) == 0 {
+		h.joined, h.rejoin = false, true
+	}
+	if !h.joined {
+		h.join()
+		return
+	}
+
+	for _, p := range sorted(h.active) {
+		if now.Sub(h.lastSeen[p]) > h.cfg.FailureTimeout {
+			h.logger.Warn("Dropping unresponsive active peer", "dest", p)
+			h.removeActive(p)
+			continue
+		}
+		h.heartbeat(p)
+	}
+
+	h.promote()
+
+	if now.Sub(h.lastShuffle) >= h.cfg.ShuffleInterval {
+		h.lastShuffle = now
+		h.shuffle()
+	}
+}
+
+// join asks a contact to add the node, one contact at a time. A contact that fails the join or does not answer
+// within FailureTimeout is given up on, and the next attempt goes to a random node. Callers hold mu.
+func (h *HyParView) join() {
+	if h.joining {
+		return
+	}
+
+	contact := h.contact()
+	if h.rejoin {
+		contact = h.randomOther()
+	}
+	if contact == "" || contact == h.n.ID() {
+		return
+	}
+
+	h.joinAttempt++
+	attempt := h.joinAttempt
+	h.joining = true
+
+	h.send(contact, schema.NewJoin(), func(msg maelstrom.Message) error {
+		_, err := schema.DecodeReply[maelstrom.MessageBody](msg, "join_ok")
+
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		if h.joinAttempt == attempt {
+			h.joining = false
+		}
+		if err != nil {
+			h.rejoin = true
+			return err
+		}
+
+		h.joined = true
+		h.addActive(msg.Src)
+		// A random contact may belong to a group of nodes that joined apart from the rest, so seed the passive
+		// view with random nodes that promotions and shuffles can reach the other groups through
+		if h.rejoin {
+			for _, p := range h.sample(h.others(), h.cfg.PassiveSize) {
+				h.addPassive(p)
+			}
+		}
+		return nil
+	})
+
+	h.clock.AfterFunc(h.cfg.FailureTimeout, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		if h.joinAttempt == attempt && h.joining {
+			h.logger.Debug("Giving up on unresponsive contact", "dest", contact)
+			h.joining, h.rejoin = false, true
+		}
+	})
+}
+
+func (h *HyParView) randomOther() string {
+	others := h.others()
+	if len(others) == 0 {
+		return ""
+	}
+	return others[h.rng.Intn(len(others))]
+}
+
+// others returns every node of the cluster but this one.
+func (h *HyParView) others() []string {
+	var others []string
+	for _, id := range sorted(h.n.NodeIDs()) {
+		if id != h.n.ID() {
+			others = append(others, id)
+		}
+	}
+	return others
+}
+
+func (h *HyParView) onJoin(src string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.addActive(src)
+	for _, p := range sorted(h.active) {
+		if p != src {
+			h.send(p, schema.NewForwardJoin(src, h.cfg.ActiveWalk), nil)
+		}
+	}
+}
+
+func (h *HyParView) onForwardJoin(src, node string, ttl int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if node == h.n.ID() {
+		return
+	}
+
+	next := h.pick(h.active, src, node)
+	if ttl <= 0 || next == "" {
+		h.connect(node)
+		return
+	}
+
+	if ttl == h.cfg.PassiveWalk {
+		h.addPassive(node)
+	}
+	h.send(next, schema.NewForwardJoin(node, ttl-1), nil)
+}
+
+// connect makes node a neighbor at the end of its FORWARDJOIN walk. Callers hold mu.
+func (h *HyParView) connect(node string) {
+	if contains(h.active, node) {
+		return
+	}
+
+	h.send(node, schema.NewNeighbor(true), func(msg maelstrom.Message) error {
+		body, err := schema.DecodeReply[schema.NeighborOK](msg, "neighbor_ok")
+		if err != nil {
+			return err
+		}
+
+		if body.Accepted {
+			h.mu.Lock()
+			h.addActive(node)
+			h.mu.Unlock()
+		}
+		return nil
+	})
+}
+
+func (h *HyParView) onNeighbor(src string, highPriority bool) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !highPriority && !contains(h.active, src) && len(h.active) >= h.cfg.ActiveSize {
+		return false
+	}
+
+	h.addActive(src)
+	return true
+}
+
+func (h *HyParView) onHeartbeat(src string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !contains(h.active, src) {
+		return false
+	}
+
+	h.lastSeen[src] = h.clock.Now()
+	return true
+}
+
+func (h *HyParView) onDisconnect(src string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if contains(h.active, src) {
+		h.removeActive(src)
+		h.addPassive(src)
+	}
+}
+
+// heartbeat checks p, which drops the link if p no longer has the node as a neighbor. Callers hold mu.
+func (h *HyParView) heartbeat(p string) {
+	h.send(p, schema.NewHeartbeat(), func(msg maelstrom.Message) error {
+		body, err := schema.DecodeReply[schema.HeartbeatOK](msg, "heartbeat_ok")
+		if err != nil {
+			return err
+		}
+
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		if !contains(h.active, p) {
+			return nil
+		}
+		if !body.Active {
+			h.logger.Debug("Dropping one sided active peer", "dest", p)
+			h.removeActive(p)
+			h.addPassive(p)
+			return nil
+		}
+		h.lastSeen[p] = h.clock.Now()
+		return nil
+	})
+}
+
+// promote asks a random passive peer to become a neighbor while the active view is not full, one peer at a
+// time. A peer that does not answer within FailureTimeout is forgotten. Callers hold mu.
+func (h *HyParView) promote() {
+	if len(h.active) >= h.cfg.ActiveSize || h.promoting != "" {
+		return
+	}
+
+	p := h.pick(h.passive)
+	if p == "" {
+		return
+	}
+
+	h.promotion++
+	attempt := h.promotion
+	h.promoting = p
+
+	h.send(p, schema.NewNeighbor(len(h.active) == 0), func(msg maelstrom.Message) error {
+		body, err := schema.DecodeReply[schema.NeighborOK](msg, "neighbor_ok")
+
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		if h.promotion == attempt {
+			h.promoting = ""
+		}
+		if err != nil {
+			return err
+		}
+		if body.Accepted {
+			h.logger.Debug("Promoted passive peer", "dest", p)
+			h.addActive(p)
+		}
+		return nil
+	})
+
+	h.clock.AfterFunc(h.cfg.FailureTimeout, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		if h.promotion == attempt && h.promoting == p {
+			h.logger.Debug("Forgetting unresponsive passive peer", "dest", p)
+			h.promoting = ""
+			h.passive = remove(h.passive, p)
+		}
+	})
+}
+
+// shuffle sends a sample of both views on a random walk. Callers hold mu.
+func (h *HyParView) shuffle() {
+	p := h.pick(h.active)
+	if p == "" {
+		return
+	}
+
+	sample := append([]string{h.n.ID()}, h.sample(h.active, h.cfg.ShuffleActive, p)...)
+	sample = append(sample, h.sample(h.passive, h.cfg.ShufflePassive)...)
+	h.shuffled = sample
+
+	h.send(p, schema.NewShuffle(h.n.ID(), sample, h.cfg.ActiveWalk), nil)
+}
+
+func (h *HyParView) onShuffle(src, origin string, nodes []string, ttl int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if ttl--; ttl > 0 {
+		if next := h.pick(h.active, src, origin); next != "" {
+			h.send(next, schema.NewShuffle(origin, nodes, ttl), nil)
+			return
+		}
+	}
+
+	if origin == h.n.ID() {
+		return
+	}
+
+	reply := h.sample(h.passive, len(nodes))
+	h.send(origin, schema.NewShuffleReply(reply), nil)
+	h.integrate(nodes, reply)
+}
+
+// integrate adds nodes to the passive view, evicting the peers in sent first and random ones after that.
+// Callers hold mu.
+func (h *HyParView) integrate(nodes, sent []string) {
+	for _, p := range nodes {
+		if p == h.n.ID() || contains(h.active, p) || contains(h.passive, p) {
+			continue
+		}
+
+		if len(h.passive) >= h.cfg.PassiveSize {
+			evict := ""
+			for _, s := range sent {
+				if contains(h.passive, s) && s != h.promoting {
+					evict = s
+					break
+				}
+			}
+			if evict == "" {
+				evict = h.pick(h.passive, h.promoting)
+			}
+			// Only the peer being promoted is left, and it must not be dropped
+			if evict == "" {
+				continue
+			}
+			h.passive = remove(h.passive, evict)
+		}
+		h.passive = append(h.passive, p)
+	}
+}
+
+// addActive makes p a neighbor, moving a random neighbor to the passive view if the active view is full.
+// Callers hold mu.
+func (h *HyParView) addActive(p string) {
+	if p == h.n.ID() || contains(h.active, p) {
+		return
+	}
+
+	if len(h.active) >= h.cfg.ActiveSize {
+		dropped := h.pick(h.active)
+		h.logger.Debug("Dropping random active peer", "dest", dropped)
+		h.send(dropped, schema.NewDisconnect(), nil)
+		h.removeActive(dropped)
+		h.addPassive(dropped)
+	}
+
+	h.passive = remove(h.passive, p)
+	h.active = append(h.active, p)
+	h.lastSeen[p] = h.clock.Now()
+	h.changed()
+}
+
+// removeActive drops p from the active view. Callers hold mu.
+func (h *HyParView) removeActive(p string) {
+	h.active = remove(h.active, p)
+	delete(h.lastSeen, p)
+	h.changed()
+}
+
+// addPassive adds p to the passive view, evicting a random peer if it is full. p is left out if the only
+// peer to evict is the one being promoted. Callers hold mu.
+func (h *HyParView) addPassive(p string) {
+	if p == h.n.ID() || contains(h.active, p) || contains(h.passive, p) {
+		return
+	}
+
+	if len(h.passive) >= h.cfg.PassiveSize {
+		evict := h.pick(h.passive, h.promoting)
+		if evict == "" {
+			return
+		}
+		h.passive = remove(h.passive, evict)
+	}
+	h.passive = append(h.passive, p)
+}
+
+func (h *HyParView) changed() {
+	neighbors := sorted(h.active)
+	h.logger.Debug("Active view changed", "neighbors", neighbors)
+	for _, f := range h.listeners {
+		f(neighbors)
+	}
+}
+
+// pick returns a random peer of view that is not excluded, or "" if there is none.
+func (h *HyParView) pick(view []string, exclude ...string) string {
+	candidates := h.sample(view, len(view), exclude...)
+	if len(candidates) == 0 {
+		return ""
+	}
+	return candidates[0]
+}
+
+// sample returns up to k random peers of view that are not excluded.
+func (h *HyParView) sample(view []string, k int, exclude ...string) []string {
+	var candidates []string
+	for _, p := range view {
+		if !contains(exclude, p) {
+			candidates = append(candidates, p)
+		}
+	}
+
+	h.rng.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	return candidates[:min(k, len(candidates))]
+}
+
+// send sends body to dest, as an RPC if onReply is set. Callers hold mu.
+func (h *HyParView) send(dest string, body any, onReply maelstrom.HandlerFunc) {
+	var err error
+	if onReply != nil {
+		err = h.n.RPC(dest, body, onReply)
+	} else {
+		err = h.n.Send(dest, body)
+	}
+	if err != nil {
+		h.logger.Error("Failed to send", "dest", dest, "err", err)
+	}
+}
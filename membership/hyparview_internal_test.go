@@ -0,0 +1,31 @@
+package membership
+
+import (
+	"slices"
+	"testing"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func TestPassiveViewKeepsPromotingPeer(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.PassiveSize = 1
+	h := NewHyParView(maelstrom.NewNode(), cfg)
+	h.n.Init("n0", []string{"n0", "n1", "n2", "n3"})
+
+	// The only passive peer is being promoted, so there is no room to make
+	h.passive = []string{"n1"}
+	h.promoting = "n1"
+	h.addPassive("n2")
+	h.integrate([]string{"n3"}, nil)
+	if !slices.Equal(h.passive, []string{"n1"}) {
+		t.Fatalf("passive view = %v, want [n1]", h.passive)
+	}
+
+	// Once the promotion is over a new peer takes its place
+	h.promoting = ""
+	h.addPassive("n2")
+	if !slices.Equal(h.passive, []string{"n2"}) {
+		t.Fatalf("passive view = %v, want [n2]", h.passive)
+	}
+}
@@ -0,0 +1,294 @@
+package membership
+
+import (
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	mst "github.com/HdkTvd/advent-of-distributed-systems/MST"
+	"github.com/HdkTvd/advent-of-distributed-systems/logging"
+	"github.com/HdkTvd/advent-of-distributed-systems/schema"
+	"github.com/HdkTvd/advent-of-distributed-systems/sim"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Latency tree
+// 1. Every ProbeInterval each node sends rtt_probe to every other node and keeps a moving average of the round
+// trip times. Probes and their replies carry the sender's averages, so every node learns the whole matrix.
+// 2. The reachable node with the smallest id builds the tree from the matrix with LatencyConfig.Build, and
+// replaces it only once its LatencyConfig.Cost under the current round trip times is more than Drift above a
+// fresh tree's, so the tree does not flap with jitter.
+// 3. Probes carry the tree too and every node follows the builder's, so all nodes agree on the edges. While a
+// new tree spreads, a node also counts as neighbors the peers whose tree has it.
+// 4. A peer silent for FailureTimeout gets unreachableRTT, which leaves it a leaf at the edge of the tree,
+// and the next smallest id takes over building if it was the builder.
+
+// LatencyConfig paces the probes and picks the tree.
+type LatencyConfig struct {
+	ProbeInterval time.Duration
+	// Drift is how much larger, relative to a tree built from the current round trip times, the cost of the
+	// tree may grow before it is rebuilt. Growth below MinDrift is jitter and never rebuilds it
+	Drift    float64
+	MinDrift time.Duration
+	// FailureTimeout is how long a peer may leave probes unanswered before it is unreachable
+	FailureTimeout time.Duration
+	// Build turns the matrix into a tree, such as mst.MinimumSpanningTreeBy or mst.MinimumDiameterTreeBy
+	Build func(nodes []string, weight mst.Weight) map[string][]string
+	// Cost is what Build keeps small, such as mst.TotalWeight or mst.Diameter, so trees are compared the way
+	// they were built
+	Cost func(t map[string][]string, weight mst.Weight) time.Duration
+}
+
+// DefaultLatencyConfig builds the minimum spanning tree of the round trip times.
+func DefaultLatencyConfig() LatencyConfig {
+	return LatencyConfig{
+		ProbeInterval:  2 * time.Second,
+		Drift:          0.25,
+		MinDrift:       5 * time.Millisecond,
+		FailureTimeout: 6 * time.Second,
+		Build:          mst.MinimumSpanningTreeBy,
+		Cost:           mst.TotalWeight,
+	}
+}
+
+const (
+	// rttWeight is the weight of a new sample in the moving average
+	rttWeight = 0.3
+	// unreachableRTT is the round trip time of peers that do not answer, or that nobody measured yet
+	unreachableRTT = time.Minute
+)
+
+// LatencyTree is a View over a spanning tree weighted by the round trip times between nodes.
+type LatencyTree struct {
+	mu     sync.Mutex
+	n      *maelstrom.Node
+	cfg    LatencyConfig
+	clock  sim.Clock
+	logger *slog.Logger
+
+	// rtt is the moving average of the round trip time to each peer
+	rtt      map[string]time.Duration
+	lastSeen map[string]time.Time
+	// rows and claims are the last round trip times and tree neighbors each peer sent
+	rows   map[string]map[string]int
+	claims map[string][]string
+
+	// tree is the tree the node follows, neighbors its own edges in it
+	tree      map[string][]string
+	neighbors []string
+}
+
+type edge struct {
+	a, b string
+}
+
+// NewLatencyTree registers the probe handler on n. Probing starts once Start is called from the workload's
+// init handler.
+func NewLatencyTree(n *maelstrom.Node, cfg LatencyConfig) *LatencyTree {
+	env := sim.For(n)
+	t := &LatencyTree{
+		n:        n,
+		cfg:      cfg,
+		clock:    env.Clock,
+		logger:   logging.For(n),
+		rtt:      make(map[string]time.Duration),
+		lastSeen: make(map[string]time.Time),
+		rows:     make(map[string]map[string]int),
+		claims:   make(map[string][]string),
+	}
+
+	n.Handle("rtt_probe", func(msg maelstrom.Message) error {
+		body, err := schema.Decode[schema.RTTProbe](msg)
+		if err != nil {
+			return err
+		}
+
+		t.mu.Lock()
+		t.learn(msg.Src, body.Row, body.Tree)
+		row, tree := t.row(), t.tree
+		t.mu.Unlock()
+
+		return n.Reply(msg, schema.NewRTTProbeOK(row, tree))
+	})
+
+	return t
+}
+
+// Neighbors returns the node's neighbors in its own tree and the peers whose tree has the node.
+func (t *LatencyTree) Neighbors() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	neighbors := append([]string(nil), t.neighbors...)
+	now := t.clock.Now()
+	for p, claim := range t.claims {
+		if contains(claim, t.n.ID()) && !contains(neighbors, p) && t.alive(p, now) {
+			neighbors = append(neighbors, p)
+		}
+	}
+	return sorted(neighbors)
+}
+
+// Start probes every peer every ProbeInterval and rebuilds the tree when the round trip times drift. It must be
+// called after init, when the node knows its id.
+func (t *LatencyTree) Start() {
+	t.clock.Go(func() {
+		for {
+			t.probe()
+			t.clock.Sleep(t.cfg.ProbeInterval)
+
+			t.mu.Lock()
+			t.rebuild()
+			t.mu.Unlock()
+		}
+	})
+}
+
+func (t *LatencyTree) probe() {
+	t.mu.Lock()
+	body := schema.NewRTTProbe(t.row(), t.tree)
+	t.mu.Unlock()
+
+	for _, p := range sorted(t.n.NodeIDs()) {
+		if p == t.n.ID() {
+			continue
+		}
+
+		p, sent := p, t.clock.Now()
+		if err := t.n.RPC(p, body, func(msg maelstrom.Message) error {
+			reply, err := schema.DecodeReply[schema.RTTProbeOK](msg, "rtt_probe_ok")
+			if err != nil {
+				return err
+			}
+
+			now := t.clock.Now()
+			sample := now.Sub(sent)
+
+			t.mu.Lock()
+			defer t.mu.Unlock()
+
+			if avg, ok := t.rtt[p]; ok && t.alive(p, now) {
+				sample = time.Duration(rttWeight*float64(sample) + (1-rttWeight)*float64(avg))
+			}
+			t.rtt[p] = sample
+			t.lastSeen[p] = now
+			t.learn(p, reply.Row, reply.Tree)
+			return nil
+		}); err != nil {
+			t.logger.Error("Failed to send", "dest", p, "err", err)
+		}
+	}
+}
+
+// learn records the row and the tree peer sent, and follows the tree if peer builds it. Callers hold mu.
+func (t *LatencyTree) learn(peer string, row map[string]int, tree map[string][]string) {
+	t.rows[peer] = row
+	t.claims[peer] = tree[peer]
+
+	if tree != nil && peer == t.builder(t.clock.Now()) {
+		t.follow(tree)
+	}
+}
+
+// builder returns the reachable node with the smallest id, which builds the tree. Callers hold mu.
+func (t *LatencyTree) builder(now time.Time) string {
+	builder := t.n.ID()
+	for p := range t.lastSeen {
+		if p < builder && t.alive(p, now) {
+			builder = p
+		}
+	}
+	return builder
+}
+
+// follow makes tree the node's tree. Callers hold mu.
+func (t *LatencyTree) follow(tree map[string][]string) {
+	t.tree = tree
+	neighbors := sorted(tree[t.n.ID()])
+	if !slices.Equal(neighbors, t.neighbors) {
+		t.neighbors = neighbors
+		t.logger.Debug("Latency tree neighbors changed", "neighbors", neighbors)
+	}
+}
+
+// row returns the round trip times to the reachable peers in microseconds. Callers hold mu.
+func (t *LatencyTree) row() map[string]int {
+	now := t.clock.Now()
+	row := make(map[string]int, len(t.rtt))
+	for p, rtt := range t.rtt {
+		if t.alive(p, now) {
+			row[p] = int(rtt / time.Microsecond)
+		}
+	}
+	return row
+}
+
+func (t *LatencyTree) alive(p string, now time.Time) bool {
+	seen, ok := t.lastSeen[p]
+	return ok && now.Sub(seen) <= t.cfg.FailureTimeout
+}
+
+// weight returns the round trip time between a and b, the mean of what both of them measured. Callers hold mu.
+func (t *LatencyTree) weight(a, b string, now time.Time) time.Duration {
+	id := t.n.ID()
+	// A peer this node can not reach is left at the edge of the tree, whatever others measured
+	for _, p := range []string{a, b} {
+		if p != id && !t.alive(p, now) {
+			return unreachableRTT
+		}
+	}
+
+	var sum time.Duration
+	var samples int
+	for _, e := range []edge{{a, b}, {b, a}} {
+		if e.a == id {
+			if rtt, ok := t.rtt[e.b]; ok {
+				sum += rtt
+				samples++
+			}
+		} else if us, ok := t.rows[e.a][e.b]; ok {
+			sum += time.Duration(us) * time.Microsecond
+			samples++
+		}
+	}
+	if samples == 0 {
+		return unreachableRTT
+	}
+	return sum / time.Duration(samples)
+}
+
+// rebuild builds a tree from the current round trip times if the node is the builder, and keeps it if the
+// cost of the current tree grew by more than Drift over the new one's. Callers hold mu.
+func (t *LatencyTree) rebuild() {
+	now := t.clock.Now()
+	if t.builder(now) != t.n.ID() {
+		return
+	}
+	nodes := sorted(t.n.NodeIDs())
+
+	weights := make(map[edge]time.Duration)
+	for i, a := range nodes {
+		for _, b := range nodes[i+1:] {
+			weights[edge{a, b}] = t.weight(a, b, now)
+		}
+	}
+	weight := func(a, b string) time.Duration {
+		if a > b {
+			a, b = b, a
+		}
+		return weights[edge{a, b}]
+	}
+
+	tree := t.cfg.Build(nodes, weight)
+	cost := t.cfg.Cost(tree, weight)
+	if t.tree != nil {
+		drift := t.cfg.Cost(t.tree, weight) - cost
+		if drift <= t.cfg.MinDrift || float64(drift) <= t.cfg.Drift*float64(cost) {
+			return
+		}
+	}
+
+	t.follow(tree)
+	t.logger.Info("Rebuilt the latency tree", "edges", tree, "cost", cost)
+}
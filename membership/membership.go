@@ -0,0 +1,71 @@
+// Package membership decides which peers a broadcast node talks to, either a
+// fixed list from the topology or a HyParView overlay that repairs itself
+// when peers fail.
+package membership
+
+import (
+	"sort"
+	"sync"
+)
+
+// View is the source of the neighbors a broadcast sends to or pulls from.
+type View interface {
+	// Neighbors returns the current neighbors in sorted order.
+	Neighbors() []string
+}
+
+// Static is a View over a fixed list of peers, such as the neighbors in a
+// topology message.
+type Static struct {
+	mu    sync.Mutex
+	peers []string
+}
+
+func NewStatic(peers ...string) *Static {
+	s := &Static{}
+	s.Add(peers...)
+	return s
+}
+
+// Add appends the peers that are not neighbors yet.
+func (s *Static) Add(peers ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range peers {
+		if !contains(s.peers, p) {
+			s.peers = append(s.peers, p)
+		}
+	}
+}
+
+func (s *Static) Neighbors() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return sorted(s.peers)
+}
+
+func contains(peers []string, p string) bool {
+	for _, q := range peers {
+		if q == p {
+			return true
+		}
+	}
+	return false
+}
+
+func remove(peers []string, p string) []string {
+	for i, q := range peers {
+		if q == p {
+			return append(peers[:i], peers[i+1:]...)
+		}
+	}
+	return peers
+}
+
+func sorted(peers []string) []string {
+	s := append([]string(nil), peers...)
+	sort.Strings(s)
+	return s
+}
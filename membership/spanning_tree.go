@@ -0,0 +1,224 @@
+package membership
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/HdkTvd/advent-of-distributed-systems/logging"
+	"github.com/HdkTvd/advent-of-distributed-systems/schema"
+	"github.com/HdkTvd/advent-of-distributed-systems/sim"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Spanning tree (a self-stabilizing BFS tree with leader election)
+// 1. Every node beacons its root, its distance to the root and its parent to its neighbors in the graph.
+// 2. A node follows the smallest root any live neighbor offers, through the neighbor closest to it, or is the
+// root itself if none is smaller than its own id. Its tree neighbors are its parent and the neighbors that
+// name it as their parent.
+// 3. A neighbor that has not beaconed for FailureTimeout is dead, and the nodes below a dead tree edge pick a
+// new parent among their other neighbors.
+// 4. When the root itself is gone, distances to it grow with every beacon until they reach the number of
+// nodes, where offers are ignored and the next smallest id takes over.
+
+// TreeConfig paces the spanning tree.
+type TreeConfig struct {
+	BeaconInterval time.Duration
+	FailureTimeout time.Duration
+}
+
+// DefaultTreeConfig suits clusters of a few dozen nodes.
+func DefaultTreeConfig() TreeConfig {
+	return TreeConfig{
+		BeaconInterval: 500 * time.Millisecond,
+		FailureTimeout: 2 * time.Second,
+	}
+}
+
+// announceDelay collects the changes of a node's place in the tree into one beacon.
+const announceDelay = 50 * time.Millisecond
+
+// SpanningTree is a View over the tree edges of a spanning tree the nodes build together over a graph.
+type SpanningTree struct {
+	mu     sync.Mutex
+	n      *maelstrom.Node
+	cfg    TreeConfig
+	clock  sim.Clock
+	logger *slog.Logger
+
+	// graph holds the node's neighbors in the graph the tree spans
+	graph []string
+	// offers is the last beacon of every graph neighbor
+	offers map[string]offer
+
+	started bool
+	// armed is set while an announcement is scheduled
+	armed  bool
+	root   string
+	dist   int
+	parent string
+}
+
+type offer struct {
+	root   string
+	dist   int
+	parent string
+	at     time.Time
+}
+
+// NewSpanningTree registers the tree handlers on n. The tree forms once Start is called from the workload's
+// init handler and the graph is set.
+func NewSpanningTree(n *maelstrom.Node, cfg TreeConfig) *SpanningTree {
+	env := sim.For(n)
+	t := &SpanningTree{
+		n:      n,
+		cfg:    cfg,
+		clock:  env.Clock,
+		logger: logging.For(n),
+		offers: make(map[string]offer),
+	}
+
+	// Beacons are sent without msg_id and need no reply
+	logging.Handle(n, t.logger, "tree_beacon", func(msg maelstrom.Message, logger *slog.Logger) error {
+		body, err := schema.Decode[schema.TreeBeacon](msg)
+		if err != nil {
+			logger.Warn("Dropping malformed tree_beacon", "err", err)
+			return nil
+		}
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		t.offers[msg.Src] = offer{root: body.Root, dist: body.Dist, parent: body.Parent, at: t.clock.Now()}
+		if t.started && t.elect() {
+			t.announce()
+		}
+		return nil
+	})
+
+	return t
+}
+
+// SetGraph sets the neighbors of the node in the graph the tree spans.
+func (t *SpanningTree) SetGraph(neighbors []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.graph = sorted(neighbors)
+	if t.started {
+		t.elect()
+		t.announce()
+	}
+}
+
+// Neighbors returns the parent and the children of the node.
+func (t *SpanningTree) Neighbors() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.clock.Now()
+	var neighbors []string
+	for _, p := range t.graph {
+		o, ok := t.offers[p]
+		if !ok || !t.alive(o, now) {
+			continue
+		}
+		if p == t.parent || o.parent == t.n.ID() && o.root == t.root {
+			neighbors = append(neighbors, p)
+		}
+	}
+	return neighbors
+}
+
+// Root returns the root the node follows and its distance to it.
+func (t *SpanningTree) Root() (string, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.root, t.dist
+}
+
+// Start makes the node a root of its own and beacons every BeaconInterval. It must be called after init,
+// when the node knows its id.
+func (t *SpanningTree) Start() {
+	t.mu.Lock()
+	t.started = true
+	t.root = t.n.ID()
+	t.elect()
+	t.mu.Unlock()
+
+	t.clock.Go(func() {
+		for {
+			t.mu.Lock()
+			t.elect()
+			t.beacon()
+			t.mu.Unlock()
+
+			t.clock.Sleep(t.cfg.BeaconInterval)
+		}
+	})
+}
+
+// elect picks the root, distance and parent from the live offers, and reports whether they changed. Callers
+// hold mu.
+func (t *SpanningTree) elect() bool {
+	id := t.n.ID()
+	now := t.clock.Now()
+	// Paths are never longer than the number of nodes, longer ones lead to a root that is gone
+	limit := len(t.n.NodeIDs())
+
+	root, dist, parent := id, 0, ""
+	for _, p := range t.graph {
+		o, ok := t.offers[p]
+		if !ok || !t.alive(o, now) || o.dist+1 >= limit || o.parent == id {
+			continue
+		}
+		if o.root < root || o.root == root && o.dist+1 < dist {
+			root, dist, parent = o.root, o.dist+1, p
+		}
+	}
+
+	if root == t.root && dist == t.dist && parent == t.parent {
+		return false
+	}
+
+	if t.parent != "" && parent != t.parent {
+		if o := t.offers[t.parent]; !t.alive(o, now) {
+			t.logger.Warn("Tree edge to the parent is dead", "dest", t.parent)
+		}
+	}
+	t.root, t.dist, t.parent = root, dist, parent
+	t.logger.Debug("Spanning tree changed", "root", root, "dist", dist, "parent", parent)
+	return true
+}
+
+func (t *SpanningTree) alive(o offer, now time.Time) bool {
+	return now.Sub(o.at) <= t.cfg.FailureTimeout
+}
+
+// announce beacons a change of the node's place after announceDelay instead of on the next beacon, so a
+// burst of changes, such as while a dead root's distances grow, is sent once. Callers hold mu.
+func (t *SpanningTree) announce() {
+	if t.armed {
+		return
+	}
+	t.armed = true
+
+	t.clock.AfterFunc(announceDelay, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		t.armed = false
+		t.beacon()
+	})
+}
+
+// beacon sends the node's place in the tree to its graph neighbors. Callers hold mu.
+func (t *SpanningTree) beacon() {
+	body := schema.NewTreeBeacon(t.root, t.dist, t.parent)
+	for _, p := range t.graph {
+		if err := t.n.Send(p, body); err != nil {
+			t.logger.Error("Failed to send", "dest", p, "err", err)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package membership_test
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HdkTvd/advent-of-distributed-systems/harness"
+	"github.com/HdkTvd/advent-of-distributed-systems/membership"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// checkTree fails unless the tree edges of ids are symmetric and span them from root, with every node at its
+// distance in want.
+func checkTree(t *testing.T, trees map[string]*membership.SpanningTree, ids []string, root string, want map[string]int) {
+	t.Helper()
+
+	edges := 0
+	for _, id := range ids {
+		if r, dist := trees[id].Root(); r != root || dist != want[id] {
+			t.Fatalf("%s follows %s at distance %d, want %s at %d", id, r, dist, root, want[id])
+		}
+		for _, p := range trees[id].Neighbors() {
+			if !contains(trees[p].Neighbors(), id) {
+				t.Fatalf("%s has tree neighbor %s, but not the other way round", id, p)
+			}
+			edges++
+		}
+	}
+	if edges/2 != len(ids)-1 {
+		t.Fatalf("%d tree edges span %d nodes, want %d", edges/2, len(ids), len(ids)-1)
+	}
+
+	reached := map[string]bool{root: true}
+	for queue := []string{root}; len(queue) > 0; queue = queue[1:] {
+		for _, p := range trees[queue[0]].Neighbors() {
+			if !reached[p] {
+				reached[p] = true
+				queue = append(queue, p)
+			}
+		}
+	}
+	if len(reached) != len(ids) {
+		t.Fatalf("the tree reaches %d of %d nodes from %s", len(reached), len(ids), root)
+	}
+}
+
+func contains(peers []string, p string) bool {
+	for _, q := range peers {
+		if q == p {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSpanningTreeReformsWithoutRoot(t *testing.T) {
+	cfg := membership.DefaultTreeConfig()
+	var mu sync.Mutex
+	trees := make(map[string]*membership.SpanningTree)
+
+	// The graph is a ring
+	s := harness.NewSimulation(7, 6, func(n *maelstrom.Node) {
+		tree := membership.NewSpanningTree(n, cfg)
+		n.Handle("init", func(msg maelstrom.Message) error {
+			ids := n.NodeIDs()
+			i := slices.Index(ids, n.ID())
+			tree.SetGraph([]string{ids[(i+1)%len(ids)], ids[(i+len(ids)-1)%len(ids)]})
+			tree.Start()
+
+			mu.Lock()
+			trees[n.ID()] = tree
+			mu.Unlock()
+			return nil
+		})
+	})
+	defer s.Close()
+
+	s.Start()
+	ids := s.NodeIDs()
+
+	// The smallest id is elected root, and every node follows it around the shorter side of the ring
+	s.RunFor(3 * time.Second)
+	checkTree(t, trees, ids, "n0", map[string]int{"n0": 0, "n1": 1, "n2": 2, "n3": 3, "n4": 2, "n5": 1})
+
+	// Once n0 is cut off its offers age out, or the distances to it grow until they reach the number of
+	// nodes, and n1 takes over the line that is left
+	var longest int
+	for at := 100 * time.Millisecond; at < 10*time.Second; at += 100 * time.Millisecond {
+		s.Schedule(at, func() {
+			for _, id := range ids[1:] {
+				if _, dist := trees[id].Root(); dist > longest {
+					longest = dist
+				}
+			}
+		})
+	}
+	s.Nemesis().Partition(ids[:1], ids[1:])
+
+	// n0 is only given up on after FailureTimeout without a beacon
+	s.RunFor(cfg.FailureTimeout / 2)
+	if root, _ := trees["n1"].Root(); root != "n0" {
+		t.Fatalf("n1 gave up on n0 within %v, before FailureTimeout", cfg.FailureTimeout/2)
+	}
+	s.RunFor(cfg.FailureTimeout + 8*time.Second)
+
+	if longest >= len(ids) {
+		t.Errorf("a node was %d hops from its root, more than the %d nodes", longest, len(ids))
+	}
+	want := make(map[string]int)
+	for i, id := range ids[1:] {
+		want[id] = i
+	}
+	checkTree(t, trees, ids[1:], "n1", want)
+	if got := trees["n0"].Neighbors(); len(got) != 0 {
+		t.Errorf("the cut off root still has tree neighbors %v", got)
+	}
+}
@@ -0,0 +1,103 @@
+package schema
+
+import (
+	"errors"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// MerkleSync carries the hashes of some nodes of one level of the sender's
+// Merkle tree, the root is level 0.
+type MerkleSync struct {
+	maelstrom.MessageBody
+	Level   int      `json:"level"`
+	Indices []int    `json:"indices"`
+	Hashes  []uint64 `json:"hashes"`
+}
+
+func (m *MerkleSync) Validate() error {
+	if len(m.Indices) != len(m.Hashes) {
+		return errors.New("indices and hashes differ in length")
+	}
+	return nil
+}
+
+func NewMerkleSync(level int, indices []int, hashes []uint64) MerkleSync {
+	return MerkleSync{MessageBody: body("merkle_sync"), Level: level, Indices: indices, Hashes: hashes}
+}
+
+// MerkleSyncOK lists the indices whose hashes differ from the receiver's.
+type MerkleSyncOK struct {
+	maelstrom.MessageBody
+	Differing []int `json:"differing"`
+}
+
+func NewMerkleSyncOK(differing []int) MerkleSyncOK {
+	if differing == nil {
+		differing = []int{}
+	}
+	return MerkleSyncOK{MessageBody: body("merkle_sync_ok"), Differing: differing}
+}
+
+// MerkleValues carries the sender's values in leaf buckets that differ.
+type MerkleValues struct {
+	maelstrom.MessageBody
+	Buckets []int `json:"buckets"`
+	Values  []int `json:"values"`
+}
+
+func NewMerkleValues(buckets, values []int) MerkleValues {
+	if values == nil {
+		values = []int{}
+	}
+	return MerkleValues{MessageBody: body("merkle_values"), Buckets: buckets, Values: values}
+}
+
+// MerkleValuesOK carries the receiver's values in those buckets that the
+// sender did not have.
+type MerkleValuesOK struct {
+	maelstrom.MessageBody
+	Values []int `json:"values"`
+}
+
+func NewMerkleValuesOK(values []int) MerkleValuesOK {
+	if values == nil {
+		values = []int{}
+	}
+	return MerkleValuesOK{MessageBody: body("merkle_values_ok"), Values: values}
+}
+
+// IBLTSync carries an invertible Bloom lookup table of the sender's values,
+// as one slice per cell field.
+type IBLTSync struct {
+	maelstrom.MessageBody
+	Counts []int    `json:"counts"`
+	Keys   []int    `json:"keys"`
+	Checks []uint64 `json:"checks"`
+}
+
+func (s *IBLTSync) Validate() error {
+	if len(s.Counts) == 0 || len(s.Keys) != len(s.Counts) || len(s.Checks) != len(s.Counts) {
+		return errors.New("cell fields differ in length or are empty")
+	}
+	return nil
+}
+
+func NewIBLTSync(counts, keys []int, checks []uint64) IBLTSync {
+	return IBLTSync{MessageBody: body("iblt_sync"), Counts: counts, Keys: keys, Checks: checks}
+}
+
+// IBLTSyncOK carries the receiver's values the sender did not have. Failed
+// is set when the difference was too large for the sketch.
+type IBLTSyncOK struct {
+	maelstrom.MessageBody
+	Values []int `json:"values"`
+	Failed bool  `json:"failed,omitempty"`
+}
+
+func NewIBLTSyncOK(values []int, failed bool) IBLTSyncOK {
+	if values == nil {
+		values = []int{}
+	}
+	return IBLTSyncOK{MessageBody: body("iblt_sync_ok"), Values: values, Failed: failed}
+}
@@ -0,0 +1,63 @@
+package schema
+
+import (
+	"errors"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Bracha is a bracha_send, bracha_echo or bracha_ready of the Bracha
+// broadcast, for the Seq-th value Sender broadcast.
+type Bracha struct {
+	maelstrom.MessageBody
+	Sender  string `json:"sender"`
+	Seq     int    `json:"seq"`
+	Message int    `json:"message"`
+}
+
+func (b *Bracha) Validate() error {
+	if b.Sender == "" || b.Seq < 1 {
+		return errors.New("missing sender or seq")
+	}
+	return nil
+}
+
+func NewBracha(typ, sender string, seq, message int) Bracha {
+	return Bracha{MessageBody: body(typ), Sender: sender, Seq: seq, Message: message}
+}
+
+// BrachaOK acknowledges a Bracha message, so the sender stops retrying it.
+type BrachaOK struct {
+	maelstrom.MessageBody
+}
+
+func NewBrachaOK() BrachaOK {
+	return BrachaOK{MessageBody: body("bracha_ok")}
+}
+
+// BrachaReadOK carries the values in the order the node delivered them, and
+// the sender and sequence number of each.
+type BrachaReadOK struct {
+	maelstrom.MessageBody
+	Messages   []int      `json:"messages"`
+	Deliveries []Delivery `json:"deliveries"`
+}
+
+func NewBrachaReadOK(deliveries []Delivery) BrachaReadOK {
+	if deliveries == nil {
+		deliveries = []Delivery{}
+	}
+	messages := make([]int, len(deliveries))
+	for i, d := range deliveries {
+		messages[i] = d.Message
+	}
+	return BrachaReadOK{MessageBody: body("read_ok"), Messages: messages, Deliveries: deliveries}
+}
+
+// Delivery is a value the Bracha broadcast delivered, the Seq-th one of
+// Sender.
+type Delivery struct {
+	Sender  string `json:"sender"`
+	Seq     int    `json:"seq"`
+	Message int    `json:"message"`
+}
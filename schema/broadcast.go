@@ -0,0 +1,140 @@
+package schema
+
+import (
+	"errors"
+	"sort"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Broadcast is sent by clients, and between nodes to gossip a value.
+type Broadcast struct {
+	maelstrom.MessageBody
+	Message int `json:"message"`
+	// Receivers lists the nodes that already have the value, set by the
+	// multi node broadcast when forwarding.
+	Receivers map[string]bool `json:"receivers,omitempty"`
+}
+
+func (b *Broadcast) Required() []string {
+	return []string{"message"}
+}
+
+func NewBroadcast(message int) Broadcast {
+	return Broadcast{MessageBody: body("broadcast"), Message: message}
+}
+
+func NewBroadcastOK() maelstrom.MessageBody {
+	return body("broadcast_ok")
+}
+
+// BroadcastBatch carries every value the fault tolerant broadcast collected
+// for one neighbor within its batch window.
+type BroadcastBatch struct {
+	maelstrom.MessageBody
+	Messages []int `json:"messages"`
+}
+
+func (b *BroadcastBatch) Validate() error {
+	if b.Messages == nil {
+		return errors.New("missing messages")
+	}
+	return nil
+}
+
+func NewBroadcastBatch(messages []int) BroadcastBatch {
+	return BroadcastBatch{MessageBody: body("broadcast_batch"), Messages: messages}
+}
+
+// NewBroadcastBatchOK acknowledges every value of a batch at once.
+func NewBroadcastBatchOK() maelstrom.MessageBody {
+	return body("broadcast_batch_ok")
+}
+
+// Read asks for every value a node has seen.
+type Read struct {
+	maelstrom.MessageBody
+}
+
+func NewRead() Read {
+	return Read{MessageBody: body("read")}
+}
+
+// ReadOK carries the values a node has seen.
+type ReadOK struct {
+	maelstrom.MessageBody
+	Messages []int `json:"messages"`
+}
+
+// NewReadOK sorts messages, so replies built from a map are the same on
+// every run.
+func NewReadOK(messages []int) ReadOK {
+	if messages == nil {
+		messages = []int{}
+	}
+	sort.Ints(messages)
+	return ReadOK{MessageBody: body("read_ok"), Messages: messages}
+}
+
+// NewOrderedReadOK carries the values in the order the node delivered them,
+// for broadcasts that agree on an order.
+func NewOrderedReadOK(messages []int) ReadOK {
+	if messages == nil {
+		messages = []int{}
+	}
+	return ReadOK{MessageBody: body("read_ok"), Messages: messages}
+}
+
+// Pull asks a peer of the efficient broadcast for the values in its log
+// after the first After entries.
+type Pull struct {
+	maelstrom.MessageBody
+	After int `json:"after"`
+}
+
+func (p *Pull) Validate() error {
+	if p.After < 0 {
+		return errors.New("negative after")
+	}
+	return nil
+}
+
+func NewPull(after int) Pull {
+	return Pull{MessageBody: body("pull"), After: after}
+}
+
+// PullOK carries the next entries of a peer's log. Next is the watermark to
+// pull after the next time.
+type PullOK struct {
+	maelstrom.MessageBody
+	Messages []int `json:"messages"`
+	Next     int   `json:"next"`
+}
+
+func NewPullOK(messages []int, next int) PullOK {
+	if messages == nil {
+		messages = []int{}
+	}
+	return PullOK{MessageBody: body("pull_ok"), Messages: messages, Next: next}
+}
+
+// Topology carries the neighbors of every node.
+type Topology struct {
+	maelstrom.MessageBody
+	Topology map[string][]string `json:"topology"`
+}
+
+func (t *Topology) Validate() error {
+	if t.Topology == nil {
+		return errors.New("missing topology")
+	}
+	return nil
+}
+
+func NewTopology(topology map[string][]string) Topology {
+	return Topology{MessageBody: body("topology"), Topology: topology}
+}
+
+func NewTopologyOK() maelstrom.MessageBody {
+	return body("topology_ok")
+}
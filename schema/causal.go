@@ -0,0 +1,74 @@
+package schema
+
+import (
+	"errors"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// CausalEvent is a value of the causal broadcast. It is the Seq-th value
+// Origin broadcast, and Deps counts the values of every other origin that
+// must be delivered before it.
+type CausalEvent struct {
+	Origin  string         `json:"origin"`
+	Seq     int            `json:"seq"`
+	Deps    map[string]int `json:"deps,omitempty"`
+	Message int            `json:"message"`
+}
+
+// CausalBroadcast is a client's broadcast to the causal broadcast. Clock is
+// a vector clock from an earlier read the value must be delivered after.
+type CausalBroadcast struct {
+	maelstrom.MessageBody
+	Message int            `json:"message"`
+	Clock   map[string]int `json:"clock,omitempty"`
+}
+
+// CausalReadOK carries the values in the order the node delivered them and
+// their vector clock.
+type CausalReadOK struct {
+	maelstrom.MessageBody
+	Messages []int          `json:"messages"`
+	Clock    map[string]int `json:"clock"`
+}
+
+func NewCausalReadOK(messages []int, clock map[string]int) CausalReadOK {
+	if messages == nil {
+		messages = []int{}
+	}
+	return CausalReadOK{MessageBody: body("read_ok"), Messages: messages, Clock: clock}
+}
+
+// CausalPull asks a peer for the events after Clock, the number of values
+// of every origin the sender delivered.
+type CausalPull struct {
+	maelstrom.MessageBody
+	Clock map[string]int `json:"clock"`
+}
+
+func NewCausalPull(clock map[string]int) CausalPull {
+	return CausalPull{MessageBody: body("causal_pull"), Clock: clock}
+}
+
+// CausalPullOK carries the events the sender of the CausalPull misses, in
+// an order they can be delivered in.
+type CausalPullOK struct {
+	maelstrom.MessageBody
+	Events []CausalEvent `json:"events"`
+}
+
+func (c *CausalPullOK) Validate() error {
+	for _, e := range c.Events {
+		if e.Origin == "" || e.Seq < 1 {
+			return errors.New("malformed event")
+		}
+	}
+	return nil
+}
+
+func NewCausalPullOK(events []CausalEvent) CausalPullOK {
+	if events == nil {
+		events = []CausalEvent{}
+	}
+	return CausalPullOK{MessageBody: body("causal_pull_ok"), Events: events}
+}
@@ -0,0 +1,27 @@
+package schema
+
+import maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+// Add increments the grow-only counter of challenge 4.
+type Add struct {
+	maelstrom.MessageBody
+	Delta int `json:"delta"`
+}
+
+func (a *Add) Required() []string {
+	return []string{"delta"}
+}
+
+func NewAddOK() maelstrom.MessageBody {
+	return body("add_ok")
+}
+
+// CounterReadOK carries the counter value.
+type CounterReadOK struct {
+	maelstrom.MessageBody
+	Value int `json:"value"`
+}
+
+func NewCounterReadOK(value int) CounterReadOK {
+	return CounterReadOK{MessageBody: body("read_ok"), Value: value}
+}
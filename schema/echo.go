@@ -0,0 +1,34 @@
+package schema
+
+import maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+// Echo is the echo request of challenge 1.
+type Echo struct {
+	maelstrom.MessageBody
+	Echo any `json:"echo"`
+}
+
+// EchoOK returns the echoed value.
+type EchoOK struct {
+	maelstrom.MessageBody
+	Echo any `json:"echo"`
+}
+
+func NewEchoOK(echo any) EchoOK {
+	return EchoOK{MessageBody: body("echo_ok"), Echo: echo}
+}
+
+// Generate is the unique id request of challenge 2.
+type Generate struct {
+	maelstrom.MessageBody
+}
+
+// GenerateOK carries the generated id.
+type GenerateOK struct {
+	maelstrom.MessageBody
+	ID string `json:"id"`
+}
+
+func NewGenerateOK(id string) GenerateOK {
+	return GenerateOK{MessageBody: body("generate_ok"), ID: id}
+}
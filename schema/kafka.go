@@ -0,0 +1,91 @@
+package schema
+
+import (
+	"errors"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Send appends a message to the log of key.
+type Send struct {
+	maelstrom.MessageBody
+	Key string `json:"key"`
+	Msg int    `json:"msg"`
+}
+
+func (s *Send) Required() []string {
+	return []string{"msg"}
+}
+
+func (s *Send) Validate() error {
+	if s.Key == "" {
+		return errors.New("missing key")
+	}
+	return nil
+}
+
+// SendOK carries the offset the message was stored at.
+type SendOK struct {
+	maelstrom.MessageBody
+	Offset int `json:"offset"`
+}
+
+func NewSendOK(offset int) SendOK {
+	return SendOK{MessageBody: body("send_ok"), Offset: offset}
+}
+
+// Poll asks for the messages of each key starting at the given offset.
+type Poll struct {
+	maelstrom.MessageBody
+	Offsets map[string]int `json:"offsets"`
+}
+
+func (p *Poll) Validate() error {
+	if p.Offsets == nil {
+		return errors.New("missing offsets")
+	}
+	return nil
+}
+
+// PollOK carries [offset, message] pairs per key.
+type PollOK struct {
+	maelstrom.MessageBody
+	Msgs map[string][][]int `json:"msgs"`
+}
+
+func NewPollOK(msgs map[string][][]int) PollOK {
+	return PollOK{MessageBody: body("poll_ok"), Msgs: msgs}
+}
+
+// CommitOffsets records the offsets a consumer has processed.
+type CommitOffsets struct {
+	maelstrom.MessageBody
+	Offsets map[string]int `json:"offsets"`
+}
+
+func (c *CommitOffsets) Validate() error {
+	if c.Offsets == nil {
+		return errors.New("missing offsets")
+	}
+	return nil
+}
+
+func NewCommitOffsetsOK() maelstrom.MessageBody {
+	return body("commit_offsets_ok")
+}
+
+// ListCommittedOffsets asks for the committed offsets of keys.
+type ListCommittedOffsets struct {
+	maelstrom.MessageBody
+	Keys []string `json:"keys"`
+}
+
+// ListCommittedOffsetsOK carries the committed offset per key.
+type ListCommittedOffsetsOK struct {
+	maelstrom.MessageBody
+	Offsets map[string]int `json:"offsets"`
+}
+
+func NewListCommittedOffsetsOK(offsets map[string]int) ListCommittedOffsetsOK {
+	return ListCommittedOffsetsOK{MessageBody: body("list_committed_offsets_ok"), Offsets: offsets}
+}
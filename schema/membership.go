@@ -0,0 +1,164 @@
+package schema
+
+import (
+	"errors"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Join asks a contact node to bring the sender into the HyParView overlay.
+type Join struct {
+	maelstrom.MessageBody
+}
+
+func NewJoin() Join {
+	return Join{MessageBody: body("join")}
+}
+
+// NewJoinOK tells the joining node the contact added it to its active view.
+func NewJoinOK() maelstrom.MessageBody {
+	return body("join_ok")
+}
+
+// ForwardJoin walks a joining node through the overlay. Every hop decrements
+// TTL, the node where it reaches zero adds Node to its active view.
+type ForwardJoin struct {
+	maelstrom.MessageBody
+	Node string `json:"node"`
+	TTL  int    `json:"ttl"`
+}
+
+func (f *ForwardJoin) Validate() error {
+	if f.Node == "" {
+		return errors.New("missing node")
+	}
+	return nil
+}
+
+func NewForwardJoin(node string, ttl int) ForwardJoin {
+	return ForwardJoin{MessageBody: body("forward_join"), Node: node, TTL: ttl}
+}
+
+// Neighbor asks a peer to add the sender to its active view. A high priority
+// request comes from a node with an empty active view and is never refused.
+type Neighbor struct {
+	maelstrom.MessageBody
+	HighPriority bool `json:"high_priority"`
+}
+
+func NewNeighbor(highPriority bool) Neighbor {
+	return Neighbor{MessageBody: body("neighbor"), HighPriority: highPriority}
+}
+
+type NeighborOK struct {
+	maelstrom.MessageBody
+	Accepted bool `json:"accepted"`
+}
+
+func NewNeighborOK(accepted bool) NeighborOK {
+	return NeighborOK{MessageBody: body("neighbor_ok"), Accepted: accepted}
+}
+
+// NewDisconnect tells a peer the sender dropped it from its active view.
+func NewDisconnect() maelstrom.MessageBody {
+	return body("disconnect")
+}
+
+// Shuffle carries a sample of Origin's views on a random walk of TTL hops,
+// the node at the end answers with a ShuffleReply to Origin.
+type Shuffle struct {
+	maelstrom.MessageBody
+	Origin string   `json:"origin"`
+	Nodes  []string `json:"nodes"`
+	TTL    int      `json:"ttl"`
+}
+
+func (s *Shuffle) Validate() error {
+	if s.Origin == "" {
+		return errors.New("missing origin")
+	}
+	return nil
+}
+
+func NewShuffle(origin string, nodes []string, ttl int) Shuffle {
+	return Shuffle{MessageBody: body("shuffle"), Origin: origin, Nodes: nodes, TTL: ttl}
+}
+
+// ShuffleReply carries a sample of the passive view back to the origin of a
+// Shuffle.
+type ShuffleReply struct {
+	maelstrom.MessageBody
+	Nodes []string `json:"nodes"`
+}
+
+func NewShuffleReply(nodes []string) ShuffleReply {
+	return ShuffleReply{MessageBody: body("shuffle_reply"), Nodes: nodes}
+}
+
+// Heartbeat checks that an active peer is still reachable.
+type Heartbeat struct {
+	maelstrom.MessageBody
+}
+
+func NewHeartbeat() Heartbeat {
+	return Heartbeat{MessageBody: body("heartbeat")}
+}
+
+// HeartbeatOK reports whether the sender of the Heartbeat is in the active
+// view of the peer, so a one sided link is noticed and dropped.
+type HeartbeatOK struct {
+	maelstrom.MessageBody
+	Active bool `json:"active"`
+}
+
+func NewHeartbeatOK(active bool) HeartbeatOK {
+	return HeartbeatOK{MessageBody: body("heartbeat_ok"), Active: active}
+}
+
+// TreeBeacon announces the sender's place in the spanning tree to its
+// neighbors in the graph: the root it follows, its distance to the root and
+// its parent, empty at the root.
+type TreeBeacon struct {
+	maelstrom.MessageBody
+	Root   string `json:"root"`
+	Dist   int    `json:"dist"`
+	Parent string `json:"parent,omitempty"`
+}
+
+func (t *TreeBeacon) Validate() error {
+	if t.Root == "" {
+		return errors.New("missing root")
+	}
+	if t.Dist < 0 {
+		return errors.New("negative dist")
+	}
+	return nil
+}
+
+func NewTreeBeacon(root string, dist int, parent string) TreeBeacon {
+	return TreeBeacon{MessageBody: body("tree_beacon"), Root: root, Dist: dist, Parent: parent}
+}
+
+// RTTProbe measures the round trip time to a peer. Row carries the sender's
+// round trip times to the peers it reaches, in microseconds, and Tree the
+// latency tree it follows.
+type RTTProbe struct {
+	maelstrom.MessageBody
+	Row  map[string]int      `json:"row"`
+	Tree map[string][]string `json:"tree,omitempty"`
+}
+
+func NewRTTProbe(row map[string]int, tree map[string][]string) RTTProbe {
+	return RTTProbe{MessageBody: body("rtt_probe"), Row: row, Tree: tree}
+}
+
+// RTTProbeOK answers an RTTProbe with the peer's own row and tree.
+type RTTProbeOK struct {
+	maelstrom.MessageBody
+	Row  map[string]int      `json:"row"`
+	Tree map[string][]string `json:"tree,omitempty"`
+}
+
+func NewRTTProbeOK(row map[string]int, tree map[string][]string) RTTProbeOK {
+	return RTTProbeOK{MessageBody: body("rtt_probe_ok"), Row: row, Tree: tree}
+}
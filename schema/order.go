@@ -0,0 +1,48 @@
+package schema
+
+import (
+	"errors"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Proposal is a value of the total order broadcast with the Lamport
+// timestamp its origin gave it.
+type Proposal struct {
+	Timestamp int `json:"ts"`
+	Message   int `json:"message"`
+}
+
+// OrderPull asks a peer for its proposals after the first After ones.
+type OrderPull struct {
+	maelstrom.MessageBody
+	After int `json:"after"`
+}
+
+func (o *OrderPull) Validate() error {
+	if o.After < 0 {
+		return errors.New("negative after")
+	}
+	return nil
+}
+
+func NewOrderPull(after int) OrderPull {
+	return OrderPull{MessageBody: body("order_pull"), After: after}
+}
+
+// OrderPullOK carries the next proposals of a peer, Next is the offset to
+// pull after the next time. Clock promises that every proposal the peer
+// makes later has a larger timestamp.
+type OrderPullOK struct {
+	maelstrom.MessageBody
+	Proposals []Proposal `json:"proposals"`
+	Next      int        `json:"next"`
+	Clock     int        `json:"clock"`
+}
+
+func NewOrderPullOK(proposals []Proposal, next, clock int) OrderPullOK {
+	if proposals == nil {
+		proposals = []Proposal{}
+	}
+	return OrderPullOK{MessageBody: body("order_pull_ok"), Proposals: proposals, Next: next, Clock: clock}
+}
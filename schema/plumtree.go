@@ -0,0 +1,56 @@
+package schema
+
+import maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+// Gossip pushes a value eagerly along the plumtree broadcast tree of Origin,
+// the node that received it from a client. Round is the number of hops it
+// took from Origin.
+type Gossip struct {
+	maelstrom.MessageBody
+	Message int    `json:"message"`
+	Origin  string `json:"origin"`
+	Round   int    `json:"round"`
+}
+
+func NewGossip(message int, origin string, round int) Gossip {
+	return Gossip{MessageBody: body("gossip"), Message: message, Origin: origin, Round: round}
+}
+
+// Announcement is a value offered lazily by an IHave, with its origin and round.
+type Announcement struct {
+	Message int    `json:"message"`
+	Origin  string `json:"origin"`
+	Round   int    `json:"round"`
+}
+
+// IHave announces values to the peers outside the broadcast tree.
+type IHave struct {
+	maelstrom.MessageBody
+	Announcements []Announcement `json:"announcements"`
+}
+
+func NewIHave(announcements []Announcement) IHave {
+	return IHave{MessageBody: body("ihave"), Announcements: announcements}
+}
+
+// Graft asks a peer to join the broadcast tree of Origin and to gossip the
+// listed values, which may be none.
+type Graft struct {
+	maelstrom.MessageBody
+	Origin   string `json:"origin"`
+	Messages []int  `json:"messages,omitempty"`
+}
+
+func NewGraft(origin string, messages []int) Graft {
+	return Graft{MessageBody: body("graft"), Origin: origin, Messages: messages}
+}
+
+// Prune asks a peer to stop gossiping values of Origin eagerly to the sender.
+type Prune struct {
+	maelstrom.MessageBody
+	Origin string `json:"origin"`
+}
+
+func NewPrune(origin string) Prune {
+	return Prune{MessageBody: body("prune"), Origin: origin}
+}
@@ -0,0 +1,69 @@
+// Package schema holds typed bodies for every message the workloads send or
+// receive, and decode helpers that report malformed bodies as errors.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// validator is implemented by bodies with fields that must be present.
+type validator interface {
+	Validate() error
+}
+
+// requirer is implemented by bodies with fields whose zero value is valid,
+// so only their presence tells a missing field apart.
+type requirer interface {
+	Required() []string
+}
+
+// Decode unmarshals the body of msg into T and validates it. A malformed body
+// is returned as a MalformedRequest *maelstrom.RPCError, so a handler that
+// returns it replies with an error instead of crashing the node.
+func Decode[T any](msg maelstrom.Message) (T, error) {
+	var body T
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return body, maelstrom.NewRPCError(maelstrom.MalformedRequest, fmt.Sprintf("decode %s body: %v", msg.Type(), err))
+	}
+
+	if r, ok := any(&body).(requirer); ok {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(msg.Body, &fields); err != nil {
+			return body, maelstrom.NewRPCError(maelstrom.MalformedRequest, fmt.Sprintf("decode %s body: %v", msg.Type(), err))
+		}
+		for _, name := range r.Required() {
+			if raw, ok := fields[name]; !ok || string(raw) == "null" {
+				return body, maelstrom.NewRPCError(maelstrom.MalformedRequest, fmt.Sprintf("invalid %s body: missing %s", msg.Type(), name))
+			}
+		}
+	}
+
+	if v, ok := any(&body).(validator); ok {
+		if err := v.Validate(); err != nil {
+			return body, maelstrom.NewRPCError(maelstrom.MalformedRequest, fmt.Sprintf("invalid %s body: %v", msg.Type(), err))
+		}
+	}
+
+	return body, nil
+}
+
+// DecodeReply is Decode for an RPC response. It returns the error carried by
+// an error reply, and fails if the reply is not of type typ.
+func DecodeReply[T any](msg maelstrom.Message, typ string) (T, error) {
+	var body T
+	if err := msg.RPCError(); err != nil {
+		return body, err
+	}
+	if got := msg.Type(); got != typ {
+		return body, fmt.Errorf("unexpected reply type %q, want %q", got, typ)
+	}
+
+	return Decode[T](msg)
+}
+
+func body(typ string) maelstrom.MessageBody {
+	return maelstrom.MessageBody{Type: typ}
+}
@@ -0,0 +1,43 @@
+package schema_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/HdkTvd/advent-of-distributed-systems/schema"
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func message(body string) maelstrom.Message {
+	return maelstrom.Message{Src: "c1", Dest: "n0", Body: json.RawMessage(body)}
+}
+
+func TestDecodeRequiresFields(t *testing.T) {
+	for body, ok := range map[string]bool{
+		`{"type":"add","delta":0}`:                     true,
+		`{"type":"add"}`:                               false,
+		`{"type":"add","delta":null}`:                  false,
+		`{"type":"send","key":"k","msg":0}`:            true,
+		`{"type":"send","key":"k"}`:                    false,
+		`{"type":"send","msg":1}`:                      false,
+		`{"type":"broadcast","message":0}`:             true,
+		`{"type":"broadcast","receivers":{"n1":true}}`: false,
+	} {
+		var err error
+		switch msg := message(body); msg.Type() {
+		case "add":
+			_, err = schema.Decode[schema.Add](msg)
+		case "send":
+			_, err = schema.Decode[schema.Send](msg)
+		case "broadcast":
+			_, err = schema.Decode[schema.Broadcast](msg)
+		}
+
+		if ok && err != nil {
+			t.Errorf("Decode(%s) = %v, want no error", body, err)
+		}
+		if !ok && maelstrom.ErrorCode(err) != maelstrom.MalformedRequest {
+			t.Errorf("Decode(%s) = %v, want a malformed-request error", body, err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Package sim abstracts the clock and randomness workloads depend on, so the
+// same handlers can run against real time or inside a deterministic
+// simulation that replays a run from its seed.
+package sim
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Clock is the time source of a node. Code that should replay in a
+// simulation starts goroutines with Go and waits with Sleep, never with the
+// go statement or time.Sleep.
+type Clock interface {
+	Now() time.Time
+	// Sleep blocks the calling goroutine for d. In a simulation it must be
+	// called from a goroutine started with Go or AfterFunc, not directly
+	// from a message handler.
+	Sleep(d time.Duration)
+	// Go runs f concurrently with the caller.
+	Go(f func())
+	// AfterFunc runs f after d, like Go. The returned function cancels the
+	// call and reports whether it did so before f started.
+	AfterFunc(d time.Duration, f func()) (stop func() bool)
+}
+
+// Env is the clock and random source of a node.
+type Env struct {
+	Clock Clock
+	// Rand is safe for concurrent use.
+	Rand *rand.Rand
+}
+
+// Real is the wall clock.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time        { return time.Now() }
+func (realClock) Sleep(d time.Duration) { time.Sleep(d) }
+func (realClock) Go(f func())           { go f() }
+
+func (realClock) AfterFunc(d time.Duration, f func()) func() bool {
+	return time.AfterFunc(d, f).Stop
+}
+
+// NewRand returns a random source seeded with seed that is safe for
+// concurrent use.
+func NewRand(seed int64) *rand.Rand {
+	return rand.New(&lockedSource{src: rand.NewSource(seed).(rand.Source64)})
+}
+
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source64
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Uint64()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src.Seed(seed)
+}
+
+var (
+	envsMu sync.Mutex
+	envs   = make(map[*maelstrom.Node]Env)
+)
+
+// Attach makes env the environment For returns for n. Simulations attach
+// their env before running the workload's setup.
+func Attach(n *maelstrom.Node, env Env) {
+	envsMu.Lock()
+	envs[n] = env
+	envsMu.Unlock()
+}
+
+// Detach forgets the environment attached to n.
+func Detach(n *maelstrom.Node) {
+	envsMu.Lock()
+	delete(envs, n)
+	envsMu.Unlock()
+}
+
+// For returns the environment attached to n, or the real clock with a time
+// seeded random source.
+func For(n *maelstrom.Node) Env {
+	envsMu.Lock()
+	env, ok := envs[n]
+	envsMu.Unlock()
+
+	if ok {
+		return env
+	}
+	return Env{Clock: Real, Rand: NewRand(time.Now().UnixNano())}
+}
@@ -0,0 +1,239 @@
+// Package wal is an append-only log of JSON records on disk, synced in
+// batches, that a node replays after a restart.
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/HdkTvd/advent-of-distributed-systems/sim"
+)
+
+// DirEnv names the data directory when the package is loaded, as maelstrom
+// starts nodes without arguments.
+const DirEnv = "AODS_DATA_DIR"
+
+// SyncInterval is how long appends are collected before one fsync makes
+// them all durable.
+const SyncInterval = 10 * time.Millisecond
+
+var (
+	dirMu sync.Mutex
+	dir   = os.Getenv(DirEnv)
+)
+
+// SetDir changes the data directory, an empty one keeps state in memory only.
+func SetDir(d string) {
+	dirMu.Lock()
+	dir = d
+	dirMu.Unlock()
+}
+
+// Dir returns the data directory.
+func Dir() string {
+	dirMu.Lock()
+	defer dirMu.Unlock()
+	return dir
+}
+
+// Log appends records to a file. Records are written and synced together
+// every SyncInterval, and each append's callback runs once its record is
+// on disk.
+type Log struct {
+	mu     sync.Mutex
+	f      *os.File
+	clock  sim.Clock
+	logger *slog.Logger
+	// size is where the last good sync ended, a failed one is cut back to it
+	size    int64
+	buf     bytes.Buffer
+	waiters []func()
+	armed   bool
+	closed  bool
+}
+
+// Open opens the log at path for appending, creating it and its directory if
+// needed.
+func Open(path string, clock sim.Clock, logger *slog.Logger) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, errors.Join(err, f.Close())
+	}
+
+	return &Log{f: f, clock: clock, logger: logger, size: info.Size()}, nil
+}
+
+// Append queues rec for the next sync. done, if set, runs after the sync
+// from a clock goroutine. A record that fails to be written is kept for the
+// next sync, and done is not called if the log is closed before it succeeds.
+func (l *Log) Append(rec any, done func()) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.buf.Write(line)
+	l.buf.WriteByte('\n')
+	if done != nil {
+		l.waiters = append(l.waiters, done)
+	}
+
+	l.arm()
+	return nil
+}
+
+// arm schedules the next sync unless one is pending. Callers hold mu.
+func (l *Log) arm() {
+	if l.armed || l.closed {
+		return
+	}
+
+	l.armed = true
+	l.clock.AfterFunc(SyncInterval, func() {
+		if err := l.Sync(); err != nil {
+			l.logger.Error("Failed to sync the log", "path", l.f.Name(), "err", err)
+		}
+	})
+}
+
+// Sync writes and syncs the queued records, then runs their callbacks. If
+// that fails, the file is truncated back to the last good sync and the
+// records and their callbacks wait for the next one.
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	l.armed = false
+
+	if l.buf.Len() == 0 {
+		l.mu.Unlock()
+		return nil
+	}
+
+	// The lock is held until the records are on disk, so syncs never reorder them
+	_, err := l.f.Write(l.buf.Bytes())
+	if err == nil {
+		err = l.f.Sync()
+	}
+	if err != nil {
+		// A torn write would hide the records appended after it from a replay
+		err = errors.Join(err, l.f.Truncate(l.size))
+		l.arm()
+		l.mu.Unlock()
+		return err
+	}
+
+	l.size += int64(l.buf.Len())
+	waiters := l.waiters
+	l.buf.Reset()
+	l.waiters = nil
+	l.mu.Unlock()
+
+	for _, done := range waiters {
+		done()
+	}
+	return nil
+}
+
+// Close syncs the queued records and closes the file. Records that still
+// fail to be written are dropped with their callbacks.
+func (l *Log) Close() error {
+	err := l.Sync()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closed = true
+	l.buf.Reset()
+	l.waiters = nil
+	return errors.Join(err, l.f.Close())
+}
+
+// Replay calls fn with every record of the log at path, in order. A missing
+// log has no records. A torn last line, left by a crash in the middle of a
+// write, is ignored, so callers Rewrite the log before appending to it again.
+func Replay(path string, fn func(line []byte) error) error {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for lineNo := 1; ; lineNo++ {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := fn(line); err != nil {
+			return fmt.Errorf("replay %s line %d: %w", path, lineNo, err)
+		}
+	}
+}
+
+// Rewrite atomically replaces the log at path with recs, which compacts it
+// after a replay.
+func Rewrite(path string, recs []any) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, rec := range recs {
+		if err = enc.Encode(rec); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err = errors.Join(err, f.Close()); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	// The rename is only durable once the directory is synced
+	d, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
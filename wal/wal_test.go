@@ -0,0 +1,87 @@
+package wal
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/HdkTvd/advent-of-distributed-systems/sim"
+)
+
+// manualClock never fires timers, so records are only synced by the test.
+type manualClock struct {
+	sim.Clock
+}
+
+func (manualClock) AfterFunc(time.Duration, func()) func() bool {
+	return func() bool { return false }
+}
+
+func TestSyncKeepsRecordsAfterFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "n0.wal")
+	l, err := Open(path, manualClock{sim.Real}, slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.Append(1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Writes to a read-only handle fail like a full disk would
+	f := l.f
+	l.mu.Lock()
+	l.f, err = os.Open(path)
+	l.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	synced := 0
+	if err := l.Append(2, func() { synced++ }); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Sync(); err == nil {
+		t.Fatal("sync to a read-only file succeeded")
+	}
+	if synced != 0 {
+		t.Fatal("the callback ran although the record was not written")
+	}
+
+	l.mu.Lock()
+	l.f.Close()
+	l.f = f
+	l.mu.Unlock()
+
+	if err := l.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if synced != 1 {
+		t.Fatalf("the callback ran %d times after the record was written, want 1", synced)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var recs []int
+	if err := Replay(path, func(line []byte) error {
+		var rec int
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return err
+		}
+		recs = append(recs, rec)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1, 2}; !reflect.DeepEqual(recs, want) {
+		t.Fatalf("replayed %v, want %v", recs, want)
+	}
+}
@@ -0,0 +1,54 @@
+package workload
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Func registers the handlers of a challenge on n. The caller owns the node and
+// decides where it reads from and writes to, so the same workload runs under
+// maelstrom and in the local harness.
+type Func func(n *maelstrom.Node)
+
+var (
+	mu       sync.RWMutex
+	registry = make(map[string]Func)
+)
+
+// Register makes a workload available under name. It is meant to be called from
+// an init function of the challenge package and panics on duplicate names.
+func Register(name string, fn Func) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("duplicate workload %q", name))
+	}
+	registry[name] = fn
+}
+
+// Lookup returns the workload registered under name.
+func Lookup(name string) (Func, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	fn, ok := registry[name]
+	return fn, ok
+}
+
+// Names returns every registered workload name in sorted order.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}